@@ -7,28 +7,36 @@ import (
 	"errors"
 	"fmt"
 	"io"
+
+	"github.com/kxue43/cli-toolkit/secret"
 )
 
 type (
-	AesKey [32]byte
-
 	AesGcm struct {
-		key AesKey
+		key *secret.Buffer
 	}
 )
 
+// KeySize is the length of AES-256 keys in bytes.
+const KeySize = 32
+
 var (
 	ErrCipher = errors.New("cipher failure")
 )
 
-// Non-nil returned error wraps [ErrCipher].
-func NewAesGcm(key AesKey) *AesGcm {
+// NewAesGcm takes ownership of key, which should hold [KeySize] bytes.
+// Call Destroy to wipe key once the cipher is no longer needed.
+func NewAesGcm(key *secret.Buffer) *AesGcm {
 	return &AesGcm{key: key}
 }
 
+func (c *AesGcm) Destroy() {
+	c.key.Destroy()
+}
+
 // Non-nil returned error wraps [ErrCipher].
 func (c *AesGcm) Encrypt(plaintext []byte) ([]byte, error) {
-	block, err := aes.NewCipher(c.key[:])
+	block, err := aes.NewCipher(c.key.Bytes())
 	if err != nil {
 		return nil, fmt.Errorf("%w: failed to initialize AES block cipher: %s", ErrCipher, err.Error())
 	}
@@ -48,9 +56,10 @@ func (c *AesGcm) Encrypt(plaintext []byte) ([]byte, error) {
 	return gcm.Seal(nonce, nonce, plaintext, nil), nil
 }
 
+// The returned buffer holds the plaintext and should be destroyed by the caller after use.
 // Non-nil returned error wraps [ErrCipher].
-func (c *AesGcm) Decrypt(ciphertext []byte) ([]byte, error) {
-	block, err := aes.NewCipher(c.key[:])
+func (c *AesGcm) Decrypt(ciphertext []byte) (*secret.Buffer, error) {
+	block, err := aes.NewCipher(c.key.Bytes())
 	if err != nil {
 		return nil, fmt.Errorf("%w: failed to initialize AES block cipher: %s", ErrCipher, err.Error())
 	}
@@ -68,8 +77,16 @@ func (c *AesGcm) Decrypt(ciphertext []byte) ([]byte, error) {
 
 	nonce, ciphertextActual := ciphertext[:nonceSize], ciphertext[nonceSize:]
 
-	plaintext, err := gcm.Open(nil, nonce, ciphertextActual, nil)
-	if err != nil {
+	if len(ciphertextActual) < gcm.Overhead() {
+		return nil, fmt.Errorf("%w: ciphertext too short", ErrCipher)
+	}
+
+	// Open the ciphertext in place of the guarded buffer, so that the plaintext never lives elsewhere.
+	plaintext := secret.NewBuffer(len(ciphertextActual) - gcm.Overhead())
+
+	if _, err = gcm.Open(plaintext.Bytes()[:0], nonce, ciphertextActual, nil); err != nil {
+		plaintext.Destroy()
+
 		return nil, fmt.Errorf("%w: AES-GCM authentication failure, the data have been tampered: %s", ErrCipher, err.Error())
 	}
 
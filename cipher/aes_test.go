@@ -0,0 +1,63 @@
+package cipher
+
+import (
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kxue43/cli-toolkit/secret"
+)
+
+func newTestCipher(t *testing.T) *AesGcm {
+	t.Helper()
+
+	key := secret.NewBuffer(KeySize)
+
+	_, err := io.ReadFull(rand.Reader, key.Bytes())
+	require.NoError(t, err, "should be able to generate a random key")
+
+	return NewAesGcm(key)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	c := newTestCipher(t)
+	defer c.Destroy()
+
+	ciphertext, err := c.Encrypt([]byte("plaintext"))
+	require.NoError(t, err, "should be able to encrypt")
+
+	plaintext, err := c.Decrypt(ciphertext)
+	require.NoError(t, err, "should be able to decrypt")
+
+	assert.Equal(t, []byte("plaintext"), plaintext.Bytes())
+
+	p := plaintext.Bytes()
+
+	plaintext.Destroy()
+
+	assert.Equal(t, make([]byte, len(p)), p, "destroying the plaintext buffer should wipe it")
+
+	ciphertext[len(ciphertext)-1] ^= 0xff
+
+	_, err = c.Decrypt(ciphertext)
+	assert.ErrorIs(t, err, ErrCipher, "tampered ciphertext should fail authentication")
+
+	_, err = c.Decrypt(ciphertext[:5])
+	assert.ErrorIs(t, err, ErrCipher, "short ciphertext should be rejected")
+}
+
+func TestDestroy(t *testing.T) {
+	c := newTestCipher(t)
+
+	key := c.key.Bytes()
+
+	c.Destroy()
+
+	assert.Equal(t, make([]byte, KeySize), key, "destroying the cipher should wipe its key")
+
+	_, err := c.Encrypt([]byte("plaintext"))
+	assert.ErrorIs(t, err, ErrCipher, "a destroyed cipher should not be usable")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kxue43/cli-toolkit/creds"
+)
+
+var doctorHelpMsg = `Usage: %s doctor [flags]
+
+Check why credentials might not be cached, which shows as repeated MFA prompts.
+The keyring, the cache directory and its entries, the terminal, the AWS profile
+and the clock skew against STS are checked.
+
+Flags:
+`
+
+func runDoctor(args []string) int {
+	var input creds.ProcessInput
+
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+
+	flags.StringVar(&input.Profile, "profile", "", "Source profile to check.")
+	flags.StringVar(&input.Region, "region", "", "The regional STS service endpoint to call.")
+	flags.StringVar(&input.STSEndpoint, "sts-endpoint", "", "URL of a custom STS endpoint, e.g. a VPC endpoint.")
+	flags.StringVar(&input.CacheDir, "cache-dir", "", "Directory of cache files. Defaults to $TOOLKIT_CACHE_DIR, $XDG_CACHE_HOME/cli-toolkit or ~/.aws/toolkit-cache.")
+
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(flags.Output(), doctorHelpMsg, os.Args[0])
+
+		flags.PrintDefaults()
+	}
+
+	_ = flags.Parse(args)
+
+	doctor := creds.Doctor{
+		Input:       input,
+		KeyProvider: newKeyProvider(),
+		LoadConfig:  loadConfig,
+		TTYPath:     "/dev/tty",
+	}
+
+	exitCode := 0
+
+	for _, result := range doctor.Run(context.Background()) {
+		fmt.Printf("[%s] %s: %s\n", result.Status, result.Name, strings.ReplaceAll(result.Detail, "\n", "\n       "))
+
+		if result.Status == creds.CheckFail {
+			fmt.Printf("       fix: %s\n", result.Remedy)
+
+			exitCode = 1
+		}
+	}
+
+	return exitCode
+}
@@ -5,8 +5,14 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 
 	"github.com/kxue43/cli-toolkit/creds"
@@ -17,13 +23,29 @@ import (
 var (
 	input = creds.ProcessInput{}
 
+	noAgent bool
+
 	helpMsg = `Usage: %s -mfa-serial=STRING -profile=STRING [flags] <RoleArn>
+       %[1]s agent [flags]
+       %[1]s pick [flags]
+       %[1]s doctor [flags]
+       %[1]s stats [flags]
 
 Run AWS CLI credential process by assuming a role.
+If a credential agent is running, credentials are obtained from it.
 
 Arguments:
   <RoleArn>    ARN of the IAM role to assume.
 
+Flags:
+`
+
+	agentHelpMsg = `Usage: %s agent [flags]
+
+Run the credential agent in the foreground.
+The agent keeps an MFA session and refreshes the credentials of roles requested
+through it before they expire, so that MFA codes are needed only once per session.
+
 Flags:
 `
 )
@@ -31,9 +53,17 @@ Flags:
 func registerFlagsAndHelp() {
 	flag.StringVar(&input.MFASerial, "mfa-serial", "", "ARN of the virtual MFA to use when assuming the role.")
 	flag.StringVar(&input.Profile, "profile", "", "Source profile used for assuming the role.")
-	flag.StringVar(&input.Region, "region", "us-east-1", "The regional STS service endpoint to call.")
+	flag.StringVar(&input.Region, "region", "", "The regional STS service endpoint to call. Defaults to a region in the partition of <RoleArn>.")
+	flag.StringVar(&input.STSEndpoint, "sts-endpoint", "", "URL of a custom STS endpoint, e.g. a VPC endpoint.")
 	flag.StringVar(&input.RoleSessionName, "role-session-name", "ToolkitCLI", "Role session name.")
 	flag.Int64Var(&input.DurationSeconds, "duration-seconds", 3600, "Role session duration seconds.")
+	flag.IntVar(&input.MFAAttempts, "mfa-attempts", 3, "How many times to prompt for the MFA code if STS rejects it.")
+	flag.StringVar(&input.CacheDir, "cache-dir", "", "Directory of cache files. Defaults to $TOOLKIT_CACHE_DIR, $XDG_CACHE_HOME/cli-toolkit or ~/.aws/toolkit-cache.")
+	flag.IntVar(&input.CacheKeep, "cache-keep", 1, "How many live cache files to keep per identity. Only the latest is used.")
+	flag.StringVar(&input.TOTPSecretFile, "totp-secret-file", os.Getenv("TOOLKIT_TOTP_SECRET_FILE"),
+		"File holding the base32 seed of the virtual MFA device, so that MFA codes are generated instead of prompted for. Defaults to $TOOLKIT_TOTP_SECRET_FILE.")
+	flag.BoolVar(&input.Metrics, "metrics", false, "Record cache hits and misses and timings for the stats subcommand.")
+	flag.BoolVar(&noAgent, "no-agent", false, "Do not ask the credential agent for credentials.")
 
 	flag.Usage = func() {
 		_, _ = fmt.Fprintf(flag.CommandLine.Output(), helpMsg, os.Args[0])
@@ -42,24 +72,110 @@ func registerFlagsAndHelp() {
 	}
 }
 
-func validateInput(input creds.ProcessInput) error {
-	if input.MFASerial == "" {
-		return errors.New("-mfa-serial is required")
+func validateInput(input *creds.ProcessInput) error {
+	if err := input.Validate(); err != nil {
+		return err
 	}
 
-	if input.Profile == "" {
-		return errors.New("-profile is required")
+	return input.ResolveRegion()
+}
+
+// printError prints err to the TTY, followed by a hint on how to fix it if there is one.
+func printError(tty *terminal.TTY, err error) {
+	tty.Println(err.Error())
+
+	if hint := creds.Hint(err); hint != "" {
+		tty.Println(hint)
 	}
+}
+
+func loadConfig(ctx context.Context, input creds.ProcessInput) (aws.Config, error) {
+	return config.LoadDefaultConfig(ctx, config.WithSharedConfigProfile(input.Profile), config.WithRegion(input.Region))
+}
+
+func newKeyProvider() key.KeyringProvider {
+	return key.NewKeyringProvider("kxue43.toolkit.assume-role", "cache-encryption-key")
+}
 
-	if input.DurationSeconds > 14400 {
-		return errors.New("-duration-seconds cannot exceed 14400, i.e. 4 hours")
+// writeCredentials writes the credential process output for input to dest.
+// The credentials come from the credential agent if it's running, unless noAgent is set.
+func writeCredentials(ctx context.Context, tty *terminal.TTY, input creds.ProcessInput, noAgent bool, dest io.Writer) error {
+	if !noAgent {
+		if socketPath, err := creds.AgentSocketPath(input.CacheDir); err == nil {
+			err = creds.NewAgentClient(socketPath).Run(ctx, input, tty, dest)
+			if !errors.Is(err, creds.ErrAgentUnavailable) {
+				return err
+			}
+		}
 	}
 
-	if input.RoleArn == "" {
-		return errors.New("the <RoleArn> argument is required")
+	cfg, err := loadConfig(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS SDK configuration: %w", err)
 	}
 
-	return nil
+	processor := creds.NewProcessor(input, tty, cfg, newKeyProvider())
+	defer processor.Close()
+
+	return processor.Run(ctx, dest)
+}
+
+func runAgent(args []string) (exitCode int) {
+	var opts creds.AgentOptions
+
+	logger := log.New(os.Stderr, "toolkit-assume-role agent: ", log.LstdFlags)
+
+	flags := flag.NewFlagSet("agent", flag.ExitOnError)
+
+	flags.StringVar(&opts.TOTPSecretFile, "totp-secret-file", "", "File holding the base32 seed of the virtual MFA device. With it, MFA codes are never prompted for.")
+	flags.DurationVar(&opts.RefreshWindow, "refresh-window", 15*time.Minute, "Refresh role credentials once they expire within this window.")
+	flags.DurationVar(&opts.SessionDuration, "session-duration", 12*time.Hour, "Lifetime of the MFA session.")
+	flags.StringVar(&opts.CacheDir, "cache-dir", "", "Directory of cache files. Defaults to $TOOLKIT_CACHE_DIR, $XDG_CACHE_HOME/cli-toolkit or ~/.aws/toolkit-cache.")
+	flags.IntVar(&opts.CacheKeep, "cache-keep", 1, "How many live cache files to keep per identity. Only the latest is used.")
+
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(flags.Output(), agentHelpMsg, os.Args[0])
+
+		flags.PrintDefaults()
+	}
+
+	_ = flags.Parse(args)
+
+	socketPath, err := creds.AgentSocketPath(opts.CacheDir)
+	if err != nil {
+		logger.Println(err.Error())
+
+		return 1
+	}
+
+	agent, err := creds.NewAgent(logger, newKeyProvider(), loadConfig, opts)
+	if err != nil {
+		logger.Println(err.Error())
+
+		return 1
+	}
+
+	defer agent.Close()
+
+	l, err := creds.ListenAgent(socketPath)
+	if err != nil {
+		logger.Println(err.Error())
+
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.Printf("listening on %s\n", socketPath)
+
+	if err = agent.Serve(ctx, l); err != nil {
+		logger.Println(err.Error())
+
+		return 1
+	}
+
+	return 0
 }
 
 func main() {
@@ -67,16 +183,37 @@ func main() {
 
 	defer func() { os.Exit(exitCode) }()
 
-	device, err := os.OpenFile("/dev/tty", os.O_RDWR|os.O_SYNC, 0600)
-	if err != nil {
-		exitCode = 1
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "agent":
+			exitCode = runAgent(os.Args[2:])
 
-		return
+			return
+		case "pick":
+			exitCode = runPick(os.Args[2:])
+
+			return
+		case "doctor":
+			exitCode = runDoctor(os.Args[2:])
+
+			return
+		case "stats":
+			exitCode = runStats(os.Args[2:])
+
+			return
+		}
 	}
 
-	defer func() { _ = device.Close() }()
+	// Without a controlling terminal, cached credentials and TOTP secret files still work, and diagnostics go to stderr.
+	tty := terminal.NewHeadless(os.Stderr, "toolkit-assume-role: ", 0)
+
+	device, err := os.OpenFile("/dev/tty", os.O_RDWR|os.O_SYNC, 0600)
+	if err == nil {
+		defer func() { _ = device.Close() }()
+
+		tty = terminal.NewTTY(device, "toolkit-assume-role: ", 0)
+	}
 
-	tty := terminal.NewTTY(device, "toolkit-assume-role: ", 0)
 	defer func() {
 		if tty.FlushLogs() != nil {
 			exitCode = 1
@@ -91,7 +228,7 @@ func main() {
 		input.RoleArn = args[0]
 	}
 
-	err = validateInput(input)
+	err = validateInput(&input)
 	if err != nil {
 		tty.Println(err.Error())
 
@@ -100,24 +237,8 @@ func main() {
 		return
 	}
 
-	ctx := context.Background()
-
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithSharedConfigProfile(input.Profile), config.WithRegion(input.Region))
-	if err != nil {
-		tty.Printf("failed to load AWS SDK configuration: %s\n", err)
-
-		exitCode = 1
-
-		return
-	}
-
-	kp := key.NewKeyringProvider("kxue43.toolkit.assume-role", "cache-encryption-key")
-
-	processor := creds.NewProcessor(input, tty, cfg, kp)
-
-	err = processor.Run(ctx, os.Stdout)
-	if err != nil {
-		tty.Println(err.Error())
+	if err = writeCredentials(context.Background(), tty, input, noAgent, os.Stdout); err != nil {
+		printError(tty, err)
 
 		exitCode = 1
 
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/kxue43/cli-toolkit/creds"
+	"github.com/kxue43/cli-toolkit/secret"
+	"github.com/kxue43/cli-toolkit/terminal"
+	"github.com/kxue43/cli-toolkit/tui"
+)
+
+var pickHelpMsg = `Usage: %s pick [flags]
+
+Pick a role from the AWS config file, and optionally a team roles file, and assume it.
+By default, shell commands that export the credentials are printed, e.g. for
+  eval "$(toolkit-assume-role pick)"
+With -shell, a new shell is launched with the credentials instead.
+
+A team roles file is a TOML file with a [[role]] table per role, which has the keys
+name, role_arn, mfa_serial, source_profile, and optionally region, role_session_name
+and duration_seconds.
+
+Flags:
+`
+
+// credentialEnvVars are dropped from the environment of launched shells, so that they don't shadow the new credentials.
+var credentialEnvVars = []string{"AWS_PROFILE", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_CREDENTIAL_EXPIRATION"}
+
+func loadRoles(configFile, teamFile string) ([]creds.Role, error) {
+	roles, err := creds.LoadConfigRoles(configFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if teamFile != "" {
+		var teamRoles []creds.Role
+
+		if teamRoles, err = creds.LoadTeamRoles(teamFile); err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, teamRoles...)
+	}
+
+	return roles, nil
+}
+
+func credentialEnv(role creds.Role, input creds.ProcessInput, output *creds.ProcessOutput) []string {
+	return []string{
+		"AWS_ACCESS_KEY_ID=" + output.AccessKeyId,
+		"AWS_SECRET_ACCESS_KEY=" + output.SecretAccessKey,
+		"AWS_SESSION_TOKEN=" + output.SessionToken,
+		"AWS_CREDENTIAL_EXPIRATION=" + output.Expiration,
+		"AWS_REGION=" + input.Region,
+		"TOOLKIT_ROLE=" + role.Name,
+	}
+}
+
+func printExports(env []string) {
+	// A profile would take precedence over the exported credentials in some tools.
+	fmt.Println("unset AWS_PROFILE")
+
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+
+		fmt.Printf("export %s='%s'\n", k, strings.ReplaceAll(v, "'", `'\''`))
+	}
+}
+
+func launchShell(tty *terminal.TTY, env []string) int {
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+
+	environ := make([]string, 0, len(os.Environ())+len(env))
+
+	for _, kv := range os.Environ() {
+		if k, _, _ := strings.Cut(kv, "="); !slices.Contains(credentialEnvVars, k) {
+			environ = append(environ, kv)
+		}
+	}
+
+	cmd := exec.Command(shell)
+	cmd.Env = append(environ, env...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	_, _ = fmt.Fprintf(tty, "Starting %s with the role credentials. Exit the shell to drop them.\n", shell)
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode()
+		}
+
+		tty.Printf("failed to launch shell: %s\n", err)
+
+		return 1
+	}
+
+	return 0
+}
+
+func runPick(args []string) (exitCode int) {
+	var (
+		configFile  string
+		teamFile    string
+		cacheDir    string
+		shell       bool
+		noAgent     bool
+		mfaAttempts int
+	)
+
+	defaultConfigFile, _ := creds.ConfigFile()
+
+	flags := flag.NewFlagSet("pick", flag.ExitOnError)
+
+	flags.StringVar(&configFile, "config-file", defaultConfigFile, "AWS config file to read role profiles from.")
+	flags.StringVar(&teamFile, "team-file", os.Getenv("TOOLKIT_TEAM_ROLES"), "TOML file of roles shared by a team. Defaults to $TOOLKIT_TEAM_ROLES.")
+	flags.StringVar(&cacheDir, "cache-dir", "", "Directory of cache files. Defaults to $TOOLKIT_CACHE_DIR, $XDG_CACHE_HOME/cli-toolkit or ~/.aws/toolkit-cache.")
+	flags.BoolVar(&shell, "shell", false, "Launch a shell with the credentials instead of printing export commands.")
+	flags.BoolVar(&noAgent, "no-agent", false, "Do not ask the credential agent for credentials.")
+	flags.IntVar(&mfaAttempts, "mfa-attempts", 3, "How many times to prompt for the MFA code if STS rejects it.")
+
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(flags.Output(), pickHelpMsg, os.Args[0])
+
+		flags.PrintDefaults()
+	}
+
+	_ = flags.Parse(args)
+
+	device, err := os.OpenFile("/dev/tty", os.O_RDWR|os.O_SYNC, 0600)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to open terminal: %s\n", err)
+
+		return 1
+	}
+
+	defer func() { _ = device.Close() }()
+
+	tty := terminal.NewTTY(device, "toolkit-assume-role: ", 0)
+	defer func() {
+		if tty.FlushLogs() != nil {
+			exitCode = 1
+		}
+	}()
+
+	roles, err := loadRoles(configFile, teamFile)
+	if err != nil {
+		tty.Println(err.Error())
+
+		return 1
+	}
+
+	// Cache status is informational, so the picker goes on without it.
+	expirations, err := creds.CachedExpirations(tty, newKeyProvider(), cacheDir)
+	if err != nil {
+		tty.Println(err.Error())
+	}
+
+	role, err := tui.PickRole(device, device, roles, expirations)
+	if err != nil {
+		tty.Println(err.Error())
+
+		return 1
+	}
+
+	input := role.Input()
+	input.MFAAttempts = mfaAttempts
+	input.CacheDir = cacheDir
+
+	if err = validateInput(&input); err != nil {
+		tty.Println(err.Error())
+
+		return 1
+	}
+
+	var raw bytes.Buffer
+
+	defer func() { secret.Wipe(raw.Bytes()) }()
+
+	if err = writeCredentials(context.Background(), tty, input, noAgent, &raw); err != nil {
+		printError(tty, err)
+
+		return 1
+	}
+
+	var output creds.ProcessOutput
+
+	if err = json.Unmarshal(raw.Bytes(), &output); err != nil {
+		tty.Printf("invalid credential process output: %s\n", err)
+
+		return 1
+	}
+
+	env := credentialEnv(role, input, &output)
+
+	if shell {
+		return launchShell(tty, env)
+	}
+
+	printExports(env)
+
+	return 0
+}
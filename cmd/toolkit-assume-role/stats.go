@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kxue43/cli-toolkit/creds"
+)
+
+var statsHelpMsg = `Usage: %s stats [flags]
+
+Summarize the cache hits and misses and the timings recorded with -metrics.
+
+Flags:
+`
+
+func runStats(args []string) int {
+	var (
+		cacheDir    string
+		days        int
+		openMetrics bool
+	)
+
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+
+	flags.StringVar(&cacheDir, "cache-dir", "", "Directory of cache files. Defaults to $TOOLKIT_CACHE_DIR, $XDG_CACHE_HOME/cli-toolkit or ~/.aws/toolkit-cache.")
+	flags.IntVar(&days, "days", 7, "Number of past days to summarize. Samples older than 90 days may have been pruned.")
+	flags.BoolVar(&openMetrics, "openmetrics", false, "Print the summary in the OpenMetrics text format.")
+
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(flags.Output(), statsHelpMsg, os.Args[0])
+
+		flags.PrintDefaults()
+	}
+
+	_ = flags.Parse(args)
+
+	path, err := creds.MetricsFile(cacheDir)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to locate metrics file: %s\n", err)
+
+		return 1
+	}
+
+	summary, err := creds.SummarizeMetrics(path, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+
+		return 1
+	}
+
+	if openMetrics {
+		if err = summary.WriteOpenMetrics(os.Stdout); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "failed to write metrics: %s\n", err)
+
+			return 1
+		}
+
+		return 0
+	}
+
+	fmt.Printf("Since %s:\n", summary.Since.Format(time.DateTime))
+	fmt.Printf("  cache hits:        %d (%.0f%%)\n", summary.CacheHits, summary.HitRatio()*100)
+	fmt.Printf("  cache misses:      %d\n", summary.CacheMisses)
+	fmt.Printf("  cache evictions:   %d\n", summary.CacheEvictions)
+	fmt.Printf("  decrypt failures:  %d\n", summary.DecryptFailures)
+	printTiming("STS latency", summary.STSLatency)
+	printTiming("MFA prompt wait", summary.PromptWait)
+
+	return 0
+}
+
+func printTiming(name string, t creds.Timing) {
+	if t.Count == 0 {
+		fmt.Printf("  %-18s no samples\n", name+":")
+
+		return
+	}
+
+	fmt.Printf("  %-18s %d samples, p50 %s, p99 %s, max %s\n", name+":", t.Count,
+		t.P50.Round(time.Millisecond), t.P99.Round(time.Millisecond), t.Max.Round(time.Millisecond))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kong"
+
+	"github.com/kxue43/cli-toolkit/credhelper"
+	"github.com/kxue43/cli-toolkit/key"
+	"github.com/kxue43/cli-toolkit/securestore"
+)
+
+type (
+	gitCmd struct {
+		Operation string `arg:"" name:"operation" help:"One of get, store and erase. Other operations are ignored."`
+	}
+
+	dockerCmd struct {
+		Operation string `arg:"" name:"operation" enum:"get,store,erase,list" help:"One of get, store, erase and list."`
+	}
+
+	logger struct{}
+)
+
+func (logger) Printf(format string, v ...any) {
+	_, _ = fmt.Fprintf(os.Stderr, "toolkit-credential-helper: "+format, v...)
+}
+
+func (c *gitCmd) Run(h *credhelper.Helper) error {
+	return h.Git(c.Operation)
+}
+
+func (c *dockerCmd) Run(h *credhelper.Helper) error {
+	return h.Docker(c.Operation)
+}
+
+// args lets the program be symlinked as git-credential-<name> or docker-credential-<name>.
+func args() []string {
+	base := filepath.Base(os.Args[0])
+
+	switch {
+	case strings.HasPrefix(base, "git-credential-"):
+		return append([]string{"git"}, os.Args[1:]...)
+	case strings.HasPrefix(base, "docker-credential-"):
+		return append([]string{"docker"}, os.Args[1:]...)
+	default:
+		return os.Args[1:]
+	}
+}
+
+func main() {
+	var cli struct {
+		TTL      time.Duration `name:"ttl" default:"24h" help:"Forget secrets after this long."`
+		StoreDir string        `name:"store-dir" type:"path" help:"Directory of the encrypted secrets. Defaults to cli-toolkit/credential-helper in the user cache directory."`
+		KeyFile  string        `name:"key-file" type:"path" help:"Keep the encryption key in this file instead of the operating system's credentials store."`
+		Git      gitCmd        `cmd:"" name:"git" help:"Act as a git credential helper."`
+		Docker   dockerCmd     `cmd:"" name:"docker" help:"Act as a docker credential helper."`
+	}
+
+	parser := kong.Must(
+		&cli,
+		kong.Name("toolkit-credential-helper"),
+		kong.Description("Keep git and docker credentials encrypted on disk."),
+		kong.UsageOnError(),
+		kong.ConfigureHelp(kong.HelpOptions{Compact: true}),
+	)
+
+	ctx, err := parser.Parse(args())
+	parser.FatalIfErrorf(err)
+
+	if cli.StoreDir == "" {
+		var cacheDir string
+
+		cacheDir, err = os.UserCacheDir()
+		ctx.FatalIfErrorf(err, "could not locate user cache directory")
+
+		cli.StoreDir = filepath.Join(cacheDir, "cli-toolkit", "credential-helper")
+	}
+
+	var kp securestore.KeyProvider = key.NewKeyringProvider("kxue43.toolkit.credential-helper", "store-encryption-key")
+
+	if cli.KeyFile != "" {
+		kp = key.NewFileProvider(cli.KeyFile)
+	}
+
+	store, err := securestore.New(cli.StoreDir, kp, logger{})
+	ctx.FatalIfErrorf(err)
+
+	err = ctx.Run(credhelper.NewHelper(store, os.Stdin, os.Stdout, cli.TTL))
+
+	// FatalIfErrorf exits without running deferred calls, so the key is wiped first.
+	store.Close()
+
+	ctx.FatalIfErrorf(err)
+}
@@ -0,0 +1,394 @@
+// Package credhelper implements the git credential helper and the docker credential helper protocols.
+// Secrets are kept in a [securestore.Store], i.e. encrypted on disk and removed after they expire.
+package credhelper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kxue43/cli-toolkit/secret"
+	"github.com/kxue43/cli-toolkit/securestore"
+)
+
+type (
+	Helper struct {
+		store *securestore.Store
+		in    io.Reader
+		out   io.Writer
+		ttl   time.Duration
+	}
+
+	// gitAttrs holds the key-value pairs of the git credential helper protocol in their order of appearance.
+	gitAttrs struct {
+		keys   []string
+		values map[string]string
+	}
+
+	// DockerCredentials is the payload of the docker credential helper protocol.
+	DockerCredentials struct {
+		ServerURL string `json:"ServerURL"`
+		Username  string `json:"Username"`
+		Secret    string `json:"Secret"`
+	}
+)
+
+const (
+	gitNamePrefix    = "git:"
+	dockerNamePrefix = "docker:"
+)
+
+var (
+	// ErrDockerNotFound has the exact message that docker clients expect for missing credentials.
+	ErrDockerNotFound = errors.New("credentials not found in native keychain")
+
+	ErrUnknownOperation = errors.New("unknown operation")
+)
+
+// NewHelper returns a helper that reads requests from in and writes responses to out.
+// Secrets are kept for at most ttl.
+func NewHelper(store *securestore.Store, in io.Reader, out io.Writer, ttl time.Duration) *Helper {
+	return &Helper{store: store, in: in, out: out, ttl: ttl}
+}
+
+func newGitAttrs() *gitAttrs {
+	return &gitAttrs{values: make(map[string]string)}
+}
+
+func (a *gitAttrs) get(key string) string {
+	return a.values[key]
+}
+
+func (a *gitAttrs) set(key, value string) {
+	if _, ok := a.values[key]; !ok {
+		a.keys = append(a.keys, key)
+	}
+
+	a.values[key] = value
+}
+
+func (a *gitAttrs) WriteTo(w io.Writer) (n int64, err error) {
+	var m int
+
+	for _, key := range a.keys {
+		m, err = fmt.Fprintf(w, "%s=%s\n", key, a.values[key])
+		n += int64(m)
+
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// readGitAttrs reads key-value pairs until a blank line or the end of input.
+func readGitAttrs(r io.Reader) (*gitAttrs, error) {
+	attrs := newGitAttrs()
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid line %q: expected key=value", line)
+		}
+
+		attrs.set(key, value)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read git credential attributes: %w", err)
+	}
+
+	if raw := attrs.get("url"); raw != "" {
+		if err := attrs.setFromURL(raw); err != nil {
+			return nil, err
+		}
+	}
+
+	return attrs, nil
+}
+
+func (a *gitAttrs) setFromURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid url attribute %q: %w", raw, err)
+	}
+
+	a.set("protocol", u.Scheme)
+	a.set("host", u.Host)
+
+	if path := strings.TrimPrefix(u.Path, "/"); path != "" {
+		a.set("path", path)
+	}
+
+	if name := u.User.Username(); name != "" {
+		a.set("username", name)
+	}
+
+	return nil
+}
+
+// name identifies a git credential by protocol, host and path.
+// The username is stored in the secret so that a lookup without username still succeeds.
+func (a *gitAttrs) name() (string, error) {
+	if a.get("protocol") == "" || a.get("host") == "" {
+		return "", errors.New("both protocol and host attributes are required")
+	}
+
+	name := fmt.Sprintf("%s%s://%s", gitNamePrefix, a.get("protocol"), a.get("host"))
+
+	if path := a.get("path"); path != "" {
+		name += "/" + path
+	}
+
+	return name, nil
+}
+
+// Git runs one operation of the git credential helper protocol.
+// Unknown operations are ignored as the protocol requires.
+func (h *Helper) Git(operation string) error {
+	switch operation {
+	case "get":
+		return h.gitGet()
+	case "store":
+		return h.gitStore()
+	case "erase":
+		return h.gitErase()
+	default:
+		return nil
+	}
+}
+
+func (h *Helper) gitGet() error {
+	query, err := readGitAttrs(h.in)
+	if err != nil {
+		return err
+	}
+
+	name, err := query.name()
+	if err != nil {
+		return err
+	}
+
+	value, err := h.store.Get(name)
+	if errors.Is(err, securestore.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	defer value.Destroy()
+
+	stored, err := readGitAttrs(bytes.NewReader(value.Bytes()))
+	if err != nil {
+		return fmt.Errorf("stored git credential is corrupt: %w", err)
+	}
+
+	if username := query.get("username"); username != "" && username != stored.get("username") {
+		return nil
+	}
+
+	_, err = stored.WriteTo(h.out)
+
+	return err
+}
+
+func (h *Helper) gitStore() error {
+	attrs, err := readGitAttrs(h.in)
+	if err != nil {
+		return err
+	}
+
+	name, err := attrs.name()
+	if err != nil {
+		return err
+	}
+
+	if attrs.get("username") == "" || attrs.get("password") == "" {
+		return nil
+	}
+
+	var unixSec int64
+
+	expiration := time.Now().Add(h.ttl)
+
+	if raw := attrs.get("password_expiry_utc"); raw != "" {
+		unixSec, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid password_expiry_utc attribute %q: %w", raw, err)
+		}
+
+		if ts := time.Unix(unixSec, 0); ts.Before(expiration) {
+			expiration = ts
+		}
+	}
+
+	stored := newGitAttrs()
+
+	stored.set("username", attrs.get("username"))
+	stored.set("password", attrs.get("password"))
+	stored.set("password_expiry_utc", strconv.FormatInt(expiration.Unix(), 10))
+
+	var b strings.Builder
+
+	if _, err = stored.WriteTo(&b); err != nil {
+		return err
+	}
+
+	// Only the latest credential of a name is kept.
+	if err = h.store.Delete(name); err != nil {
+		return err
+	}
+
+	return h.store.PutUntil(name, []byte(b.String()), expiration)
+}
+
+func (h *Helper) gitErase() error {
+	attrs, err := readGitAttrs(h.in)
+	if err != nil {
+		return err
+	}
+
+	name, err := attrs.name()
+	if err != nil {
+		return err
+	}
+
+	return h.store.Delete(name)
+}
+
+// Docker runs one operation of the docker credential helper protocol.
+func (h *Helper) Docker(operation string) error {
+	switch operation {
+	case "get":
+		return h.dockerGet()
+	case "store":
+		return h.dockerStore()
+	case "erase":
+		return h.dockerErase()
+	case "list":
+		return h.dockerList()
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownOperation, operation)
+	}
+}
+
+func (h *Helper) readServerURL() (string, error) {
+	raw, err := io.ReadAll(h.in)
+	if err != nil {
+		return "", fmt.Errorf("failed to read server URL: %w", err)
+	}
+
+	serverURL := strings.TrimSpace(string(raw))
+	if serverURL == "" {
+		return "", errors.New("no server URL was given")
+	}
+
+	return serverURL, nil
+}
+
+func (h *Helper) dockerGet() error {
+	serverURL, err := h.readServerURL()
+	if err != nil {
+		return err
+	}
+
+	value, err := h.store.Get(dockerNamePrefix + serverURL)
+	if errors.Is(err, securestore.ErrNotFound) {
+		// Docker clients look for this exact message on stdout.
+		_, _ = fmt.Fprintln(h.out, ErrDockerNotFound.Error())
+
+		return ErrDockerNotFound
+	} else if err != nil {
+		return err
+	}
+
+	defer value.Destroy()
+
+	_, err = h.out.Write(value.Bytes())
+
+	return err
+}
+
+func (h *Helper) dockerStore() error {
+	var creds DockerCredentials
+
+	if err := json.NewDecoder(h.in).Decode(&creds); err != nil {
+		return fmt.Errorf("failed to decode docker credentials: %w", err)
+	}
+
+	if creds.ServerURL == "" {
+		return errors.New("no server URL was given")
+	}
+
+	value, err := json.Marshal(&creds)
+	if err != nil {
+		return fmt.Errorf("failed to encode docker credentials: %w", err)
+	}
+
+	name := dockerNamePrefix + creds.ServerURL
+
+	// Only the latest credential of a server is kept.
+	if err = h.store.Delete(name); err != nil {
+		return err
+	}
+
+	return h.store.Put(name, value, h.ttl)
+}
+
+func (h *Helper) dockerErase() error {
+	serverURL, err := h.readServerURL()
+	if err != nil {
+		return err
+	}
+
+	return h.store.Delete(dockerNamePrefix + serverURL)
+}
+
+func (h *Helper) dockerList() error {
+	entries, err := h.store.List()
+	if err != nil {
+		return err
+	}
+
+	var value *secret.Buffer
+
+	servers := make(map[string]string)
+
+	for _, entry := range entries {
+		serverURL, ok := strings.CutPrefix(entry.Name, dockerNamePrefix)
+		if !ok {
+			continue
+		}
+
+		if value, err = h.store.Get(entry.Name); err != nil {
+			continue
+		}
+
+		var creds DockerCredentials
+
+		err = json.Unmarshal(value.Bytes(), &creds)
+		value.Destroy()
+
+		if err != nil {
+			continue
+		}
+
+		servers[serverURL] = creds.Username
+	}
+
+	return json.NewEncoder(h.out).Encode(servers)
+}
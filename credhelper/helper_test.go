@@ -0,0 +1,114 @@
+package credhelper
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kxue43/cli-toolkit/securestore"
+	"github.com/kxue43/cli-toolkit/securestore/storetest"
+)
+
+func run(t *testing.T, store *securestore.Store, protocol, operation, input string) (string, error) {
+	t.Helper()
+
+	var out bytes.Buffer
+
+	h := NewHelper(store, strings.NewReader(input), &out, time.Hour)
+
+	var err error
+
+	if protocol == "git" {
+		err = h.Git(operation)
+	} else {
+		err = h.Docker(operation)
+	}
+
+	return out.String(), err
+}
+
+func TestGit(t *testing.T) {
+	store := storetest.NewStore(t)
+
+	out, err := run(t, store, "git", "get", "protocol=https\nhost=github.com\n\n")
+	require.NoError(t, err, "get should not fail when there is no credential")
+	assert.Empty(t, out, "get should output nothing when there is no credential")
+
+	_, err = run(t, store, "git", "store", "protocol=https\nhost=github.com\nusername=octocat\npassword=token-1\n\n")
+	require.NoError(t, err, "should be able to store a credential")
+
+	out, err = run(t, store, "git", "get", "protocol=https\nhost=github.com\n\n")
+	require.NoError(t, err, "should be able to get the stored credential")
+	assert.True(t, strings.HasPrefix(out, "username=octocat\npassword=token-1\npassword_expiry_utc="), "unexpected output %q", out)
+
+	out, err = run(t, store, "git", "get", "url=https://someone@github.com\n\n")
+	require.NoError(t, err)
+	assert.Empty(t, out, "get should not return the credential of another user")
+
+	_, err = run(t, store, "git", "erase", "protocol=https\nhost=github.com\nusername=octocat\n\n")
+	require.NoError(t, err, "should be able to erase the credential")
+
+	out, err = run(t, store, "git", "get", "protocol=https\nhost=github.com\n\n")
+	require.NoError(t, err)
+	assert.Empty(t, out, "get should output nothing after erase")
+
+	out, err = run(t, store, "git", "capability", "")
+	require.NoError(t, err, "unknown operations should be ignored")
+	assert.Empty(t, out)
+}
+
+func TestGitPasswordExpiry(t *testing.T) {
+	store := storetest.NewStore(t)
+
+	expiry := time.Now().Add(time.Minute).Unix()
+
+	_, err := run(t, store, "git", "store", "protocol=https\nhost=example.com\nusername=u\npassword=p\npassword_expiry_utc="+strconv.FormatInt(expiry, 10)+"\n")
+	require.NoError(t, err, "should be able to store a credential")
+
+	entries, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	assert.Equal(t, expiry, entries[0].Expiration.Unix(), "an earlier password_expiry_utc should take precedence over the TTL")
+}
+
+func TestDocker(t *testing.T) {
+	store := storetest.NewStore(t)
+
+	out, err := run(t, store, "docker", "get", "https://index.docker.io/v1/\n")
+	require.ErrorIs(t, err, ErrDockerNotFound, "get should fail when there is no credential")
+	assert.Equal(t, "credentials not found in native keychain\n", out)
+
+	input, err := json.Marshal(DockerCredentials{ServerURL: "https://index.docker.io/v1/", Username: "whale", Secret: "s3cr3t"})
+	require.NoError(t, err)
+
+	_, err = run(t, store, "docker", "store", string(input))
+	require.NoError(t, err, "should be able to store a credential")
+
+	out, err = run(t, store, "docker", "get", "https://index.docker.io/v1/\n")
+	require.NoError(t, err, "should be able to get the stored credential")
+
+	var creds DockerCredentials
+
+	require.NoError(t, json.Unmarshal([]byte(out), &creds))
+	assert.Equal(t, DockerCredentials{ServerURL: "https://index.docker.io/v1/", Username: "whale", Secret: "s3cr3t"}, creds)
+
+	out, err = run(t, store, "docker", "list", "")
+	require.NoError(t, err, "should be able to list credentials")
+	assert.JSONEq(t, `{"https://index.docker.io/v1/": "whale"}`, out)
+
+	_, err = run(t, store, "docker", "erase", "https://index.docker.io/v1/")
+	require.NoError(t, err, "should be able to erase the credential")
+
+	_, err = run(t, store, "docker", "get", "https://index.docker.io/v1/")
+	require.ErrorIs(t, err, ErrDockerNotFound, "get should fail after erase")
+
+	_, err = run(t, store, "docker", "version", "")
+	require.ErrorIs(t, err, ErrUnknownOperation)
+}
@@ -0,0 +1,552 @@
+package creds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+
+	"github.com/kxue43/cli-toolkit/secret"
+)
+
+type (
+	// ConfigLoader loads the AWS SDK configuration for the source profile and region of input.
+	ConfigLoader func(context.Context, ProcessInput) (aws.Config, error)
+
+	AgentOptions struct {
+		// TOTPSecretFile holds the base32 seed of the virtual MFA device. It is optional.
+		// With it, the agent never needs to ask for MFA codes.
+		TOTPSecretFile string
+		// Role credentials are refreshed once they are going to expire within RefreshWindow.
+		RefreshWindow time.Duration
+		// SessionDuration is the lifetime of the MFA session obtained from STS GetSessionToken.
+		SessionDuration time.Duration
+		// CacheDir is where credentials are cached. Empty means the default of [CacheDir].
+		CacheDir string
+		// CacheKeep is how many live cache files are kept per identity. Zero means one.
+		CacheKeep int
+	}
+
+	// Agent keeps role credentials in the cache fresh in the background.
+	// It holds an MFA authenticated session per source profile and MFA device, which it uses to assume roles without prompting.
+	Agent struct {
+		logger     logger
+		cacher     *cacher
+		loadConfig ConfigLoader
+		totpSecret *secret.Buffer
+		sessions   map[string]aws.Credentials
+		watched    map[string]*watchedRole
+		opts       AgentOptions
+		mux        sync.Mutex // Guards sessions and watched, and serializes calls to STS
+	}
+
+	watchedRole struct {
+		expiration time.Time
+		input      ProcessInput
+	}
+
+	// AgentClient asks a running [Agent] for credentials over its Unix socket.
+	AgentClient struct {
+		socketPath string
+	}
+
+	agentRequest struct {
+		Action    string       `json:"Action"`
+		TokenCode string       `json:"TokenCode,omitempty"`
+		Input     ProcessInput `json:"Input"`
+	}
+
+	agentResponse struct {
+		Output  json.RawMessage `json:"Output,omitempty"`
+		Error   string          `json:"Error,omitempty"`
+		Kind    string          `json:"Kind,omitempty"`
+		NeedMFA bool            `json:"NeedMFA,omitempty"`
+		// Cached tells clients that Output came from the cache, for their metrics.
+		Cached bool `json:"Cached,omitempty"`
+	}
+)
+
+const (
+	agentActionCredentials = "credentials"
+	agentActionMFA         = "mfa"
+
+	// agentTimeout bounds a single exchange over the socket, which may include calls to STS.
+	agentTimeout = time.Minute
+
+	agentRefreshInterval = time.Minute
+)
+
+var (
+	ErrAgentUnavailable = errors.New("credential agent is unavailable")
+
+	errNeedMFA = errors.New("an MFA code is needed")
+
+	// agentErrorKinds are typed errors that survive the trip from the agent to its clients.
+	agentErrorKinds = []error{ErrInvalidInput, ErrPartitionMismatch, ErrAccessDenied, ErrMFACodeReused, ErrMFACodeInvalid, ErrMaxSessionDuration}
+)
+
+// AgentSocketPath returns the path of the agent's Unix socket in cacheDir, or in the default of [CacheDir] if it's empty.
+// The socket lives next to the cache files, so that an agent only serves clients that use its cache directory.
+func AgentSocketPath(cacheDir string) (string, error) {
+	dir, _, err := resolveCacheDir(cacheDir)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "agent.sock"), nil
+}
+
+// ListenAgent listens on the Unix socket at socketPath, replacing a stale socket file left behind by a dead agent.
+func ListenAgent(socketPath string) (net.Listener, error) {
+	if conn, err := net.DialTimeout("unix", socketPath, time.Second); err == nil {
+		_ = conn.Close()
+
+		return nil, fmt.Errorf("another agent is already listening on %q", socketPath)
+	}
+
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale socket %q: %w", socketPath, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0750); err != nil {
+		return nil, fmt.Errorf("failed to create directory for socket %q: %w", socketPath, err)
+	}
+
+	l, err := listenPrivate(socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %q: %w", socketPath, err)
+	}
+
+	return l, nil
+}
+
+// Non-nil returned error wraps [ErrCacheInit] if the cache is unusable, because the agent is pointless without it.
+func NewAgent(logger logger, kp KeyProvider, loadConfig ConfigLoader, opts AgentOptions) (*Agent, error) {
+	if opts.SessionDuration < 15*time.Minute || opts.SessionDuration > 36*time.Hour {
+		return nil, fmt.Errorf("session duration %s is not between 15 minutes and 36 hours", opts.SessionDuration)
+	}
+
+	if opts.CacheKeep < 0 {
+		return nil, fmt.Errorf("cache keep %d is negative", opts.CacheKeep)
+	}
+
+	c, err := newCacher(logger, kp, opts.CacheDir, opts.CacheKeep)
+	if err != nil {
+		return nil, err
+	}
+
+	a := Agent{
+		logger:     logger,
+		cacher:     c,
+		loadConfig: loadConfig,
+		sessions:   make(map[string]aws.Credentials),
+		watched:    make(map[string]*watchedRole),
+		opts:       opts,
+	}
+
+	if opts.TOTPSecretFile != "" {
+		a.totpSecret, err = readTOTPSecret(opts.TOTPSecretFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &a, nil
+}
+
+// Serve answers clients on l and refreshes watched credentials until ctx is done.
+func (a *Agent) Serve(ctx context.Context, l net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+
+	defer wg.Wait()
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		a.refreshLoop(ctx)
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			a.handle(ctx, conn)
+		}()
+	}
+}
+
+// Close wipes the agent's secrets. The agent must not be used afterwards.
+func (a *Agent) Close() {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	a.totpSecret.Destroy()
+	a.cacher.store.Close()
+	clear(a.sessions)
+}
+
+func (a *Agent) handle(ctx context.Context, conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	_ = conn.SetDeadline(time.Now().Add(agentTimeout))
+
+	var (
+		req  agentRequest
+		resp agentResponse
+	)
+
+	err := json.NewDecoder(conn).Decode(&req)
+	if err != nil {
+		resp.Error = fmt.Sprintf("invalid request: %s", err)
+	} else {
+		var output *secret.Buffer
+
+		output, resp.Cached, err = a.credentials(ctx, req)
+		defer output.Destroy()
+
+		switch {
+		case errors.Is(err, errNeedMFA):
+			resp.NeedMFA = true
+		case err != nil:
+			resp.Error = err.Error()
+			resp.Kind = agentErrorKind(err)
+		default:
+			resp.Output = output.Bytes()
+		}
+	}
+
+	if err = json.NewEncoder(conn).Encode(&resp); err != nil {
+		a.logger.Printf("failed to respond to client: %s\n", err)
+	}
+}
+
+func agentErrorKind(err error) string {
+	for _, kind := range agentErrorKinds {
+		if errors.Is(err, kind) {
+			return kind.Error()
+		}
+	}
+
+	return ""
+}
+
+// credentials answers req. cached reports whether the returned output came from the cache.
+func (a *Agent) credentials(ctx context.Context, req agentRequest) (output *secret.Buffer, cached bool, err error) {
+	if err = req.Input.Validate(); err != nil {
+		return nil, false, err
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	switch req.Action {
+	case agentActionCredentials:
+		if output = a.cacher.retrieve(cacheKey(req.Input)); output != nil {
+			if expiration, err := outputExpiration(output.Bytes()); err == nil {
+				a.watch(req.Input, expiration)
+			}
+
+			return output, true, nil
+		}
+
+		output, err = a.assume(ctx, req.Input, "")
+
+		return output, false, err
+	case agentActionMFA:
+		if req.TokenCode == "" {
+			return nil, false, errors.New("no MFA code was given")
+		}
+
+		output, err = a.assume(ctx, req.Input, req.TokenCode)
+
+		return output, false, err
+	default:
+		return nil, false, fmt.Errorf("unknown action %q", req.Action)
+	}
+}
+
+func (a *Agent) watch(input ProcessInput, expiration time.Time) {
+	a.watched[cacheKey(input)] = &watchedRole{input: input, expiration: expiration}
+}
+
+func sessionKey(input ProcessInput) string {
+	return input.Profile + "\x00" + input.MFASerial
+}
+
+// assume assumes the role of input with the MFA session, after starting a new session with code if necessary.
+// It must be called with a.mux held.
+// The returned error wraps errNeedMFA if there is no usable session and no MFA code.
+func (a *Agent) assume(ctx context.Context, input ProcessInput, code string) (*secret.Buffer, error) {
+	cfg, err := a.loadConfig(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS SDK configuration: %w", err)
+	}
+
+	key := sessionKey(input)
+
+	session, ok := a.sessions[key]
+	if !ok || session.Expires.Before(a.cacher.store.Now().Add(time.Minute)) {
+		if code == "" && a.totpSecret != nil {
+			code = totpCode(a.totpSecret.Bytes(), a.cacher.store.Now(), 6)
+		}
+
+		if code == "" {
+			return nil, errNeedMFA
+		}
+
+		session, err = a.newSession(ctx, cfg, input, code)
+		if err != nil {
+			return nil, err
+		}
+
+		a.sessions[key] = session
+	}
+
+	client := newSTSClient(cfg, input, a.cacher.recordSkew(a.logger), func(o *sts.Options) {
+		o.Credentials = credentials.StaticCredentialsProvider{Value: session}
+	})
+
+	stsCreds, err := stscreds.NewAssumeRoleProvider(client, input.RoleArn, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = input.RoleSessionName
+		o.Duration = time.Second * time.Duration(input.DurationSeconds)
+	}).Retrieve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to assume role with MFA session: %w", classifySTSError(err))
+	}
+
+	soutput := ProcessOutput{
+		AccessKeyId:     stsCreds.AccessKeyID,
+		SecretAccessKey: stsCreds.SecretAccessKey,
+		SessionToken:    stsCreds.SessionToken,
+		Expiration:      stsCreds.Expires.Format(time.RFC3339),
+		Version:         1,
+	}
+
+	output, err := a.cacher.save(cacheKey(input), &soutput)
+	if errors.Is(err, ErrInvalidCredential) {
+		return nil, err
+	} else if err != nil {
+		a.logger.Println(err.Error())
+	}
+
+	a.watch(input, stsCreds.Expires)
+
+	return output, nil
+}
+
+func (a *Agent) newSession(ctx context.Context, cfg aws.Config, input ProcessInput, code string) (aws.Credentials, error) {
+	resp, err := newSTSClient(cfg, input, a.cacher.recordSkew(a.logger)).GetSessionToken(ctx, &sts.GetSessionTokenInput{
+		DurationSeconds: aws.Int32(int32(a.opts.SessionDuration / time.Second)),
+		SerialNumber:    aws.String(input.MFASerial),
+		TokenCode:       aws.String(code),
+	})
+	if err != nil {
+		return aws.Credentials{}, fmt.Errorf("failed to start MFA session: %w", classifySTSError(err))
+	}
+
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(resp.Credentials.AccessKeyId),
+		SecretAccessKey: aws.ToString(resp.Credentials.SecretAccessKey),
+		SessionToken:    aws.ToString(resp.Credentials.SessionToken),
+		Source:          "toolkit-agent",
+		CanExpire:       true,
+		Expires:         aws.ToTime(resp.Credentials.Expiration),
+	}, nil
+}
+
+func (a *Agent) refreshLoop(ctx context.Context) {
+	ticker := time.NewTicker(agentRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.refresh(ctx, now)
+		}
+	}
+}
+
+// refresh renews watched credentials that are going to expire within the refresh window.
+// Roles that cannot be renewed without an MFA code are no longer watched until a client asks for them again.
+func (a *Agent) refresh(ctx context.Context, now time.Time) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	for key, role := range a.watched {
+		if role.expiration.After(now.Add(a.opts.RefreshWindow - a.cacher.store.Skew)) {
+			continue
+		}
+
+		output, err := a.assume(ctx, role.input, "")
+		if errors.Is(err, errNeedMFA) {
+			a.logger.Printf("stop refreshing %s because the MFA session has expired\n", role.input.RoleArn)
+			delete(a.watched, key)
+
+			continue
+		} else if err != nil {
+			a.logger.Printf("failed to refresh %s: %s\n", role.input.RoleArn, err)
+
+			continue
+		}
+
+		output.Destroy()
+	}
+}
+
+func NewAgentClient(socketPath string) AgentClient {
+	return AgentClient{socketPath: socketPath}
+}
+
+// Run asks the agent for credentials and writes them to dest, prompting for an MFA code through tty if the agent needs one.
+// If input.Metrics is set, cache hits and misses and MFA prompts are recorded in the cache directory of input.
+// The calls to STS are made by the agent, so they are not timed.
+// Non-nil returned error wraps [ErrAgentUnavailable] if the agent cannot be reached.
+func (c AgentClient) Run(ctx context.Context, input ProcessInput, tty Terminal, dest io.Writer) error {
+	resp, err := c.call(ctx, agentRequest{Action: agentActionCredentials, Input: input})
+	if err != nil {
+		return err
+	}
+
+	var metrics *metricsRecorder
+
+	if input.Metrics {
+		if path, err1 := MetricsFile(input.CacheDir); err1 == nil {
+			metrics = newMetricsRecorder(tty, path)
+		}
+	}
+
+	if resp.NeedMFA {
+		if resp, err = c.runMFA(ctx, input, tty, metrics); err != nil {
+			return err
+		}
+	}
+
+	defer secret.Wipe(resp.Output)
+
+	if err = resp.err(); err != nil {
+		return err
+	} else if len(resp.Output) == 0 {
+		return errors.New("credential agent returned no credentials")
+	}
+
+	if resp.Cached {
+		metrics.event(metricCacheHit)
+	} else {
+		metrics.event(metricCacheMiss)
+	}
+
+	if _, err = dest.Write(resp.Output); err != nil {
+		return fmt.Errorf("failed to write credentials to destination: %w", err)
+	}
+
+	return nil
+}
+
+// runMFA sends MFA codes to the agent until it accepts one or input.MFAAttempts codes have been tried.
+// Rejected codes are retried in the same way as by [Processor].
+func (c AgentClient) runMFA(ctx context.Context, input ProcessInput, tty Terminal, metrics *metricsRecorder) (*agentResponse, error) {
+	retrier := newMFARetrier(input, tty)
+
+	token := retrier.track(mfaTokenProvider(input, tty, time.Now))
+	if metrics != nil && input.TOTPSecretFile == "" {
+		token = metrics.timePrompt(token)
+	}
+
+	for attempt := 1; ; attempt++ {
+		code, err := token()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.call(ctx, agentRequest{Action: agentActionMFA, Input: input, TokenCode: code})
+		if err != nil {
+			return nil, err
+		}
+
+		if err = resp.err(); err == nil {
+			return resp, nil
+		}
+
+		if err = retrier.retry(ctx, err, attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// err rebuilds the error of the agent, wrapping the same typed error as on the agent's side.
+func (resp *agentResponse) err() error {
+	if resp.Error == "" {
+		return nil
+	}
+
+	for _, kind := range agentErrorKinds {
+		if kind.Error() == resp.Kind {
+			return fmt.Errorf("%w: credential agent failed: %s", kind, resp.Error)
+		}
+	}
+
+	return fmt.Errorf("credential agent failed: %s", resp.Error)
+}
+
+func (c AgentClient) call(ctx context.Context, req agentRequest) (*agentResponse, error) {
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "unix", c.socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrAgentUnavailable, err.Error())
+	}
+
+	defer func() { _ = conn.Close() }()
+
+	_ = conn.SetDeadline(time.Now().Add(agentTimeout))
+
+	if err = json.NewEncoder(conn).Encode(&req); err != nil {
+		return nil, fmt.Errorf("failed to send request to credential agent: %w", err)
+	}
+
+	var resp agentResponse
+
+	if err = json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to read response from credential agent: %w", err)
+	}
+
+	return &resp, nil
+}
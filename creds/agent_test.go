@@ -0,0 +1,251 @@
+package creds
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/aws/smithy-go"
+	"github.com/awsdocs/aws-doc-sdk-examples/gov2/testtools"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kxue43/cli-toolkit/securestore/storetest"
+)
+
+type (
+	// LoggingTerminal adds a logger to a MockTerminal, so that it's a Terminal.
+	LoggingTerminal struct {
+		*MockTerminal
+		storetest.DiscardLogger
+	}
+
+	// scriptedLoggingTerminal adds a logger to a scriptedTerminal, so that it's a Terminal.
+	scriptedLoggingTerminal struct {
+		*scriptedTerminal
+		storetest.DiscardLogger
+	}
+)
+
+func TestTOTPCode(t *testing.T) {
+	// Test vectors of RFC 6238, Appendix B, for HMAC-SHA1.
+	key := []byte("12345678901234567890")
+
+	tests := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+
+	for unixSec, expected := range tests {
+		assert.Equal(t, expected, totpCode(key, time.Unix(unixSec, 0), 8), "TOTP code at %d", unixSec)
+	}
+
+	assert.Equal(t, "287082", totpCode(key, time.Unix(59, 0), 6), "6-digit codes should be the last 6 digits")
+}
+
+func TestReadTOTPSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "totp")
+
+	// base32 of "12345678901234567890" in groups of four and lowercase
+	require.NoError(t, os.WriteFile(path, []byte("gezd gnbv gy3t qojq gezd gnbv gy3t qojq\n"), 0600))
+
+	seed, err := readTOTPSecret(path)
+	require.NoError(t, err, "should be able to read the TOTP secret")
+
+	defer seed.Destroy()
+
+	assert.Equal(t, []byte("12345678901234567890"), seed.Bytes())
+
+	require.NoError(t, os.Chmod(path, 0644))
+
+	_, err = readTOTPSecret(path)
+	assert.Error(t, err, "a TOTP secret file readable by others should be rejected")
+}
+
+func TestAgent(t *testing.T) {
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+
+	t.Setenv("TOOLKIT_CACHE_DIR", cacheDir)
+
+	kp := storetest.KeyProvider(t)
+
+	stubber := testtools.NewStubber()
+	defer testtools.ExitTest(stubber, t)
+
+	loadConfig := func(context.Context, ProcessInput) (aws.Config, error) {
+		return *stubber.SdkConfig, nil
+	}
+
+	agent, err := NewAgent(storetest.DiscardLogger{}, kp, loadConfig, AgentOptions{RefreshWindow: 15 * time.Minute, SessionDuration: 12 * time.Hour})
+	require.NoError(t, err, "should be able to create an agent")
+
+	defer agent.Close()
+
+	socketPath, err := AgentSocketPath("")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(cacheDir, "agent.sock"), socketPath, "the socket should be in the cache directory")
+
+	otherDir := filepath.Join(t.TempDir(), "other")
+
+	otherPath, err := AgentSocketPath(otherDir)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(otherDir, "agent.sock"), otherPath, "agents with other cache directories should have other sockets")
+
+	l, err := ListenAgent(socketPath)
+	require.NoError(t, err, "should be able to listen on a Unix socket")
+
+	info, err := os.Stat(socketPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "only the current user should be able to connect to the agent")
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		assert.NoError(t, agent.Serve(ctx, l), "the agent should stop serving without error")
+	}()
+
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	input := ProcessInput{
+		RoleArn:         "arn:aws:iam::123456789012:role/admin",
+		MFASerial:       "arn:aws:iam::123456789012:mfa/me",
+		Profile:         "profile",
+		Region:          "us-east-1",
+		RoleSessionName: "ToolkitCLI",
+		DurationSeconds: 3600,
+		MFAAttempts:     3,
+		Metrics:         true,
+	}
+
+	token := "123456"
+
+	var (
+		duration        int32 = 3600
+		sessionDuration int32 = 12 * 3600
+	)
+
+	sessionExpiration := time.Now().Add(12 * time.Hour)
+
+	addAssumeRoleStub := func(accessKeyId string, expiration time.Time) {
+		stubber.Add(testtools.Stub{
+			OperationName: "AssumeRole",
+			Input: &sts.AssumeRoleInput{
+				DurationSeconds: &duration,
+				RoleArn:         &input.RoleArn,
+				RoleSessionName: &input.RoleSessionName,
+			},
+			Output: &sts.AssumeRoleOutput{
+				Credentials: &types.Credentials{
+					AccessKeyId:     aws.String(accessKeyId),
+					SecretAccessKey: aws.String("secret-access-key"),
+					SessionToken:    aws.String("session-token"),
+					Expiration:      &expiration,
+				},
+			},
+		})
+	}
+
+	client := NewAgentClient(socketPath)
+
+	t.Run("MFA code is prompted for once", func(t *testing.T) {
+		invalid := &smithy.GenericAPIError{Code: "AccessDenied", Message: "MultiFactorAuthentication failed with invalid MFA one time pass code."}
+
+		stubber.Add(testtools.Stub{OperationName: "GetSessionToken", Error: &testtools.StubError{Err: invalid, ContinueAfter: true}})
+		stubber.Add(testtools.Stub{
+			OperationName: "GetSessionToken",
+			Input: &sts.GetSessionTokenInput{
+				DurationSeconds: &sessionDuration,
+				SerialNumber:    &input.MFASerial,
+				TokenCode:       &token,
+			},
+			Output: &sts.GetSessionTokenOutput{
+				Credentials: &types.Credentials{
+					AccessKeyId:     aws.String("session-access-key-id"),
+					SecretAccessKey: aws.String("session-secret-access-key"),
+					SessionToken:    aws.String("session-session-token"),
+					Expiration:      &sessionExpiration,
+				},
+			},
+		})
+
+		addAssumeRoleStub("access-key-id-1", time.Now().Add(time.Hour))
+
+		term := &scriptedTerminal{lines: []string{"111111", token}}
+		dest := MockTerminal{}
+
+		err := client.Run(ctx, input, scriptedLoggingTerminal{scriptedTerminal: term}, &dest)
+		require.NoError(t, err, "should be able to get credentials from the agent after a rejected MFA code")
+
+		assert.Equal(t, "MFA code: MFA code is invalid. 2 attempt(s) left.\nMFA code: ", term.w.String(), "the MFA code should have been prompted for again after it was rejected")
+
+		var output ProcessOutput
+
+		require.NoError(t, json.Unmarshal(dest.w.Bytes(), &output))
+		assert.Equal(t, "access-key-id-1", output.AccessKeyId)
+
+		mockedTerminal := &MockTerminal{}
+		dest = MockTerminal{}
+
+		err = client.Run(ctx, input, LoggingTerminal{MockTerminal: mockedTerminal}, &dest)
+		require.NoError(t, err, "should be able to get cached credentials from the agent")
+
+		assert.Empty(t, mockedTerminal.w.String(), "the MFA code should not be prompted for again")
+
+		require.NoError(t, json.Unmarshal(dest.w.Bytes(), &output))
+		assert.Equal(t, "access-key-id-1", output.AccessKeyId)
+	})
+
+	t.Run("Credentials are refreshed with the MFA session", func(t *testing.T) {
+		addAssumeRoleStub("access-key-id-2", time.Now().Add(2*time.Hour))
+
+		agent.refresh(ctx, time.Now().Add(50*time.Minute))
+
+		mockedTerminal := &MockTerminal{}
+		dest := MockTerminal{}
+
+		err := client.Run(ctx, input, LoggingTerminal{MockTerminal: mockedTerminal}, &dest)
+		require.NoError(t, err, "should be able to get refreshed credentials from the agent")
+
+		var output ProcessOutput
+
+		require.NoError(t, json.Unmarshal(dest.w.Bytes(), &output))
+		assert.Equal(t, "access-key-id-2", output.AccessKeyId, "the refreshed credentials should be handed out")
+	})
+
+	t.Run("Metrics are recorded by clients", func(t *testing.T) {
+		path, err := MetricsFile(input.CacheDir)
+		require.NoError(t, err)
+
+		summary, err := SummarizeMetrics(path, time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, summary.CacheMisses, "credentials obtained from STS by the agent should be cache misses")
+		assert.Equal(t, 2, summary.CacheHits, "credentials served from the agent's cache should be cache hits")
+		assert.Equal(t, 2, summary.PromptWait.Count, "the MFA prompts should be timed")
+	})
+
+	t.Run("Unavailable agent", func(t *testing.T) {
+		err := NewAgentClient(filepath.Join(cacheDir, "missing.sock")).Run(ctx, input, LoggingTerminal{MockTerminal: &MockTerminal{}}, &MockTerminal{})
+		assert.ErrorIs(t, err, ErrAgentUnavailable)
+	})
+}
@@ -1,34 +1,33 @@
 package creds
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
-	"sort"
-	"strconv"
+	"strings"
 	"time"
 
-	"github.com/kxue43/cli-toolkit/cipher"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+
+	"github.com/kxue43/cli-toolkit/secret"
+	"github.com/kxue43/cli-toolkit/securestore"
 )
 
 type (
 	cacher struct {
-		logger   logger
-		cipher   *cipher.AesGcm
-		cacheDir string
+		store   *securestore.Store
+		metrics *metricsRecorder
 	}
 
-	cacheFile struct {
-		expiration time.Time
-		filePath   string
+	// skewRecorder measures how far the local clock is off from the Date headers of STS responses.
+	skewRecorder struct {
+		client sts.HTTPClient
+		record func(time.Duration)
 	}
-
-	cacheFileSlice []*cacheFile
 )
 
 var (
@@ -37,168 +36,251 @@ var (
 	ErrInvalidCredential = errors.New("invalid AWS credential")
 )
 
-func (cs cacheFileSlice) Len() int {
-	return len(cs)
-}
-
-func (cs cacheFileSlice) Less(i, j int) bool {
-	return cs[i].expiration.Before(cs[j].expiration)
-}
+// CacheDir returns the directory of cache files, in the order of
+// $TOOLKIT_CACHE_DIR, $XDG_CACHE_HOME/cli-toolkit and ~/.aws/toolkit-cache.
+func CacheDir() (string, error) {
+	dir, _, err := resolveCacheDir("")
 
-func (cs cacheFileSlice) Swap(i, j int) {
-	cs[i], cs[j] = cs[j], cs[i]
+	return dir, err
 }
 
-func getPrefix(s string) string {
-	h := sha1.Sum([]byte(s))
+// resolveCacheDir returns dir if it's given, and the default of [CacheDir] otherwise.
+// migrate reports whether cache files in the legacy directory should be moved into the returned one.
+// This is only the case when the default moved because of XDG_CACHE_HOME, since explicitly configured directories start empty.
+func resolveCacheDir(dir string) (resolved string, migrate bool, err error) {
+	if dir != "" {
+		return dir, false, nil
+	}
 
-	return hex.EncodeToString(h[:])[0:7]
-}
+	if dir = os.Getenv("TOOLKIT_CACHE_DIR"); dir != "" {
+		return dir, false, nil
+	}
 
-func encodeToFileName(roleArn string, ts time.Time) string {
-	return fmt.Sprintf("%s-%s", getPrefix(roleArn), strconv.FormatInt(ts.Unix(), 10))
-}
+	if dir = os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return filepath.Join(dir, "cli-toolkit"), true, nil
+	}
 
-func decodeFromFileName(roleArn, fileName string) (ts time.Time, err error) {
-	regex := regexp.MustCompile(fmt.Sprintf(`^%s-(\d+)$`, getPrefix(roleArn)))
+	dir, err = legacyCacheDir()
 
-	matches := regex.FindStringSubmatch(fileName)
-	if matches == nil {
-		return ts, fmt.Errorf("%q is not of the right cache file name format", fileName)
-	}
+	return dir, false, err
+}
 
-	unixSec, err := strconv.ParseInt(matches[1], 10, 64)
+// legacyCacheDir is where cache files were saved before the cache directory became configurable.
+func legacyCacheDir() (string, error) {
+	home, err := os.UserHomeDir()
 	if err != nil {
-		return ts, fmt.Errorf("numeric portion of %q is not a valid Unix second", fileName)
+		return "", errors.New("could not locate user home directory")
 	}
 
-	ts = time.Unix(unixSec, 0)
-
-	return ts, nil
+	return filepath.Join(home, ".aws", "toolkit-cache"), nil
 }
 
+// newCacher saves cache files in cacheDir, or in the default of [CacheDir] if it's empty.
+// Cache files in the legacy directory are moved to the default directory once. See [migrateLegacyCache].
+// keep is how many live cache files are kept per identity, from the latest. Zero means one.
+// Only the latest is handed out, and identities that assume the same role are kept apart by [cacheKey], not by keep.
 // Non-nil returned error wraps [ErrCacheInit].
-func newCacher(logger logger, kp KeyProvider) (*cacher, error) {
-	home, err := os.UserHomeDir()
+func newCacher(logger logger, kp KeyProvider, cacheDir string, keep int) (*cacher, error) {
+	cacheDir, migrate, err := resolveCacheDir(cacheDir)
 	if err != nil {
-		return nil, fmt.Errorf("%w: could not locate user home directory", ErrCacheInit)
+		return nil, fmt.Errorf("%w: %s", ErrCacheInit, err.Error())
 	}
 
-	var key cipher.AesKey
-
-	err = kp.Write(key[:])
+	store, err := securestore.New(cacheDir, kp, logger)
 	if err != nil {
-		return nil, fmt.Errorf("%w: failed to obtain encryption key for cache file: %s", ErrCacheInit, err.Error())
+		return nil, fmt.Errorf("%w: %s", ErrCacheInit, err.Error())
+	}
+
+	if migrate {
+		migrateLegacyCache(logger, store)
 	}
 
-	aes := cipher.NewAesGcm(key)
+	// Credentials that expire within 10 minutes are not handed out.
+	store.Margin = time.Minute * 10
+	store.Keep = keep
 
-	cacheDir := filepath.Join(home, ".aws", "toolkit-cache")
+	return &cacher{store: store}, nil
+}
 
-	info, err := os.Stat(cacheDir)
-	if os.IsNotExist(err) {
-		if err = os.MkdirAll(cacheDir, 0750); err != nil {
-			return nil, fmt.Errorf("%w: failed to create cache directory", ErrCacheInit)
-		}
+// clockSkew estimates how far the local clock is ahead of a server, given serverTime from the Date header of a response
+// to a request that was sent at start and answered at end.
+func clockSkew(start, end, serverTime time.Time) time.Duration {
+	// The Date header has a precision of seconds.
+	return start.Add(end.Sub(start) / 2).Truncate(time.Second).Sub(serverTime)
+}
+
+func (r skewRecorder) Do(req *http.Request) (*http.Response, error) {
+	start := time.Now()
 
-		return &cacher{logger: logger, cacheDir: cacheDir, cipher: aes}, nil
-	} else if err != nil {
-		return nil, fmt.Errorf("%w: failed to locate cache directory: %s", ErrCacheInit, err.Error())
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return resp, err
 	}
 
-	if !info.IsDir() {
-		return nil, fmt.Errorf("%w: cache directory is already a file", ErrCacheInit)
+	if serverTime, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		r.record(clockSkew(start, time.Now(), serverTime))
 	}
 
-	return &cacher{logger: logger, cacheDir: cacheDir, cipher: aes}, nil
+	return resp, nil
 }
 
-// Non-nil returned error wraps [ErrInvalidCredential] or [ErrCacheSave].
-// contents is valid for use as long as it's not nil.
-func (c *cacher) save(roleArn string, output *ProcessOutput) (contents []byte, err error) {
-	ts, err := time.Parse(time.RFC3339, output.Expiration)
+// recordSkew makes an STS client save the clock skew it observes, so that cached credentials expire by the clock of STS,
+// which is what issued their expirations.
+func (c *cacher) recordSkew(logger logger) func(*sts.Options) {
+	return func(o *sts.Options) {
+		o.HTTPClient = skewRecorder{client: o.HTTPClient, record: func(skew time.Duration) {
+			if err := c.store.SetSkew(skew); err != nil {
+				logger.Println(err.Error())
+			}
+		}}
+	}
+}
+
+// migrateLegacyCache moves the cache files of the legacy directory into store, which keeps the cache warm when the default
+// directory moves because of XDG_CACHE_HOME.
+// Files that can never be read are deleted instead. These are files written before cache entries had names,
+// and entries named after role ARNs alone, before they were keyed by [cacheKey].
+func migrateLegacyCache(logger logger, store *securestore.Store) {
+	legacy, err := legacyCacheDir()
+	if err != nil || filepath.Clean(legacy) == filepath.Clean(store.Dir()) {
+		return
+	}
+
+	n, err := store.Import(legacy, isCacheKey)
 	if err != nil {
-		return nil, fmt.Errorf("%w: expiration %q is not of the right format: %s", ErrInvalidCredential, output.Expiration, err.Error())
+		logger.Printf("failed to move cache files from %s to %s: %s\n", legacy, store.Dir(), err)
+	} else if n > 0 {
+		logger.Printf("moved %d cache files from %s to %s\n", n, legacy, store.Dir())
 	}
+}
+
+// cacheKey identifies the cache files of the full identity that assumes a role, so that credentials obtained
+// through different source profiles or MFA devices don't evict each other.
+// Role ARNs cannot contain "|".
+func cacheKey(input ProcessInput) string {
+	return strings.Join([]string{input.RoleArn, input.Profile, input.MFASerial, input.RoleSessionName}, "|")
+}
+
+// isCacheKey reports whether name is of the format of [cacheKey].
+func isCacheKey(name string) bool {
+	return strings.HasPrefix(name, "arn:") && strings.Count(name, "|") == 3
+}
 
-	contents, err = json.Marshal(output)
+// CachedExpirations returns the expiration of the cached credentials of each identity, keyed by [Role.CacheKey].
+// Credentials that are too close to expiry to be handed out are left out.
+func CachedExpirations(logger logger, kp KeyProvider, cacheDir string) (map[string]time.Time, error) {
+	c, err := newCacher(logger, kp, cacheDir, 0)
 	if err != nil {
-		return nil, fmt.Errorf("%w: failed to serialize CredentialProcessOutput: %s", ErrInvalidCredential, err.Error())
+		return nil, err
 	}
 
-	filePath := filepath.Join(c.cacheDir, encodeToFileName(roleArn, ts))
+	defer c.store.Close()
 
-	encrypted, err := c.cipher.Encrypt(contents)
+	entries, err := c.store.List()
 	if err != nil {
-		return contents, fmt.Errorf("%w: failed to encrypt before saving: %s", ErrCacheSave, err.Error())
+		return nil, err
 	}
 
-	if err = os.WriteFile(filePath, encrypted, 0600); err != nil {
-		return contents, fmt.Errorf("%w: failed to write to disk: %s", ErrCacheSave, err.Error())
+	expirations := make(map[string]time.Time, len(entries))
+
+	for _, entry := range entries {
+		if expiration, ok := expirations[entry.Name]; !ok || entry.Expiration.After(expiration) {
+			expirations[entry.Name] = entry.Expiration
+		}
 	}
 
-	return contents, nil
+	return expirations, nil
 }
 
-// retrieve tries to retrieve AWS credentials from cache files.
-// It succeeded if and only if the returned byte slice is not nil.
-func (c *cacher) retrieve(roleArn string) (contents []byte) {
-	max := time.Now().Add(time.Minute * 10)
-	actives := make(cacheFileSlice, 0)
-	pattern := filepath.Join(c.cacheDir, fmt.Sprintf(`%s-*`, getPrefix(roleArn)))
-
-	cacheFiles, err := filepath.Glob(pattern)
+// marshalOutput serializes output into a guarded buffer.
+// Non-nil returned error wraps [ErrInvalidCredential].
+func marshalOutput(output *ProcessOutput) (contents *secret.Buffer, err error) {
+	raw, err := json.Marshal(output)
 	if err != nil {
-		c.logger.Printf("invalid file globbing pattern: %s\n", err)
+		return nil, fmt.Errorf("%w: failed to serialize CredentialProcessOutput: %s", ErrInvalidCredential, err.Error())
+	}
 
-		return nil
+	return secret.FromBytes(raw), nil
+}
+
+// unmarshalCredentials deserializes the output of the AWS CLI credential process into SDK credentials.
+// Non-nil returned error wraps [ErrInvalidCredential].
+func unmarshalCredentials(contents []byte) (aws.Credentials, error) {
+	var output ProcessOutput
+
+	if err := json.Unmarshal(contents, &output); err != nil {
+		return aws.Credentials{}, fmt.Errorf("%w: failed to deserialize CredentialProcessOutput: %s", ErrInvalidCredential, err.Error())
 	}
 
-	var expiration time.Time
+	expiration, err := time.Parse(time.RFC3339, output.Expiration)
+	if err != nil {
+		return aws.Credentials{}, fmt.Errorf("%w: expiration %q is not of the right format: %s", ErrInvalidCredential, output.Expiration, err.Error())
+	}
 
-	for _, fullPath := range cacheFiles {
-		if expiration, err = decodeFromFileName(roleArn, filepath.Base(fullPath)); err != nil {
-			c.deleteCacheFile(fullPath, "invalid")
+	return aws.Credentials{
+		AccessKeyID:     output.AccessKeyId,
+		SecretAccessKey: output.SecretAccessKey,
+		SessionToken:    output.SessionToken,
+		Source:          "toolkit-assume-role",
+		CanExpire:       true,
+		Expires:         expiration,
+	}, nil
+}
 
-			continue
-		} else if expiration.Before(max) {
-			c.deleteCacheFile(fullPath, "almost expired")
+// outputExpiration reads the expiration of serialized credentials without copying the secrets out of contents.
+func outputExpiration(contents []byte) (time.Time, error) {
+	var output struct {
+		Expiration string `json:"Expiration"`
+	}
 
-			continue
-		} else {
-			actives = append(actives, &cacheFile{expiration: expiration, filePath: fullPath})
-		}
+	if err := json.Unmarshal(contents, &output); err != nil {
+		return time.Time{}, fmt.Errorf("%w: failed to deserialize CredentialProcessOutput: %s", ErrInvalidCredential, err.Error())
 	}
 
-	if len(actives) == 0 {
-		return nil
+	ts, err := time.Parse(time.RFC3339, output.Expiration)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: expiration %q is not of the right format: %s", ErrInvalidCredential, output.Expiration, err.Error())
 	}
 
-	sort.Sort(actives)
+	return ts, nil
+}
 
-	for _, item := range actives[1:] {
-		c.deleteCacheFile(item.filePath, "older")
+// Non-nil returned error wraps [ErrInvalidCredential] or [ErrCacheSave].
+// contents is valid for use as long as it's not nil, and the caller should destroy it after use.
+func (c *cacher) save(key string, output *ProcessOutput) (contents *secret.Buffer, err error) {
+	ts, err := time.Parse(time.RFC3339, output.Expiration)
+	if err != nil {
+		return nil, fmt.Errorf("%w: expiration %q is not of the right format: %s", ErrInvalidCredential, output.Expiration, err.Error())
 	}
 
-	contents, err = os.ReadFile(actives[0].filePath)
+	contents, err = marshalOutput(output)
 	if err != nil {
-		c.logger.Printf("failed to read active cache file %q: %s\n", actives[0].filePath, err)
+		return nil, err
+	}
 
-		return nil
+	if err = c.store.PutUntil(key, contents.Bytes(), ts); err != nil {
+		return contents, fmt.Errorf("%w: %s", ErrCacheSave, err.Error())
 	}
 
-	contents, err = c.cipher.Decrypt(contents)
+	return contents, nil
+}
+
+// retrieve tries to retrieve AWS credentials of the identity of key from cache files.
+// On misses, expired cache files of all identities are deleted, so that roles which are no longer used don't leave files behind.
+// Hits don't scan the whole directory, while misses are followed by calls to STS that take far longer anyway.
+// It succeeded if and only if the returned buffer is not nil, in which case the caller should destroy it after use.
+func (c *cacher) retrieve(key string) (contents *secret.Buffer) {
+	contents, err := c.store.Get(key)
 	if err != nil {
-		c.logger.Printf("failed to decrypt cache file %q: %s\n", actives[0].filePath, err)
+		c.metrics.event(metricCacheMiss)
+
+		// Failing to delete other identities' files must not fail retrieval.
+		_ = c.store.GC()
 
 		return nil
 	}
 
-	return contents
-}
+	c.metrics.event(metricCacheHit)
 
-func (c *cacher) deleteCacheFile(fullPath string, desc string) {
-	if os.Remove(fullPath) != nil {
-		c.logger.Printf("Failed to delete %s cache file %q.\n", desc, fullPath)
-	}
+	return contents
 }
@@ -0,0 +1,259 @@
+package creds
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kxue43/cli-toolkit/cipher"
+	"github.com/kxue43/cli-toolkit/secret"
+	"github.com/kxue43/cli-toolkit/securestore"
+	"github.com/kxue43/cli-toolkit/securestore/storetest"
+	"github.com/kxue43/cli-toolkit/terminal"
+)
+
+func TestCacheDir(t *testing.T) {
+	home := t.TempDir()
+
+	t.Setenv("HOME", home)
+	t.Setenv("TOOLKIT_CACHE_DIR", "")
+	t.Setenv("XDG_CACHE_HOME", "")
+
+	dir, err := CacheDir()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(home, ".aws", "toolkit-cache"), dir, "the legacy directory should be the fallback")
+
+	t.Setenv("XDG_CACHE_HOME", "/xdg")
+
+	dir, err = CacheDir()
+	require.NoError(t, err)
+	assert.Equal(t, "/xdg/cli-toolkit", dir, "XDG_CACHE_HOME should take precedence over the legacy directory")
+
+	t.Setenv("TOOLKIT_CACHE_DIR", "/toolkit")
+
+	dir, err = CacheDir()
+	require.NoError(t, err)
+	assert.Equal(t, "/toolkit", dir, "TOOLKIT_CACHE_DIR should take precedence over XDG_CACHE_HOME")
+
+	dir, _, err = resolveCacheDir("/flag")
+	require.NoError(t, err)
+	assert.Equal(t, "/flag", dir, "an explicit directory should take precedence over everything")
+}
+
+func TestLegacyCacheMigration(t *testing.T) {
+	home := t.TempDir()
+	xdg := t.TempDir()
+
+	t.Setenv("HOME", home)
+	t.Setenv("TOOLKIT_CACHE_DIR", "")
+	t.Setenv("XDG_CACHE_HOME", xdg)
+
+	kp := storetest.KeyProvider(t)
+
+	legacy := filepath.Join(home, ".aws", "toolkit-cache")
+
+	legacyStore, err := securestore.New(legacy, kp, storetest.DiscardLogger{})
+	require.NoError(t, err, "should be able to create a store in the legacy directory")
+
+	input := ProcessInput{
+		RoleArn:         "arn:aws:iam::123456789012:role/admin",
+		MFASerial:       "arn:aws:iam::123456789012:mfa/me",
+		Profile:         "default",
+		RoleSessionName: "ToolkitCLI",
+	}
+
+	expiration := time.Now().Add(time.Hour)
+
+	require.NoError(t, legacyStore.PutUntil(cacheKey(input), []byte(`{"Expiration":"x"}`), expiration))
+	// entries named after role ARNs alone, before they were keyed by identity
+	require.NoError(t, legacyStore.PutUntil(input.RoleArn, []byte(`{"Expiration":"x"}`), expiration.Add(time.Second)))
+	legacyStore.Close()
+
+	// the baseline format, which has no name header
+	var key [cipher.KeySize]byte
+
+	require.NoError(t, kp.Write(key[:]))
+
+	aes := cipher.NewAesGcm(secret.FromBytes(key[:]))
+	defer aes.Destroy()
+
+	encrypted, err := aes.Encrypt([]byte(`{"AccessKeyId":"id","SecretAccessKey":"secret","SessionToken":"token","Expiration":"x","Version":1}`))
+	require.NoError(t, err)
+
+	hash := sha1.Sum([]byte(input.RoleArn))
+	baseline := fmt.Sprintf("%s-%d", hex.EncodeToString(hash[:])[:7], expiration.Add(2*time.Second).Unix())
+
+	require.NoError(t, os.WriteFile(filepath.Join(legacy, baseline), encrypted, 0600))
+
+	c, err := newCacher(storetest.DiscardLogger{}, kp, "", 0)
+	require.NoError(t, err, "should be able to create a cacher in the XDG cache directory")
+
+	defer c.store.Close()
+
+	assert.Equal(t, filepath.Join(xdg, "cli-toolkit"), c.store.Dir())
+
+	contents := c.retrieve(cacheKey(input))
+	require.NotNil(t, contents, "the legacy cache entry should have been moved to the new directory")
+
+	defer contents.Destroy()
+
+	entries, err := c.store.List()
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "entries that can never be read should not be moved")
+
+	_, err = os.Stat(legacy)
+	assert.True(t, os.IsNotExist(err), "the legacy directory should have been emptied and removed")
+}
+
+func TestCacheDirPermissions(t *testing.T) {
+	kp := storetest.KeyProvider(t)
+
+	dir := filepath.Join(t.TempDir(), "cache")
+
+	require.NoError(t, os.Mkdir(dir, 0700))
+	require.NoError(t, os.Chmod(dir, 0755))
+
+	_, err := newCacher(storetest.DiscardLogger{}, kp, dir, 0)
+	assert.ErrorIs(t, err, ErrCacheInit, "a cache directory readable by others should be refused")
+
+	require.NoError(t, os.Chmod(dir, 0700))
+
+	c, err := newCacher(storetest.DiscardLogger{}, kp, dir, 0)
+	require.NoError(t, err, "a private cache directory should be used")
+
+	c.store.Close()
+}
+
+func TestClockSkew(t *testing.T) {
+	kp := storetest.KeyProvider(t)
+
+	// The local clock is two hours behind STS, which issues credentials that expire in 5 minutes by its own clock.
+	// They're still usable by the local clock, but must not be handed out from the cache.
+	const skew = -2 * time.Hour
+
+	var requests int
+
+	fakeSTS := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+
+		serverTime := time.Now().Add(-skew).UTC()
+
+		w.Header().Set("Date", serverTime.Format(http.TimeFormat))
+		w.Header().Set("Content-Type", "text/xml")
+
+		_, _ = fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>access-key-id</AccessKeyId>
+      <SecretAccessKey>secret-access-key</SecretAccessKey>
+      <SessionToken>session-token</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`, serverTime.Add(5*time.Minute).Format(time.RFC3339))
+	}))
+	defer fakeSTS.Close()
+
+	input := ProcessInput{
+		RoleArn:         "arn:aws:iam::123456789012:role/admin",
+		MFASerial:       "arn:aws:iam::123456789012:mfa/me",
+		Profile:         "profile",
+		Region:          "us-east-1",
+		STSEndpoint:     fakeSTS.URL,
+		RoleSessionName: "ToolkitCLI",
+		DurationSeconds: 3600,
+		CacheDir:        filepath.Join(t.TempDir(), "cache"),
+	}
+
+	cfg := aws.Config{
+		Region:      input.Region,
+		Credentials: credentials.NewStaticCredentialsProvider("source-access-key-id", "source-secret-access-key", ""),
+	}
+
+	mockedTerminal := &MockTerminal{}
+	tty := terminal.NewTTY(mockedTerminal, "toolkit-assume-role: ", 0)
+
+	for range 2 {
+		_, err := mockedTerminal.r.WriteString("123456\n")
+		require.NoError(t, err, "should be able to write token to mocked TTY file descriptor")
+
+		_, err = NewProcessor(input, tty, cfg, kp).Retrieve(context.Background())
+		require.NoError(t, err, "should be able to retrieve credentials from the fake STS")
+	}
+
+	assert.Equal(t, 2, requests, "credentials expiring within the margin by the clock of STS should not be taken from the cache")
+
+	c, err := newCacher(storetest.DiscardLogger{}, kp, input.CacheDir, 0)
+	require.NoError(t, err)
+
+	defer c.store.Close()
+
+	assert.InDelta(t, skew, c.store.Skew, float64(2*time.Second), "the observed skew should be saved in the cache metadata")
+}
+
+func TestCacheIdentities(t *testing.T) {
+	kp := storetest.KeyProvider(t)
+
+	c, err := newCacher(storetest.DiscardLogger{}, kp, filepath.Join(t.TempDir(), "cache"), 2)
+	require.NoError(t, err)
+
+	defer c.store.Close()
+
+	regular := ProcessInput{RoleArn: "arn:aws:iam::123456789012:role/admin", Profile: "regular", MFASerial: "arn:aws:iam::123456789012:mfa/regular", RoleSessionName: "ToolkitCLI"}
+	breakGlass := regular
+	breakGlass.Profile = "break-glass"
+	breakGlass.MFASerial = "arn:aws:iam::123456789012:mfa/break-glass"
+
+	save := func(input ProcessInput, accessKeyId string, ttl time.Duration) {
+		contents, err := c.save(cacheKey(input), &ProcessOutput{AccessKeyId: accessKeyId, Expiration: time.Now().Add(ttl).Format(time.RFC3339), Version: 1})
+		require.NoError(t, err, "should be able to save credentials of %s", input.Profile)
+
+		contents.Destroy()
+	}
+
+	countFiles := func() int {
+		entries, err := os.ReadDir(c.store.Dir())
+		require.NoError(t, err)
+
+		return len(entries)
+	}
+
+	save(regular, "regular-1", time.Hour)
+	save(regular, "regular-2", 2*time.Hour)
+	save(regular, "regular-3", 3*time.Hour)
+	save(breakGlass, "break-glass", time.Hour)
+
+	// An expired file of a role that's not requested again.
+	require.NoError(t, c.store.PutUntil("arn:aws:iam::123456789012:role/old", []byte(`{}`), time.Now().Add(-time.Minute)))
+
+	for input, accessKeyId := range map[ProcessInput]string{regular: "regular-3", breakGlass: "break-glass"} {
+		contents := c.retrieve(cacheKey(input))
+		require.NotNil(t, contents, "credentials of %s should not have been evicted by the other identity", input.Profile)
+
+		output, err := unmarshalCredentials(contents.Bytes())
+		require.NoError(t, err)
+		assert.Equal(t, accessKeyId, output.AccessKeyID, "each identity should get its own latest credentials")
+
+		contents.Destroy()
+	}
+
+	assert.Equal(t, 4, countFiles(), "the two latest live files of each identity and the expired file should be left after hits")
+
+	other := regular
+	other.Profile = "other"
+
+	assert.Nil(t, c.retrieve(cacheKey(other)), "an identity without cache files should miss")
+	assert.Equal(t, 3, countFiles(), "expired files of other roles should have been garbage collected on a miss")
+}
@@ -1,32 +1,50 @@
 // Package creds implements AWS credential process with caching.
 // Cache files are saved on disk and encrypted via AES-GCM with the encryption key stored in the operating system's "native" credentials store.
 // For example, Keychain is used on macOS.
+// Go programs can use [Processor] directly as an [aws.CredentialsProvider].
 package creds
 
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"path/filepath"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 
+	"github.com/kxue43/cli-toolkit/secret"
 	"github.com/kxue43/cli-toolkit/terminal"
 )
 
 type (
 	ProcessInput struct {
-		RoleArn         string
-		MFASerial       string
-		Profile         string
-		Region          string
-		RoleSessionName string
-		DurationSeconds int64
+		RoleArn         string `json:"RoleArn"`
+		MFASerial       string `json:"MFASerial"`
+		Profile         string `json:"Profile"`
+		Region          string `json:"Region"`
+		RoleSessionName string `json:"RoleSessionName"`
+		DurationSeconds int64  `json:"DurationSeconds"`
+		// STSEndpoint overrides the regional STS endpoint, e.g. with a VPC endpoint.
+		STSEndpoint string `json:"STSEndpoint,omitempty"`
+		// MFAAttempts is how many MFA codes are prompted for before giving up. Zero means one.
+		MFAAttempts int `json:"MFAAttempts,omitempty"`
+		// CacheDir is where cache files are saved. Empty means the default of [CacheDir].
+		// It's never sent to the credential agent, which is reached through the socket of [AgentSocketPath] in CacheDir.
+		CacheDir string `json:"-"`
+		// CacheKeep is how many live cache files are kept per identity. Zero means one.
+		// It's never sent to the credential agent, which has its own.
+		CacheKeep int `json:"-"`
+		// TOTPSecretFile holds the base32 seed of the virtual MFA device, so that MFA codes are generated instead of prompted for.
+		// It's never sent to the credential agent, which has its own.
+		TOTPSecretFile string `json:"-"`
+		// Metrics enables appending cache events and timings to the file of [MetricsFile] in the cache directory.
+		// It's never sent to the credential agent, because its clients record the metrics themselves.
+		Metrics bool `json:"-"`
 	}
 
 	ProcessOutput struct {
@@ -41,7 +59,23 @@ type (
 		logger    logger
 		cacher    *cacher
 		retriever *stscreds.AssumeRoleProvider
-		roleArn   string
+		cacheKey  string
+		mfaRetrier
+	}
+
+	// mfaRetrier decides whether another MFA code is asked for after STS rejects one.
+	// It's shared by [Processor] and [AgentClient], which send MFA codes to STS directly and through the agent respectively.
+	mfaRetrier struct {
+		// tty receives messages that must be seen before the next MFA prompt, bypassing the buffered logger.
+		tty      io.Writer
+		attempts int
+		// generated is whether MFA codes come from a TOTP secret file.
+		generated bool
+		// code is the last MFA code sent to STS, and rejected the last one that STS rejected.
+		code     string
+		rejected string
+		sleep    func(context.Context, time.Duration) error
+		now      func() time.Time
 	}
 
 	logger interface {
@@ -49,6 +83,13 @@ type (
 		Println(...any)
 	}
 
+	// Terminal prompts users for MFA codes and shows them messages.
+	// *terminal.TTY implements it.
+	Terminal interface {
+		io.ReadWriter
+		logger
+	}
+
 	KeyProvider interface {
 		Write([]byte) error
 	}
@@ -56,9 +97,40 @@ type (
 	mfaPrompter struct {
 		io.ReadWriter
 	}
+
+	// totpGenerator computes MFA codes from a TOTP secret file, for use without a terminal.
+	totpGenerator struct {
+		path string
+		now  func() time.Time
+	}
 )
 
+// mfaTokenProvider returns the source of MFA codes for input: its TOTP secret file if it's set, and prompts through tty otherwise.
+func mfaTokenProvider(input ProcessInput, tty io.ReadWriter, now func() time.Time) func() (string, error) {
+	if input.TOTPSecretFile != "" {
+		return totpGenerator{path: input.TOTPSecretFile, now: now}.token
+	}
+
+	return mfaPrompter{ReadWriter: tty}.token
+}
+
+func (g totpGenerator) token() (string, error) {
+	seed, err := readTOTPSecret(g.path)
+	if err != nil {
+		return "", err
+	}
+
+	defer seed.Destroy()
+
+	return totpCode(seed.Bytes(), g.now(), 6), nil
+}
+
+// Non-nil returned error wraps [terminal.ErrNoTTY] if tty cannot be read from.
 func (c mfaPrompter) token() (code string, err error) {
+	if t, ok := c.ReadWriter.(interface{ Interactive() bool }); ok && !t.Interactive() {
+		return "", fmt.Errorf("%w: an MFA code is needed but cannot be prompted for", terminal.ErrNoTTY)
+	}
+
 	_, err = io.WriteString(c, "MFA code: ")
 	if err != nil {
 		return "", fmt.Errorf("failed to prompt for MFA code: %w", err)
@@ -74,52 +146,205 @@ func (c mfaPrompter) token() (code string, err error) {
 	return string(bytes.TrimSpace(buf[:n])), nil
 }
 
-func NewProcessor(input ProcessInput, tty *terminal.TTY, cfg aws.Config, kp KeyProvider) *Processor {
+var _ aws.CredentialsProvider = (*Processor)(nil)
+
+func NewProcessor(input ProcessInput, tty Terminal, cfg aws.Config, kp KeyProvider) *Processor {
 	var err error
 
 	p := Processor{}
 
-	prompter := mfaPrompter{ReadWriter: tty}
-
 	p.logger = tty
 
-	p.cacher, err = newCacher(p.logger, kp)
+	p.cacher, err = newCacher(p.logger, kp, input.CacheDir, input.CacheKeep)
 	if err != nil {
 		p.logger.Println(err.Error())
 	}
 
-	p.retriever = stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), input.RoleArn, func(o *stscreds.AssumeRoleOptions) {
+	var (
+		stsOptFns []func(*sts.Options)
+		metrics   *metricsRecorder
+	)
+
+	if p.cacher != nil {
+		stsOptFns = append(stsOptFns, p.cacher.recordSkew(p.logger))
+
+		if input.Metrics {
+			metrics = newMetricsRecorder(p.logger, filepath.Join(p.cacher.store.Dir(), metricsFileName))
+			metrics.observeStore(p.cacher.store)
+			p.cacher.metrics = metrics
+			stsOptFns = append(stsOptFns, metrics.timeSTS())
+		}
+	}
+
+	p.mfaRetrier = newMFARetrier(input, tty)
+
+	// TOTP codes are generated and waited for by the clock of STS rather than the local one.
+	if p.cacher != nil {
+		p.now = p.cacher.store.Now
+	}
+
+	token := p.track(mfaTokenProvider(input, tty, func() time.Time { return p.now() }))
+	if metrics != nil && input.TOTPSecretFile == "" {
+		token = metrics.timePrompt(token)
+	}
+
+	p.retriever = stscreds.NewAssumeRoleProvider(newSTSClient(cfg, input, stsOptFns...), input.RoleArn, func(o *stscreds.AssumeRoleOptions) {
 		o.RoleSessionName = input.RoleSessionName
 		o.Duration = time.Second * time.Duration(input.DurationSeconds)
 		o.SerialNumber = aws.String(input.MFASerial)
-		o.TokenProvider = prompter.token
+		o.TokenProvider = token
 	})
 
-	p.roleArn = input.RoleArn
+	p.cacheKey = cacheKey(input)
 
 	return &p
 }
 
+// Close wipes the encryption key of the cache. The processor must not be used afterwards.
+func (a *Processor) Close() {
+	if a.cacher != nil {
+		a.cacher.store.Close()
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retrieve calls STS, prompting for another MFA code when STS rejects one, until a.attempts codes have been tried.
+func (a *Processor) retrieve(ctx context.Context) (aws.Credentials, error) {
+	for attempt := 1; ; attempt++ {
+		stsCreds, err := a.retriever.Retrieve(ctx)
+		if err == nil {
+			return stsCreds, nil
+		}
+
+		if err = a.retry(ctx, classifySTSError(err), attempt); err != nil {
+			return aws.Credentials{}, err
+		}
+	}
+}
+
+func newMFARetrier(input ProcessInput, tty io.Writer) mfaRetrier {
+	return mfaRetrier{
+		tty:       tty,
+		attempts:  max(input.MFAAttempts, 1),
+		generated: input.TOTPSecretFile != "",
+		sleep:     sleepContext,
+		now:       time.Now,
+	}
+}
+
+// track makes r remember the MFA codes returned by token, so that it can tell when a rejected code is sent again.
+func (r *mfaRetrier) track(token func() (string, error)) func() (string, error) {
+	return func() (code string, err error) {
+		code, err = token()
+		r.code = code
+
+		return code, err
+	}
+}
+
+// retry decides what to do about err, which is returned by [classifySTSError] for the attempt-th MFA code.
+// It returns nil if another code should be tried, after waiting for the next time step if the code was reused.
+// Otherwise err is returned, wrapping [ErrMFACodeReused] if the code was reused.
+func (r *mfaRetrier) retry(ctx context.Context, err error, attempt int) error {
+	err = r.classify(err, attempt)
+
+	if attempt >= r.attempts {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, ErrMFACodeReused):
+		now := r.now()
+		wait := now.Truncate(totpPeriod).Add(totpPeriod).Sub(now)
+
+		_, _ = fmt.Fprintf(r.tty, "MFA code has already been used. Waiting %s for the next code...\n", wait.Round(time.Second))
+
+		return r.sleep(ctx, wait)
+	case errors.Is(err, ErrMFACodeInvalid):
+		_, _ = fmt.Fprintf(r.tty, "MFA code is invalid. %d attempt(s) left.\n", r.attempts-attempt)
+
+		return nil
+	default:
+		return err
+	}
+}
+
+// classify tells reused MFA codes apart from invalid ones.
+// STS rejects both with the same AccessDenied error, "MultiFactorAuthentication failed with invalid MFA one time pass code.",
+// so a rejected code is taken as reused if it was rejected before, or if it was generated and there are attempts left,
+// because a generated code is right for the current time step unless the TOTP secret file is wrong.
+func (r *mfaRetrier) classify(err error, attempt int) error {
+	if !errors.Is(err, ErrMFACodeInvalid) {
+		return err
+	}
+
+	reused := r.code == r.rejected || (r.generated && r.rejected == "" && attempt < r.attempts)
+	r.rejected = r.code
+
+	if reused {
+		return fmt.Errorf("%w: %w", ErrMFACodeReused, err)
+	}
+
+	return err
+}
+
+// Retrieve returns credentials of the role, from the cache if possible, so that a [Processor] can be used as an [aws.CredentialsProvider].
+// Wrap it in [aws.CredentialsCache] to avoid decrypting cache files on every call.
+// Non-nil returned error is the same as that of [Processor.Run].
+func (a *Processor) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	output, err := a.output(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	defer output.Destroy()
+
+	return unmarshalCredentials(output.Bytes())
+}
+
+// Run writes the output of the AWS CLI credential process to dest.
 // Non-nil returned error means failure.
-func (a *Processor) Run(ctx context.Context, dest io.Writer) (err error) {
-	// Output of the AWS CLI credential process.
-	var output []byte
+// STS failures wrap [ErrAccessDenied], [ErrMFACodeInvalid], [ErrMFACodeReused] or [ErrMaxSessionDuration] when they are recognized.
+// Serialized credentials are kept in guarded buffers and wiped before Run returns.
+// Credentials returned by the AWS SDK are Go strings, which cannot be wiped.
+func (a *Processor) Run(ctx context.Context, dest io.Writer) error {
+	output, err := a.output(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer output.Destroy()
 
+	if _, err = dest.Write(output.Bytes()); err != nil {
+		return fmt.Errorf("failed to write credentials to destination: %w", err)
+	}
+
+	return nil
+}
+
+// output returns the serialized output of the AWS CLI credential process, from the cache if possible.
+// The caller should destroy the returned buffer after use.
+func (a *Processor) output(ctx context.Context) (output *secret.Buffer, err error) {
 	if a.cacher != nil {
-		output = a.cacher.retrieve(a.roleArn)
-		if output != nil {
-			_, err = dest.Write(output)
-			if err != nil {
-				return fmt.Errorf("failed to write credentials to destination: %s", err.Error())
-			}
-
-			return
+		if output = a.cacher.retrieve(a.cacheKey); output != nil {
+			return output, nil
 		}
 	}
 
-	stsCreds, err := a.retriever.Retrieve(ctx)
+	stsCreds, err := a.retrieve(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to retrieve STS credentials: %s", err.Error())
+		return nil, fmt.Errorf("failed to retrieve STS credentials: %w", err)
 	}
 
 	// structured output
@@ -132,25 +357,22 @@ func (a *Processor) Run(ctx context.Context, dest io.Writer) (err error) {
 	}
 
 	if a.cacher != nil {
-		output, err = a.cacher.save(a.roleArn, &soutput)
+		output, err = a.cacher.save(a.cacheKey, &soutput)
 		if errors.Is(err, ErrInvalidCredential) {
-			return err
+			output.Destroy()
+
+			return nil, err
 		} else if err != nil {
 			a.logger.Println(err.Error())
 		}
 	}
 
 	if output == nil {
-		output, err = json.Marshal(&soutput)
+		output, err = marshalOutput(&soutput)
 		if err != nil {
-			return fmt.Errorf("failed to marshal credential process output: %s", err.Error())
+			return nil, err
 		}
 	}
 
-	_, err = dest.Write(output)
-	if err != nil {
-		return fmt.Errorf("failed to write credentials to destination: %s", err.Error())
-	}
-
-	return nil
+	return output, nil
 }
@@ -3,22 +3,24 @@ package creds
 import (
 	"bytes"
 	"context"
-	"crypto/rand"
 	"encoding/json"
-	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/aws/smithy-go"
 	"github.com/awsdocs/aws-doc-sdk-examples/gov2/testtools"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
-	"github.com/kxue43/cli-toolkit/cipher"
+	"github.com/kxue43/cli-toolkit/securestore"
+	"github.com/kxue43/cli-toolkit/securestore/storetest"
 	"github.com/kxue43/cli-toolkit/terminal"
 )
 
@@ -27,37 +29,8 @@ type (
 		r bytes.Buffer
 		w bytes.Buffer
 	}
-
-	HomeDirMocker struct {
-		TempDir string
-		home    string
-	}
-
-	AesKeyProvider struct {
-		key cipher.AesKey
-	}
 )
 
-func NewAesKeyProvider() (*AesKeyProvider, error) {
-	p := AesKeyProvider{}
-
-	if _, err := io.ReadFull(rand.Reader, p.key[:]); err != nil {
-		return nil, fmt.Errorf("failed to generate encryption key: %s", err.Error())
-	}
-
-	return &p, nil
-}
-
-func (p *AesKeyProvider) Write(key []byte) error {
-	if len(key) != len(p.key) {
-		return fmt.Errorf("the input byte slice should have length %d, but its length is %d.", len(p.key), len(key))
-	}
-
-	copy(key, p.key[:])
-
-	return nil
-}
-
 func (fd *MockTerminal) Read(p []byte) (n int, err error) {
 	return fd.r.Read(p)
 }
@@ -66,39 +39,12 @@ func (fd *MockTerminal) Write(p []byte) (n int, err error) {
 	return fd.w.Write(p)
 }
 
-func (m *HomeDirMocker) SetUp(t *testing.T) {
-	t.Helper()
-
-	var err error
-
-	m.TempDir, err = os.MkdirTemp("", "cli-toolkit")
-	require.NoError(t, err, "should be able to set up a temp directory for holding cache files during tests")
-
-	m.home, err = os.UserHomeDir()
-	require.NoError(t, err, "should be able to get user home directory")
-
-	err = os.Setenv("HOME", m.TempDir)
-	require.NoError(t, err, "should be able to set the HOME environment variable during tests")
-}
-
-func (m *HomeDirMocker) TearDown(t *testing.T) {
-	t.Helper()
-
-	err := os.Setenv("HOME", m.home)
-	require.NoError(t, err, "should be able to reset HOME to its original value after tests")
-
-	err = os.RemoveAll(m.TempDir)
-	require.NoError(t, err, "should be able to remove temp directory after tests")
-}
-
 func TestAssumeRoleCmdRun(t *testing.T) {
-	hdm := HomeDirMocker{}
+	cacheDir := filepath.Join(t.TempDir(), "cache")
 
-	hdm.SetUp(t)
-	defer hdm.TearDown(t)
+	t.Setenv("TOOLKIT_CACHE_DIR", cacheDir)
 
-	kp, err1 := NewAesKeyProvider()
-	require.NoError(t, err1, "should be able to create AesKeyProvider during tests")
+	kp := storetest.KeyProvider(t)
 
 	stubber := testtools.NewStubber()
 	defer testtools.ExitTest(stubber, t)
@@ -167,18 +113,21 @@ func TestAssumeRoleCmdRun(t *testing.T) {
 		err = processor.Run(ctx, &dest)
 		require.NoError(t, err, "should be able to run command without error")
 
-		cacheFilePath := filepath.Join(hdm.TempDir, ".aws", "toolkit-cache", encodeToFileName(roleArn, expiration))
+		assert.Equal(t, cacheDir, processor.cacher.store.Dir(), "cache files should be saved in $TOOLKIT_CACHE_DIR")
 
-		info, err := os.Stat(cacheFilePath)
-		require.NoError(t, err, "should be able to locate the cache file created by the Run method")
+		entries, err := processor.cacher.store.List()
+		require.NoError(t, err, "should be able to list the cache entries created by the Run method")
 
-		assert.False(t, info.IsDir(), "the cache file created by the Run method should be a regular file, not a directory")
+		require.Len(t, entries, 1, "there should be exactly one cache entry after the Run method")
 
-		rawContents, err := os.ReadFile(filepath.Clean(cacheFilePath))
-		require.NoError(t, err, "should be able to read cache file raw content without error")
+		assert.Equal(t, cacheKey(input), entries[0].Name, "the cache entry should be saved under the identity of the input")
 
-		rawContents, err = processor.cacher.cipher.Decrypt(rawContents)
-		require.NoError(t, err, "should be able to decrypt cache file without error")
+		assert.Equal(t, expiration.Unix(), entries[0].Expiration.Unix(), "the cache entry should expire together with the STS credentials")
+
+		value, err := processor.cacher.store.Get(cacheKey(input))
+		require.NoError(t, err, "should be able to read and decrypt the cache entry without error")
+
+		rawContents := value.Bytes()
 
 		var sCachedContents ProcessOutput
 
@@ -221,18 +170,21 @@ func TestAssumeRoleCmdRun(t *testing.T) {
 		err := processor.Run(ctx, &dest)
 		require.NoError(t, err, "should be able to run command without error")
 
-		cacheFilePath := filepath.Join(hdm.TempDir, ".aws", "toolkit-cache", encodeToFileName(roleArn, expiration))
+		assert.Equal(t, cacheDir, processor.cacher.store.Dir(), "cache files should be saved in $TOOLKIT_CACHE_DIR")
+
+		entries, err := processor.cacher.store.List()
+		require.NoError(t, err, "should be able to list the cache entries created by the Run method")
 
-		info, err := os.Stat(cacheFilePath)
-		require.NoError(t, err, "should be able to locate the cache file created by the Run method")
+		require.Len(t, entries, 1, "there should be exactly one cache entry after the Run method")
 
-		assert.False(t, info.IsDir(), "the cache file created by the Run method should be a regular file, not a directory")
+		assert.Equal(t, cacheKey(input), entries[0].Name, "the cache entry should be saved under the identity of the input")
 
-		rawContents, err := os.ReadFile(filepath.Clean(cacheFilePath))
-		require.NoError(t, err, "should be able to read cache file raw content without error")
+		assert.Equal(t, expiration.Unix(), entries[0].Expiration.Unix(), "the cache entry should expire together with the STS credentials")
 
-		rawContents, err = processor.cacher.cipher.Decrypt(rawContents)
-		require.NoError(t, err, "should be able to decrypt cache file without error")
+		value, err := processor.cacher.store.Get(cacheKey(input))
+		require.NoError(t, err, "should be able to read and decrypt the cache entry without error")
+
+		rawContents := value.Bytes()
 
 		var sCachedContents ProcessOutput
 
@@ -255,3 +207,349 @@ func TestAssumeRoleCmdRun(t *testing.T) {
 		assert.Equal(t, sCachedContents.Version, stdoutContents.Version, "Version from cache file should match that from stdout")
 	})
 }
+
+// scriptedTerminal answers each read with the next line, like a user typing one MFA code per prompt.
+type scriptedTerminal struct {
+	lines []string
+	w     bytes.Buffer
+}
+
+func (s *scriptedTerminal) Read(p []byte) (n int, err error) {
+	if len(s.lines) == 0 {
+		return 0, io.EOF
+	}
+
+	n = copy(p, s.lines[0]+"\n")
+	s.lines = s.lines[1:]
+
+	return n, nil
+}
+
+func (s *scriptedTerminal) Write(p []byte) (n int, err error) {
+	return s.w.Write(p)
+}
+
+func TestMFARetry(t *testing.T) {
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+
+	t.Setenv("TOOLKIT_CACHE_DIR", cacheDir)
+
+	kp := storetest.KeyProvider(t)
+
+	stubber := testtools.NewStubber()
+	defer testtools.ExitTest(stubber, t)
+
+	var duration int32 = 3600
+
+	input := ProcessInput{
+		RoleArn:         "role-arn",
+		MFASerial:       "mfa-serial",
+		Profile:         "profile",
+		Region:          "us-east-1",
+		RoleSessionName: "ToolkitCLI",
+		DurationSeconds: int64(duration),
+		MFAAttempts:     3,
+	}
+
+	// STS rejects invalid and reused MFA codes alike.
+	invalid := &smithy.GenericAPIError{Code: "AccessDenied", Message: "MultiFactorAuthentication failed with invalid MFA one time pass code."}
+
+	// Ten seconds into a TOTP time step.
+	now := time.Unix(1000*30+10, 0)
+
+	var waits []time.Duration
+
+	newProcessor := func(term *scriptedTerminal) *Processor {
+		processor := NewProcessor(input, terminal.NewTTY(term, "toolkit-assume-role: ", 0), *stubber.SdkConfig, kp)
+
+		processor.now = func() time.Time { return now }
+		processor.sleep = func(_ context.Context, d time.Duration) error {
+			waits = append(waits, d)
+
+			return nil
+		}
+
+		return processor
+	}
+
+	t.Run("Succeeds after bad codes", func(t *testing.T) {
+		token := "333333"
+		expiration := time.Now().Add(time.Hour)
+
+		stubber.Add(testtools.Stub{OperationName: "AssumeRole", Error: &testtools.StubError{Err: invalid, ContinueAfter: true}})
+		stubber.Add(testtools.Stub{OperationName: "AssumeRole", Error: &testtools.StubError{Err: invalid, ContinueAfter: true}})
+		stubber.Add(testtools.Stub{
+			OperationName: "AssumeRole",
+			Input: &sts.AssumeRoleInput{
+				DurationSeconds: &duration,
+				RoleArn:         &input.RoleArn,
+				RoleSessionName: &input.RoleSessionName,
+				SerialNumber:    &input.MFASerial,
+				TokenCode:       &token,
+			},
+			Output: &sts.AssumeRoleOutput{
+				Credentials: &types.Credentials{
+					AccessKeyId:     aws.String("access-key-id"),
+					SecretAccessKey: aws.String("secret-access-key"),
+					SessionToken:    aws.String("session-token"),
+					Expiration:      &expiration,
+				},
+			},
+		})
+
+		term := &scriptedTerminal{lines: []string{"111111", "111111", token}}
+		dest := MockTerminal{}
+
+		err := newProcessor(term).Run(context.Background(), &dest)
+		require.NoError(t, err, "should succeed with the third MFA code")
+
+		assert.Equal(t, []time.Duration{20 * time.Second}, waits, "a code rejected twice should be taken as reused and retried in the next TOTP time step")
+		assert.Equal(t, 3, strings.Count(term.w.String(), "MFA code: "), "the MFA code should have been prompted for three times")
+		assert.Contains(t, term.w.String(), "MFA code has already been used")
+		assert.Contains(t, term.w.String(), "MFA code is invalid")
+
+		var output ProcessOutput
+
+		require.NoError(t, json.Unmarshal(dest.w.Bytes(), &output))
+		assert.Equal(t, "access-key-id", output.AccessKeyId)
+	})
+
+	t.Run("Gives up after the last attempt", func(t *testing.T) {
+		input.RoleArn = "another-role-arn"
+
+		stubber.Add(testtools.Stub{OperationName: "AssumeRole", Error: &testtools.StubError{Err: invalid, ContinueAfter: true}})
+		stubber.Add(testtools.Stub{OperationName: "AssumeRole", Error: &testtools.StubError{Err: invalid, ContinueAfter: true}})
+		stubber.Add(testtools.Stub{OperationName: "AssumeRole", Error: &testtools.StubError{Err: invalid, ContinueAfter: true}})
+
+		term := &scriptedTerminal{lines: []string{"111111", "222222", "333333", "444444"}}
+
+		err := newProcessor(term).Run(context.Background(), &MockTerminal{})
+		require.ErrorIs(t, err, ErrMFACodeInvalid, "the last STS error should be returned")
+
+		assert.Equal(t, 3, strings.Count(term.w.String(), "MFA code: "), "the MFA code should have been prompted for MFAAttempts times")
+		assert.Len(t, term.lines, 1, "no more codes should be read after the last attempt")
+	})
+
+	t.Run("Other errors are not retried", func(t *testing.T) {
+		input.RoleArn = "yet-another-role-arn"
+
+		denied := &smithy.GenericAPIError{Code: "AccessDenied", Message: "not authorized to perform: sts:AssumeRole"}
+
+		stubber.Add(testtools.Stub{OperationName: "AssumeRole", Error: &testtools.StubError{Err: denied, ContinueAfter: true}})
+
+		term := &scriptedTerminal{lines: []string{"111111", "222222"}}
+
+		err := newProcessor(term).Run(context.Background(), &MockTerminal{})
+		require.ErrorIs(t, err, ErrAccessDenied)
+
+		assert.Len(t, term.lines, 1, "the MFA code should have been prompted for only once")
+	})
+
+	t.Run("Rejected generated codes are retried in the next time step", func(t *testing.T) {
+		input.RoleArn = "generated-role-arn"
+		input.TOTPSecretFile = filepath.Join(t.TempDir(), "totp")
+		waits = nil
+
+		defer func() { input.TOTPSecretFile = "" }()
+
+		require.NoError(t, os.WriteFile(input.TOTPSecretFile, []byte("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ\n"), 0600))
+
+		token := totpCode([]byte("12345678901234567890"), now, 6)
+		expiration := time.Now().Add(time.Hour)
+
+		stubber.Add(testtools.Stub{OperationName: "AssumeRole", Error: &testtools.StubError{Err: invalid, ContinueAfter: true}})
+		stubber.Add(testtools.Stub{
+			OperationName: "AssumeRole",
+			Input: &sts.AssumeRoleInput{
+				DurationSeconds: &duration,
+				RoleArn:         &input.RoleArn,
+				RoleSessionName: &input.RoleSessionName,
+				SerialNumber:    &input.MFASerial,
+				TokenCode:       &token,
+			},
+			Output: &sts.AssumeRoleOutput{
+				Credentials: &types.Credentials{
+					AccessKeyId:     aws.String("access-key-id"),
+					SecretAccessKey: aws.String("secret-access-key"),
+					SessionToken:    aws.String("session-token"),
+					Expiration:      &expiration,
+				},
+			},
+		})
+
+		term := &scriptedTerminal{}
+
+		err := newProcessor(term).Run(context.Background(), &MockTerminal{})
+		require.NoError(t, err, "should succeed with the code of the next time step")
+
+		assert.Equal(t, []time.Duration{20 * time.Second}, waits, "a rejected generated code should be taken as reused")
+		assert.NotContains(t, term.w.String(), "MFA code: ", "generated codes should not be prompted for")
+	})
+}
+
+func TestProcessorClock(t *testing.T) {
+	kp := storetest.KeyProvider(t)
+
+	input := ProcessInput{CacheDir: filepath.Join(t.TempDir(), "cache"), TOTPSecretFile: filepath.Join(t.TempDir(), "totp")}
+
+	p := NewProcessor(input, terminal.NewTTY(&scriptedTerminal{}, "toolkit-assume-role: ", 0), aws.Config{}, kp)
+	require.NotNil(t, p.cacher, "the cache should be usable")
+
+	p.cacher.store.Skew = time.Minute
+
+	assert.WithinDuration(t, time.Now().Add(-time.Minute), p.now(), time.Second, "MFA codes should be generated by the clock of STS")
+}
+
+func TestProcessorAsCredentialsProvider(t *testing.T) {
+	kp := storetest.KeyProvider(t)
+
+	stubber := testtools.NewStubber()
+	defer testtools.ExitTest(stubber, t)
+
+	var duration int32 = 3600
+
+	token := "123456"
+	expiration := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	input := ProcessInput{
+		RoleArn:         "role-arn",
+		MFASerial:       "mfa-serial",
+		Profile:         "profile",
+		Region:          "us-east-1",
+		RoleSessionName: "ToolkitCLI",
+		DurationSeconds: int64(duration),
+		CacheDir:        filepath.Join(t.TempDir(), "cache"),
+	}
+
+	stubber.Add(testtools.Stub{
+		OperationName: "AssumeRole",
+		Input: &sts.AssumeRoleInput{
+			DurationSeconds: &duration,
+			RoleArn:         &input.RoleArn,
+			RoleSessionName: &input.RoleSessionName,
+			SerialNumber:    &input.MFASerial,
+			TokenCode:       &token,
+		},
+		Output: &sts.AssumeRoleOutput{
+			Credentials: &types.Credentials{
+				AccessKeyId:     aws.String("access-key-id"),
+				SecretAccessKey: aws.String("secret-access-key"),
+				SessionToken:    aws.String("session-token"),
+				Expiration:      &expiration,
+			},
+		},
+	})
+
+	// Any Terminal works, not only *terminal.TTY.
+	term := struct {
+		*MockTerminal
+		storetest.DiscardLogger
+	}{MockTerminal: &MockTerminal{}}
+
+	_, err := term.r.WriteString(token + "\n")
+	require.NoError(t, err, "should be able to write token to mocked TTY file descriptor")
+
+	provider := aws.NewCredentialsCache(NewProcessor(input, term, *stubber.SdkConfig, kp))
+
+	ctx := context.Background()
+
+	for range 2 {
+		value, err := provider.Retrieve(ctx)
+		require.NoError(t, err, "should be able to retrieve credentials")
+
+		assert.Equal(t, "access-key-id", value.AccessKeyID)
+		assert.Equal(t, "secret-access-key", value.SecretAccessKey)
+		assert.Equal(t, "session-token", value.SessionToken)
+		assert.True(t, value.CanExpire, "role credentials should expire")
+		assert.True(t, expiration.Equal(value.Expires), "credentials should expire together with the STS credentials")
+	}
+
+	entries, err := os.ReadDir(input.CacheDir)
+	require.NoError(t, err, "the injected cache directory should have been created")
+	assert.Len(t, entries, 1, "the credentials should be cached in the injected cache directory")
+
+	// A new processor finds the credentials in the injected cache directory without calling STS.
+	value, err := NewProcessor(input, term, *stubber.SdkConfig, kp).Retrieve(ctx)
+	require.NoError(t, err, "should be able to retrieve cached credentials")
+
+	assert.Equal(t, "access-key-id", value.AccessKeyID)
+}
+
+func TestHeadless(t *testing.T) {
+	kp := storetest.KeyProvider(t)
+
+	stubber := testtools.NewStubber()
+	defer testtools.ExitTest(stubber, t)
+
+	var duration int32 = 3600
+
+	now := time.Unix(59, 0)
+	token := "287082"
+	expiration := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	input := ProcessInput{
+		RoleArn:         "role-arn",
+		MFASerial:       "mfa-serial",
+		Profile:         "profile",
+		Region:          "us-east-1",
+		RoleSessionName: "ToolkitCLI",
+		DurationSeconds: int64(duration),
+		CacheDir:        filepath.Join(t.TempDir(), "cache"),
+	}
+
+	var stderr bytes.Buffer
+
+	tty := terminal.NewHeadless(&stderr, "toolkit-assume-role: ", 0)
+
+	_, err := NewProcessor(input, tty, *stubber.SdkConfig, kp).Retrieve(context.Background())
+	require.ErrorIs(t, err, terminal.ErrNoTTY, "an MFA code cannot be prompted for without a terminal")
+	assert.NotEmpty(t, Hint(err), "the error should come with a hint")
+	assert.NotContains(t, stderr.String(), "MFA code: ", "no prompt should be written without a terminal")
+
+	// base32 of "12345678901234567890", whose 6-digit TOTP code at 59s is in RFC 6238, Appendix B.
+	input.TOTPSecretFile = filepath.Join(t.TempDir(), "totp")
+	require.NoError(t, os.WriteFile(input.TOTPSecretFile, []byte("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ\n"), 0600))
+
+	stubber.Add(testtools.Stub{
+		OperationName: "AssumeRole",
+		Input: &sts.AssumeRoleInput{
+			DurationSeconds: &duration,
+			RoleArn:         &input.RoleArn,
+			RoleSessionName: &input.RoleSessionName,
+			SerialNumber:    &input.MFASerial,
+			TokenCode:       &token,
+		},
+		Output: &sts.AssumeRoleOutput{
+			Credentials: &types.Credentials{
+				AccessKeyId:     aws.String("access-key-id"),
+				SecretAccessKey: aws.String("secret-access-key"),
+				SessionToken:    aws.String("session-token"),
+				Expiration:      &expiration,
+			},
+		},
+	})
+
+	p := NewProcessor(input, tty, *stubber.SdkConfig, kp)
+	p.now = func() time.Time { return now }
+
+	value, err := p.Retrieve(context.Background())
+	require.NoError(t, err, "MFA codes should be generated from the TOTP secret file")
+	assert.Equal(t, "access-key-id", value.AccessKeyID)
+
+	// Cached credentials need neither a terminal nor a TOTP secret file.
+	input.TOTPSecretFile = ""
+
+	dest := MockTerminal{}
+
+	require.NoError(t, NewProcessor(input, tty, *stubber.SdkConfig, kp).Run(context.Background(), &dest), "cached credentials should be returned without a terminal")
+
+	var output ProcessOutput
+
+	require.NoError(t, json.Unmarshal(dest.w.Bytes(), &output))
+	assert.Equal(t, "access-key-id", output.AccessKeyId)
+
+	p.Close()
+
+	err = p.cacher.store.Put("closed", []byte("x"), time.Hour)
+	assert.ErrorIs(t, err, securestore.ErrSave, "closing the processor should wipe the key of the cache")
+}
@@ -0,0 +1,271 @@
+package creds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+
+	"github.com/kxue43/cli-toolkit/cipher"
+	"github.com/kxue43/cli-toolkit/key"
+	"github.com/kxue43/cli-toolkit/secret"
+	"github.com/kxue43/cli-toolkit/securestore"
+)
+
+type (
+	CheckStatus int
+
+	CheckResult struct {
+		Name   string
+		Status CheckStatus
+		Detail string
+		// Remedy tells users how to fix a failed check.
+		Remedy string
+	}
+
+	// Doctor diagnoses why credentials are not cached, which otherwise only shows as repeated MFA prompts.
+	Doctor struct {
+		// Input provides the profile, region, STS endpoint and cache directory to check. Other fields are ignored.
+		Input       ProcessInput
+		KeyProvider KeyProvider
+		LoadConfig  ConfigLoader
+		TTYPath     string
+	}
+
+	// keyReader is implemented by key providers that can read the key without generating one, such as those of package key.
+	keyReader interface {
+		Read([]byte) error
+	}
+)
+
+const (
+	CheckPass CheckStatus = iota
+	CheckFail
+	CheckSkip
+)
+
+// maxClockSkew is the clock skew beyond which MFA codes may be rejected, because virtual MFA devices use 30-second time steps.
+const maxClockSkew = 30 * time.Second
+
+func (s CheckStatus) String() string {
+	switch s {
+	case CheckPass:
+		return "PASS"
+	case CheckFail:
+		return "FAIL"
+	default:
+		return "SKIP"
+	}
+}
+
+func pass(name, detail string) CheckResult {
+	return CheckResult{Name: name, Status: CheckPass, Detail: detail}
+}
+
+func fail(name, detail, remedy string) CheckResult {
+	return CheckResult{Name: name, Status: CheckFail, Detail: detail, Remedy: remedy}
+}
+
+func skip(name, detail string) CheckResult {
+	return CheckResult{Name: name, Status: CheckSkip, Detail: detail}
+}
+
+// Run performs all checks. Checks that depend on a failed one are skipped.
+func (d *Doctor) Run(ctx context.Context) []CheckResult {
+	results := []CheckResult{d.checkTTY(), d.checkKeyring()}
+
+	keyringStatus := results[1].Status
+
+	dirResult, cacheDir := d.checkCacheDir()
+	results = append(results, dirResult)
+
+	switch {
+	case keyringStatus == CheckSkip:
+		results = append(results, skip("cache entries", "there is no encryption key yet"))
+	case keyringStatus != CheckPass:
+		results = append(results, skip("cache entries", "the encryption key is not accessible"))
+	case dirResult.Status != CheckPass:
+		results = append(results, skip("cache entries", "the cache directory is unusable"))
+	default:
+		results = append(results, d.checkEntries(cacheDir))
+	}
+
+	return append(results, d.checkProfile(ctx), d.checkClock(ctx))
+}
+
+func (d *Doctor) checkTTY() CheckResult {
+	const name = "terminal"
+
+	f, err := os.OpenFile(d.TTYPath, os.O_RDWR, 0600)
+	if err != nil {
+		return fail(name, fmt.Sprintf("cannot open %s: %s", d.TTYPath, err), "Run in an interactive terminal, because MFA codes are prompted for on "+d.TTYPath+", or pass -totp-secret-file.")
+	}
+
+	_ = f.Close()
+
+	return pass(name, d.TTYPath+" is available")
+}
+
+// checkKeyring reads the encryption key without generating one if the key provider allows it,
+// so that checking doesn't save a key as a side effect.
+func (d *Doctor) checkKeyring() CheckResult {
+	const name = "keyring"
+
+	buf := secret.NewBuffer(cipher.KeySize)
+	defer buf.Destroy()
+
+	read := d.KeyProvider.Write
+	if r, ok := d.KeyProvider.(keyReader); ok {
+		read = r.Read
+	}
+
+	if err := read(buf.Bytes()); errors.Is(err, key.ErrNoKey) {
+		return skip(name, "there is no cache encryption key yet, and one will be created when credentials are first cached")
+	} else if err != nil {
+		return fail(name, err.Error(), "Unlock the login keychain on macOS, or run a Secret Service provider such as gnome-keyring on Linux.")
+	}
+
+	return pass(name, "the cache encryption key is accessible")
+}
+
+func (d *Doctor) checkCacheDir() (CheckResult, string) {
+	const name = "cache directory"
+
+	dir, _, err := resolveCacheDir(d.Input.CacheDir)
+	if err != nil {
+		return fail(name, err.Error(), "Set TOOLKIT_CACHE_DIR or pass -cache-dir."), ""
+	}
+
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return skip(name, dir+" does not exist yet and will be created"), dir
+	} else if err != nil {
+		return fail(name, err.Error(), "Make sure "+dir+" is accessible."), dir
+	}
+
+	if !info.IsDir() {
+		return fail(name, dir+" is not a directory", "Remove "+dir+"."), dir
+	}
+
+	if owned, known := ownedByCurrentUser(info); known && !owned {
+		return fail(name, dir+" is owned by another user", "Run `chown -R \"$(id -u)\" "+dir+"`."), dir
+	}
+
+	if perm := info.Mode().Perm(); perm&0007 != 0 {
+		return fail(name, fmt.Sprintf("%s has permissions %s and is accessible by others", dir, perm), "Run `chmod 700 "+dir+"`."), dir
+	}
+
+	return pass(name, dir+" is private to the current user"), dir
+}
+
+func (d *Doctor) checkEntries(cacheDir string) CheckResult {
+	const name = "cache entries"
+
+	store, err := securestore.New(cacheDir, d.KeyProvider, log.New(io.Discard, "", 0))
+	if err != nil {
+		return fail(name, err.Error(), "Fix the cache directory first.")
+	}
+
+	defer store.Close()
+
+	entries, errs, err := store.Verify()
+	if err != nil {
+		return fail(name, err.Error(), "Make sure "+cacheDir+" is readable.")
+	}
+
+	if len(errs) > 0 {
+		details := make([]string, len(errs))
+		for i := range errs {
+			details[i] = errs[i].Error()
+		}
+
+		return fail(name,
+			fmt.Sprintf("%d of %d entries cannot be decrypted:\n%s", len(errs), entries, strings.Join(details, "\n")),
+			"The encryption key probably changed or the files were modified. Delete these files, and they will be recreated.",
+		)
+	}
+
+	return pass(name, fmt.Sprintf("all %d entries can be decrypted", entries))
+}
+
+func (d *Doctor) checkProfile(ctx context.Context) CheckResult {
+	const name = "AWS profile"
+
+	if d.Input.Profile == "" {
+		return skip(name, "no profile was given")
+	}
+
+	cfg, err := d.LoadConfig(ctx, d.Input)
+	if err != nil {
+		return fail(name, err.Error(), "Check the profile "+d.Input.Profile+" in the AWS config and credentials files.")
+	}
+
+	value, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fail(name, err.Error(), "Check that the profile "+d.Input.Profile+" has credentials, e.g. aws_access_key_id and aws_secret_access_key.")
+	}
+
+	return pass(name, fmt.Sprintf("profile %s resolves to credentials from %s", d.Input.Profile, value.Source))
+}
+
+// checkClock compares the local clock with the Date header of an STS response.
+// The request is unsigned, because the Date header of the error response is just as good, and the check then works without credentials.
+func (d *Doctor) checkClock(ctx context.Context) CheckResult {
+	const name = "clock skew"
+
+	cfg, err := d.LoadConfig(ctx, d.Input)
+	if err != nil {
+		cfg = aws.Config{Region: d.Input.Region}
+	}
+
+	start := time.Now()
+
+	out, err := newSTSClient(cfg, d.Input, func(o *sts.Options) {
+		o.Credentials = aws.AnonymousCredentials{}
+		o.RetryMaxAttempts = 1
+
+		if o.Region == "" {
+			o.Region = partitionOfRegion("").defaultRegion
+		}
+	}).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+
+	end := time.Now()
+
+	var (
+		resp    *smithyhttp.Response
+		respErr *smithyhttp.ResponseError
+	)
+
+	if err == nil {
+		resp, _ = awsmiddleware.GetRawResponse(out.ResultMetadata).(*smithyhttp.Response)
+	} else if errors.As(err, &respErr) {
+		resp = respErr.Response
+	}
+
+	if resp == nil {
+		return fail(name, fmt.Sprintf("cannot reach STS: %s", err), "Check the network connection, region and STS endpoint.")
+	}
+
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return fail(name, "STS responded without a valid Date header", "Check the STS endpoint.")
+	}
+
+	skew := clockSkew(start, end, serverTime)
+
+	if skew > maxClockSkew || skew < -maxClockSkew {
+		return fail(name, fmt.Sprintf("the local clock is off by %s", skew), "Synchronize the clock, e.g. by enabling NTP.")
+	}
+
+	return pass(name, fmt.Sprintf("the local clock is off by %s", skew))
+}
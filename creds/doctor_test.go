@@ -0,0 +1,162 @@
+package creds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kxue43/cli-toolkit/key"
+	"github.com/kxue43/cli-toolkit/securestore"
+	"github.com/kxue43/cli-toolkit/securestore/storetest"
+)
+
+type (
+	lockedKeyProvider struct{}
+
+	// emptyKeyProvider has no key yet, and fails tests if one is generated.
+	emptyKeyProvider struct {
+		t *testing.T
+	}
+)
+
+func (lockedKeyProvider) Write([]byte) error {
+	return errors.New("keyring is locked")
+}
+
+func (emptyKeyProvider) Read([]byte) error {
+	return fmt.Errorf("%w in the keyring", key.ErrNoKey)
+}
+
+func (p emptyKeyProvider) Write([]byte) error {
+	p.t.Error("the doctor should not generate an encryption key")
+
+	return nil
+}
+
+func TestDoctor(t *testing.T) {
+	kp := storetest.KeyProvider(t)
+
+	var skew time.Duration
+
+	fakeSTS := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Authorization"), "the clock check should not sign requests")
+
+		w.Header().Set("Date", time.Now().Add(-skew).UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Type", "text/xml")
+		w.WriteHeader(http.StatusForbidden)
+
+		_, _ = w.Write([]byte(`<ErrorResponse><Error><Type>Sender</Type><Code>MissingAuthenticationToken</Code><Message>Request is missing Authentication Token</Message></Error><RequestId>request-id</RequestId></ErrorResponse>`))
+	}))
+	defer fakeSTS.Close()
+
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	tty := filepath.Join(t.TempDir(), "tty")
+
+	require.NoError(t, os.WriteFile(tty, nil, 0600))
+
+	store, err := securestore.New(cacheDir, kp, storetest.DiscardLogger{})
+	require.NoError(t, err, "should be able to create a store in the cache directory")
+
+	require.NoError(t, store.Put("role-arn", []byte("{}"), time.Hour))
+	store.Close()
+
+	newDoctor := func() *Doctor {
+		return &Doctor{
+			Input: ProcessInput{
+				Profile:     "profile",
+				Region:      "us-east-1",
+				STSEndpoint: fakeSTS.URL,
+				CacheDir:    cacheDir,
+			},
+			KeyProvider: kp,
+			LoadConfig: func(context.Context, ProcessInput) (aws.Config, error) {
+				return aws.Config{
+					Region:      "us-east-1",
+					Credentials: credentials.NewStaticCredentialsProvider("access-key-id", "secret-access-key", ""),
+				}, nil
+			},
+			TTYPath: tty,
+		}
+	}
+
+	statuses := func(results []CheckResult) map[string]CheckStatus {
+		m := make(map[string]CheckStatus, len(results))
+
+		for _, result := range results {
+			m[result.Name] = result.Status
+
+			if result.Status == CheckFail {
+				assert.NotEmpty(t, result.Remedy, "failed check %q should come with a remedy", result.Name)
+			}
+		}
+
+		return m
+	}
+
+	ctx := context.Background()
+
+	t.Run("Healthy", func(t *testing.T) {
+		assert.Equal(t, map[string]CheckStatus{
+			"terminal":        CheckPass,
+			"keyring":         CheckPass,
+			"cache directory": CheckPass,
+			"cache entries":   CheckPass,
+			"AWS profile":     CheckPass,
+			"clock skew":      CheckPass,
+		}, statuses(newDoctor().Run(ctx)))
+	})
+
+	t.Run("Unhealthy", func(t *testing.T) {
+		skew = 2 * time.Minute
+
+		defer func() { skew = 0 }()
+
+		// A file encrypted with another key, e.g. after the keyring was reset.
+		otherStore, err := securestore.New(cacheDir, storetest.KeyProvider(t), storetest.DiscardLogger{})
+		require.NoError(t, err)
+
+		require.NoError(t, otherStore.Put("other-role-arn", []byte("{}"), time.Hour))
+		otherStore.Close()
+
+		doctor := newDoctor()
+		doctor.TTYPath = filepath.Join(t.TempDir(), "missing")
+		doctor.Input.Profile = ""
+
+		assert.Equal(t, map[string]CheckStatus{
+			"terminal":        CheckFail,
+			"keyring":         CheckPass,
+			"cache directory": CheckPass,
+			"cache entries":   CheckFail, // encrypted with another key
+			"AWS profile":     CheckSkip,
+			"clock skew":      CheckFail,
+		}, statuses(doctor.Run(ctx)))
+
+		doctor.KeyProvider = lockedKeyProvider{}
+
+		require.NoError(t, os.Chmod(cacheDir, 0755))
+
+		got := statuses(doctor.Run(ctx))
+
+		assert.Equal(t, CheckFail, got["keyring"], "a locked keyring should be reported")
+		assert.Equal(t, CheckFail, got["cache directory"], "a cache directory readable by others should be reported")
+		assert.Equal(t, CheckSkip, got["cache entries"], "entries cannot be checked without the encryption key")
+
+		doctor.KeyProvider = emptyKeyProvider{t: t}
+
+		got = statuses(doctor.Run(ctx))
+
+		assert.Equal(t, CheckSkip, got["keyring"], "a missing key should be reported without creating one")
+		assert.Equal(t, CheckSkip, got["cache entries"])
+	})
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package creds
+
+import "os"
+
+// lockFile does nothing without advisory locks, so that files are only guarded against other goroutines of the process.
+func lockFile(*os.File, bool) error {
+	return nil
+}
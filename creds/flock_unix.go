@@ -0,0 +1,19 @@
+//go:build unix
+
+package creds
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFile takes a shared or exclusive advisory lock of f, which is released when f is closed.
+func lockFile(f *os.File, exclusive bool) error {
+	how := unix.LOCK_SH
+	if exclusive {
+		how = unix.LOCK_EX
+	}
+
+	return unix.Flock(int(f.Fd()), how)
+}
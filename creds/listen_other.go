@@ -0,0 +1,23 @@
+//go:build !unix
+
+package creds
+
+import (
+	"net"
+	"os"
+)
+
+func listenPrivate(socketPath string) (net.Listener, error) {
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.Chmod(socketPath, 0600); err != nil {
+		_ = l.Close()
+
+		return nil, err
+	}
+
+	return l, nil
+}
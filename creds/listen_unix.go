@@ -0,0 +1,18 @@
+//go:build unix
+
+package creds
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenPrivate listens on a Unix socket that only the current user can connect to.
+// The socket is created under a restrictive umask, because changing its mode afterwards leaves a window for others to connect.
+// The umask is process-wide, so files created concurrently by other goroutines are restricted as well.
+func listenPrivate(socketPath string) (net.Listener, error) {
+	mask := syscall.Umask(0177)
+	defer syscall.Umask(mask)
+
+	return net.Listen("unix", socketPath)
+}
@@ -0,0 +1,413 @@
+package creds
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+
+	"github.com/kxue43/cli-toolkit/securestore"
+)
+
+type (
+	// metricsRecorder appends metric samples to a JSON Lines file. A nil *metricsRecorder records nothing.
+	metricsRecorder struct {
+		logger logger
+		path   string
+		// maxSize is the size of the metrics file beyond which it's pruned.
+		maxSize int64
+		mux     sync.Mutex
+	}
+
+	// MetricSample is a line of the metrics file.
+	MetricSample struct {
+		Time time.Time `json:"Time"`
+		Name string    `json:"Name"`
+		// Seconds is the duration of timings, and zero for events.
+		Seconds float64 `json:"Seconds,omitempty"`
+	}
+
+	// MetricsSummary aggregates the samples of a metrics file.
+	MetricsSummary struct {
+		Since           time.Time
+		CacheHits       int
+		CacheMisses     int
+		CacheEvictions  int
+		DecryptFailures int
+		STSLatency      Timing
+		PromptWait      Timing
+	}
+
+	// Timing summarizes durations. Quantiles are of the nearest rank.
+	Timing struct {
+		Count int
+		Sum   time.Duration
+		P50   time.Duration
+		P99   time.Duration
+		Max   time.Duration
+	}
+
+	// timedClient reports how long STS takes to respond, excluding the time spent on MFA prompts.
+	timedClient struct {
+		client sts.HTTPClient
+		record func(time.Duration)
+	}
+)
+
+const (
+	metricCacheHit       = "cache_hit"
+	metricCacheMiss      = "cache_miss"
+	metricCacheEviction  = "cache_eviction"
+	metricDecryptFailure = "decrypt_failure"
+	metricSTSLatency     = "sts_latency"
+	metricPromptWait     = "prompt_wait"
+
+	metricsFileName = "metrics.jsonl"
+
+	// metricsRetention is how long samples are kept once the metrics file is large enough to be pruned.
+	metricsRetention = 90 * 24 * time.Hour
+	// maxMetricsFileSize is about 15,000 samples.
+	maxMetricsFileSize = 1 << 20
+)
+
+// MetricsFile returns the path of the metrics file in cacheDir, or in the default of [CacheDir] if it's empty.
+func MetricsFile(cacheDir string) (string, error) {
+	dir, _, err := resolveCacheDir(cacheDir)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, metricsFileName), nil
+}
+
+func newMetricsRecorder(logger logger, path string) *metricsRecorder {
+	return &metricsRecorder{logger: logger, path: path, maxSize: maxMetricsFileSize}
+}
+
+func (m *metricsRecorder) event(name string) {
+	m.record(name, 0)
+}
+
+func (m *metricsRecorder) timing(name string, d time.Duration) {
+	m.record(name, d)
+}
+
+// record appends a sample. Failures are logged rather than returned, because metrics must never fail credential retrieval.
+func (m *metricsRecorder) record(name string, d time.Duration) {
+	if m == nil {
+		return
+	}
+
+	raw, err := json.Marshal(MetricSample{Time: time.Now().UTC(), Name: name, Seconds: d.Seconds()})
+	if err != nil {
+		m.logger.Printf("failed to serialize metric sample: %s\n", err)
+
+		return
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	size, err := m.append(append(raw, '\n'))
+	if err != nil {
+		m.logger.Printf("failed to write metrics file: %s\n", err)
+
+		return
+	}
+
+	if size > m.maxSize {
+		if err = m.prune(time.Now()); err != nil {
+			m.logger.Printf("failed to prune metrics file: %s\n", err)
+		}
+	}
+}
+
+// lock takes an advisory lock of the metrics file, which is shared by processes that append to it,
+// and exclusive to the one that prunes it. The lock is released by closing the returned file.
+// It's taken on a separate file, because pruning replaces the metrics file.
+func (m *metricsRecorder) lock(exclusive bool) (*os.File, error) {
+	f, err := os.OpenFile(m.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = lockFile(f, exclusive); err != nil {
+		_ = f.Close()
+
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// append appends line to the metrics file and returns the size of the file after.
+// It must be called with m.mux held.
+func (m *metricsRecorder) append(line []byte) (int64, error) {
+	lock, err := m.lock(false)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() { _ = lock.Close() }()
+
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	if _, err = f.Write(line); err != nil {
+		return 0, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
+
+// prune rewrites the metrics file without the samples older than [metricsRetention], unless another process just did.
+// At most half of maxSize of the latest samples are kept, so that pruning is rare even if all samples are recent.
+// It must be called with m.mux held.
+func (m *metricsRecorder) prune(now time.Time) error {
+	lock, err := m.lock(true)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = lock.Close() }()
+
+	contents, err := os.ReadFile(m.path)
+	if err != nil || int64(len(contents)) <= m.maxSize {
+		return err
+	}
+
+	var (
+		sample MetricSample
+		kept   [][]byte
+		size   int64
+	)
+
+	cutoff := now.Add(-metricsRetention)
+	lines := bytes.SplitAfter(contents, []byte("\n"))
+
+	// Samples are appended in time order, so the latest are at the end.
+	for _, line := range slices.Backward(lines) {
+		sample = MetricSample{}
+
+		if json.Unmarshal(line, &sample) != nil {
+			continue
+		}
+
+		if size += int64(len(line)); sample.Time.Before(cutoff) || size > m.maxSize/2 {
+			break
+		}
+
+		kept = append(kept, line)
+	}
+
+	slices.Reverse(kept)
+
+	// Replacing the file at once keeps it whole for readers, which don't take the lock.
+	tmp := m.path + ".tmp"
+
+	if err = os.WriteFile(tmp, bytes.Join(kept, nil), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, m.path)
+}
+
+// observeStore records the evictions and decryption failures of store.
+func (m *metricsRecorder) observeStore(store *securestore.Store) {
+	store.Observe = func(event securestore.Event) {
+		switch event {
+		case securestore.EntryEvicted:
+			m.event(metricCacheEviction)
+		case securestore.EntryUndecryptable:
+			m.event(metricDecryptFailure)
+		}
+	}
+}
+
+// timeSTS makes an STS client record how long each request takes.
+func (m *metricsRecorder) timeSTS() func(*sts.Options) {
+	return func(o *sts.Options) {
+		o.HTTPClient = timedClient{client: o.HTTPClient, record: func(d time.Duration) {
+			m.timing(metricSTSLatency, d)
+		}}
+	}
+}
+
+// timePrompt records how long users take to enter MFA codes.
+func (m *metricsRecorder) timePrompt(token func() (string, error)) func() (string, error) {
+	return func() (string, error) {
+		start := time.Now()
+
+		code, err := token()
+
+		m.timing(metricPromptWait, time.Since(start))
+
+		return code, err
+	}
+}
+
+func (c timedClient) Do(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+
+	resp, err := c.client.Do(req)
+
+	c.record(time.Since(start))
+
+	return resp, err
+}
+
+// SummarizeMetrics aggregates the samples in the metrics file at path that were recorded since since.
+// A missing file summarizes to zeros, and malformed lines are skipped.
+func SummarizeMetrics(path string, since time.Time) (MetricsSummary, error) {
+	summary := MetricsSummary{Since: since}
+
+	f, err := os.Open(filepath.Clean(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return summary, nil
+	} else if err != nil {
+		return summary, fmt.Errorf("failed to open metrics file: %w", err)
+	}
+
+	defer func() { _ = f.Close() }()
+
+	var (
+		sample       MetricSample
+		stsLatencies []time.Duration
+		promptWaits  []time.Duration
+	)
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		sample = MetricSample{}
+
+		if json.Unmarshal(scanner.Bytes(), &sample) != nil || sample.Time.Before(since) {
+			continue
+		}
+
+		switch sample.Name {
+		case metricCacheHit:
+			summary.CacheHits++
+		case metricCacheMiss:
+			summary.CacheMisses++
+		case metricCacheEviction:
+			summary.CacheEvictions++
+		case metricDecryptFailure:
+			summary.DecryptFailures++
+		case metricSTSLatency:
+			stsLatencies = append(stsLatencies, secondsToDuration(sample.Seconds))
+		case metricPromptWait:
+			promptWaits = append(promptWaits, secondsToDuration(sample.Seconds))
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return summary, fmt.Errorf("failed to read metrics file: %w", err)
+	}
+
+	summary.STSLatency = newTiming(stsLatencies)
+	summary.PromptWait = newTiming(promptWaits)
+
+	return summary, nil
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func newTiming(durations []time.Duration) Timing {
+	if len(durations) == 0 {
+		return Timing{}
+	}
+
+	slices.Sort(durations)
+
+	t := Timing{Count: len(durations), Max: durations[len(durations)-1]}
+
+	for _, d := range durations {
+		t.Sum += d
+	}
+
+	t.P50 = nearestRank(durations, 0.5)
+	t.P99 = nearestRank(durations, 0.99)
+
+	return t
+}
+
+// nearestRank returns the q-quantile of sorted durations.
+func nearestRank(sorted []time.Duration, q float64) time.Duration {
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+
+	return sorted[max(rank, 0)]
+}
+
+// HitRatio is the fraction of credential requests served from the cache, or zero if there were none.
+func (s MetricsSummary) HitRatio() float64 {
+	if total := s.CacheHits + s.CacheMisses; total > 0 {
+		return float64(s.CacheHits) / float64(total)
+	}
+
+	return 0
+}
+
+// WriteOpenMetrics writes s in the OpenMetrics text format, e.g. for the textfile collector of the Prometheus node exporter.
+// Every metric is a gauge, because the values are of the samples since s.Since, which go down as old samples leave the window.
+func (s MetricsSummary) WriteOpenMetrics(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	gauges := []struct {
+		name, help string
+		value      int
+	}{
+		{"toolkit_cache_hits", "Credential requests served from the cache.", s.CacheHits},
+		{"toolkit_cache_misses", "Credential requests that called STS.", s.CacheMisses},
+		{"toolkit_cache_evictions", "Cache files deleted because they expired or were superseded.", s.CacheEvictions},
+		{"toolkit_cache_decrypt_failures", "Cache files that could not be decrypted.", s.DecryptFailures},
+		{"toolkit_sts_requests", "Requests to STS.", s.STSLatency.Count},
+		{"toolkit_mfa_prompts", "MFA codes entered by users.", s.PromptWait.Count},
+	}
+
+	for _, g := range gauges {
+		_, _ = fmt.Fprintf(bw, "# TYPE %[1]s gauge\n# HELP %[1]s %[2]s\n%[1]s %[3]d\n", g.name, g.help, g.value)
+	}
+
+	timings := []struct {
+		name, help string
+		timing     Timing
+	}{
+		{"toolkit_sts_request_duration_seconds", "Quantiles of the time taken by STS to respond.", s.STSLatency},
+		{"toolkit_mfa_prompt_duration_seconds", "Quantiles of the time taken by users to enter MFA codes.", s.PromptWait},
+	}
+
+	for _, m := range timings {
+		_, _ = fmt.Fprintf(bw, "# TYPE %[1]s gauge\n# UNIT %[1]s seconds\n# HELP %[1]s %[2]s\n", m.name, m.help)
+
+		if m.timing.Count > 0 {
+			_, _ = fmt.Fprintf(bw, "%[1]s{quantile=\"0.5\"} %[2]g\n%[1]s{quantile=\"0.99\"} %[3]g\n", m.name, m.timing.P50.Seconds(), m.timing.P99.Seconds())
+		}
+	}
+
+	_, _ = bw.WriteString("# EOF\n")
+
+	return bw.Flush()
+}
@@ -0,0 +1,202 @@
+package creds
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/awsdocs/aws-doc-sdk-examples/gov2/testtools"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kxue43/cli-toolkit/securestore/storetest"
+	"github.com/kxue43/cli-toolkit/terminal"
+)
+
+func TestMetrics(t *testing.T) {
+	kp := storetest.KeyProvider(t)
+
+	stubber := testtools.NewStubber()
+	defer testtools.ExitTest(stubber, t)
+
+	var duration int32 = 3600
+
+	token := "123456"
+	expiration := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	input := ProcessInput{
+		RoleArn:         "role-arn",
+		MFASerial:       "mfa-serial",
+		Profile:         "profile",
+		Region:          "us-east-1",
+		RoleSessionName: "ToolkitCLI",
+		DurationSeconds: int64(duration),
+		CacheDir:        filepath.Join(t.TempDir(), "cache"),
+		Metrics:         true,
+	}
+
+	stubber.Add(testtools.Stub{
+		OperationName: "AssumeRole",
+		Input: &sts.AssumeRoleInput{
+			DurationSeconds: &duration,
+			RoleArn:         &input.RoleArn,
+			RoleSessionName: &input.RoleSessionName,
+			SerialNumber:    &input.MFASerial,
+			TokenCode:       &token,
+		},
+		Output: &sts.AssumeRoleOutput{
+			Credentials: &types.Credentials{
+				AccessKeyId:     aws.String("access-key-id"),
+				SecretAccessKey: aws.String("secret-access-key"),
+				SessionToken:    aws.String("session-token"),
+				Expiration:      &expiration,
+			},
+		},
+	})
+
+	mockedTerminal := &MockTerminal{}
+
+	_, err := mockedTerminal.r.WriteString(token + "\n")
+	require.NoError(t, err, "should be able to write token to mocked TTY file descriptor")
+
+	tty := terminal.NewTTY(mockedTerminal, "toolkit-assume-role: ", 0)
+
+	for range 2 {
+		_, err = NewProcessor(input, tty, *stubber.SdkConfig, kp).Retrieve(context.Background())
+		require.NoError(t, err, "should be able to retrieve credentials")
+	}
+
+	path, err := MetricsFile(input.CacheDir)
+	require.NoError(t, err)
+
+	summary, err := SummarizeMetrics(path, time.Now().Add(-time.Hour))
+	require.NoError(t, err, "should be able to summarize the metrics file")
+
+	assert.Equal(t, 1, summary.CacheHits, "the second retrieval should be a cache hit")
+	assert.Equal(t, 1, summary.CacheMisses, "the first retrieval should be a cache miss")
+	assert.Equal(t, 1, summary.PromptWait.Count, "the MFA prompt should be timed")
+	assert.InDelta(t, 0.5, summary.HitRatio(), 0.001)
+
+	summary, err = SummarizeMetrics(path, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, summary.CacheHits+summary.CacheMisses, "samples before the cutoff should be left out")
+
+	summary, err = SummarizeMetrics(filepath.Join(t.TempDir(), "missing.jsonl"), time.Time{})
+	require.NoError(t, err, "a missing metrics file should summarize to zeros")
+	assert.Zero(t, summary.CacheHits)
+}
+
+func TestSummarizeMetrics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), metricsFileName)
+
+	lines := []string{
+		`{"Time":"2026-01-01T00:00:00Z","Name":"sts_latency","Seconds":0.1}`,
+		`{"Time":"2026-01-01T00:00:01Z","Name":"sts_latency","Seconds":0.3}`,
+		`not json`,
+		`{"Time":"2026-01-01T00:00:02Z","Name":"sts_latency","Seconds":0.2}`,
+		`{"Time":"2026-01-01T00:00:03Z","Name":"cache_eviction"}`,
+		`{"Time":"2026-01-01T00:00:04Z","Name":"decrypt_failure"}`,
+	}
+
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600))
+
+	summary, err := SummarizeMetrics(path, time.Time{})
+	require.NoError(t, err, "malformed lines should be skipped")
+
+	assert.Equal(t, Timing{
+		Count: 3,
+		Sum:   600 * time.Millisecond,
+		P50:   200 * time.Millisecond,
+		P99:   300 * time.Millisecond,
+		Max:   300 * time.Millisecond,
+	}, summary.STSLatency)
+	assert.Equal(t, 1, summary.CacheEvictions)
+	assert.Equal(t, 1, summary.DecryptFailures)
+
+	var out strings.Builder
+
+	require.NoError(t, summary.WriteOpenMetrics(&out))
+
+	text := out.String()
+
+	assert.Contains(t, text, "# TYPE toolkit_cache_evictions gauge\n")
+	assert.Contains(t, text, "toolkit_cache_evictions 1\n")
+	assert.Contains(t, text, "toolkit_sts_requests 3\n")
+	assert.NotContains(t, text, "counter", "sums over a moving window should not be exposed as counters")
+	assert.Contains(t, text, `toolkit_sts_request_duration_seconds{quantile="0.5"} 0.2`+"\n")
+	assert.NotContains(t, text, `toolkit_mfa_prompt_duration_seconds{quantile`, "quantiles of empty summaries should be left out")
+	assert.True(t, strings.HasSuffix(text, "# EOF\n"), "the exposition should be terminated by # EOF")
+}
+
+func TestPruneMetrics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), metricsFileName)
+
+	old := `{"Time":"2000-01-01T00:00:00Z","Name":"cache_hit"}` + "\n"
+
+	require.NoError(t, os.WriteFile(path, []byte(strings.Repeat(old, 10)), 0600))
+
+	m := newMetricsRecorder(storetest.DiscardLogger{}, path)
+	m.maxSize = 1 << 10
+
+	m.event(metricCacheMiss)
+
+	summary, err := SummarizeMetrics(path, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, 10, summary.CacheHits, "samples should only be pruned once the file is large")
+
+	for range 100 {
+		m.event(metricCacheMiss)
+	}
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, info.Size(), m.maxSize, "the metrics file should be kept under its maximum size")
+
+	summary, err = SummarizeMetrics(path, time.Time{})
+	require.NoError(t, err)
+	assert.Zero(t, summary.CacheHits, "samples older than the retention should be pruned")
+	assert.Positive(t, summary.CacheMisses, "the latest samples should be kept")
+}
+
+func TestConcurrentMetricsRecorders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), metricsFileName)
+
+	var wg sync.WaitGroup
+
+	// Each recorder stands for a separate process, which doesn't share the in-process mutex.
+	for range 4 {
+		m := newMetricsRecorder(storetest.DiscardLogger{}, path)
+		m.maxSize = 1 << 10
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for range 200 {
+				m.event(metricCacheHit)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(contents), 1<<10, "the metrics file should be kept under its maximum size")
+
+	for line := range strings.SplitSeq(strings.TrimSuffix(string(contents), "\n"), "\n") {
+		var sample MetricSample
+
+		require.NoError(t, json.Unmarshal([]byte(line), &sample), "concurrent pruning should not corrupt the metrics file")
+		assert.Equal(t, metricCacheHit, sample.Name)
+	}
+}
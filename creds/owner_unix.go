@@ -0,0 +1,18 @@
+//go:build unix
+
+package creds
+
+import (
+	"os"
+	"syscall"
+)
+
+// ownedByCurrentUser reports whether info belongs to the user running the process.
+func ownedByCurrentUser(info os.FileInfo) (owned bool, known bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false, false
+	}
+
+	return int(stat.Uid) == os.Getuid(), true
+}
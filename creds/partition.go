@@ -0,0 +1,139 @@
+package creds
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+type (
+	partition struct {
+		// id is the partition component of ARNs, e.g. "aws-us-gov".
+		id string
+		// regionPrefix is shared by the names of all regions of the partition.
+		// It's empty for the commercial partition, which is the fallback.
+		regionPrefix string
+		// defaultRegion is where STS is called when no region is given.
+		defaultRegion string
+	}
+)
+
+var (
+	ErrPartitionMismatch = errors.New("partition mismatch")
+	errUnknownPartition  = errors.New("unknown partition")
+)
+
+// partitions lists the partitions that have STS. Longer region prefixes come first.
+var partitions = []partition{
+	{id: "aws-iso-b", regionPrefix: "us-isob-", defaultRegion: "us-isob-east-1"},
+	{id: "aws-iso-e", regionPrefix: "eu-isoe-", defaultRegion: "eu-isoe-west-1"},
+	{id: "aws-iso-f", regionPrefix: "us-isof-", defaultRegion: "us-isof-south-1"},
+	{id: "aws-iso", regionPrefix: "us-iso-", defaultRegion: "us-iso-east-1"},
+	{id: "aws-eusc", regionPrefix: "eusc-", defaultRegion: "eusc-de-east-1"},
+	{id: "aws-us-gov", regionPrefix: "us-gov-", defaultRegion: "us-gov-west-1"},
+	{id: "aws-cn", regionPrefix: "cn-", defaultRegion: "cn-north-1"},
+	{id: "aws", regionPrefix: "", defaultRegion: "us-east-1"},
+}
+
+func partitionByID(id string) (partition, bool) {
+	for _, p := range partitions {
+		if p.id == id {
+			return p, true
+		}
+	}
+
+	return partition{}, false
+}
+
+func partitionOfRegion(region string) partition {
+	for _, p := range partitions {
+		if strings.HasPrefix(region, p.regionPrefix) {
+			return p
+		}
+	}
+
+	// Unreachable, because the commercial partition matches every region.
+	return partitions[len(partitions)-1]
+}
+
+// partitionOfArn returns the partition of an ARN.
+// The second returned value is false if s is not an ARN, e.g. the serial number of a hardware MFA device.
+func partitionOfArn(s string) (partition, bool, error) {
+	if !arn.IsARN(s) {
+		return partition{}, false, nil
+	}
+
+	parsed, err := arn.Parse(s)
+	if err != nil {
+		return partition{}, false, err
+	}
+
+	p, ok := partitionByID(parsed.Partition)
+	if !ok {
+		return partition{}, false, fmt.Errorf("%w %q in ARN %q", errUnknownPartition, parsed.Partition, s)
+	}
+
+	return p, true, nil
+}
+
+// ResolveRegion detects the partition from the role ARN and checks that the MFA serial and the region belong to it.
+// If no region is given, the default STS region of the partition is filled in.
+// A role in a partition unknown to this package is only accepted with STSEndpoint and Region, which are then used as they are.
+// Non-nil returned error wraps [ErrPartitionMismatch] if the inputs belong to different partitions.
+func (in *ProcessInput) ResolveRegion() error {
+	role, ok, err := partitionOfArn(in.RoleArn)
+	if errors.Is(err, errUnknownPartition) && in.STSEndpoint != "" {
+		if in.Region == "" {
+			return fmt.Errorf("%w: region is required for role %q with a custom STS endpoint, because its partition is unknown", ErrInvalidInput, in.RoleArn)
+		}
+
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if !ok {
+		// Not an ARN, leave it to STS to complain.
+		if in.Region == "" {
+			in.Region = partitionOfRegion("").defaultRegion
+		}
+
+		return nil
+	}
+
+	mfa, ok, err := partitionOfArn(in.MFASerial)
+	if err != nil {
+		return err
+	}
+
+	if ok && mfa.id != role.id {
+		return fmt.Errorf("%w: MFA device %q is in partition %q, but role %q is in partition %q", ErrPartitionMismatch, in.MFASerial, mfa.id, in.RoleArn, role.id)
+	}
+
+	if in.Region == "" {
+		in.Region = role.defaultRegion
+
+		return nil
+	}
+
+	if region := partitionOfRegion(in.Region); region.id != role.id {
+		return fmt.Errorf("%w: region %q is in partition %q, but role %q is in partition %q", ErrPartitionMismatch, in.Region, region.id, in.RoleArn, role.id)
+	}
+
+	return nil
+}
+
+// newSTSClient creates an STS client that calls the regional endpoint of cfg, or input.STSEndpoint if it's set.
+func newSTSClient(cfg aws.Config, input ProcessInput, optFns ...func(*sts.Options)) *sts.Client {
+	if input.STSEndpoint != "" {
+		optFns = append([]func(*sts.Options){func(o *sts.Options) {
+			o.BaseEndpoint = aws.String(input.STSEndpoint)
+		}}, optFns...)
+	}
+
+	return sts.NewFromConfig(cfg, optFns...)
+}
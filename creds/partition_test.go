@@ -0,0 +1,201 @@
+package creds
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kxue43/cli-toolkit/securestore/storetest"
+	"github.com/kxue43/cli-toolkit/terminal"
+)
+
+func TestResolveRegion(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     ProcessInput
+		region    string
+		errorWrap error
+	}{
+		{
+			name:   "Commercial default",
+			input:  ProcessInput{RoleArn: "arn:aws:iam::123456789012:role/admin", MFASerial: "arn:aws:iam::123456789012:mfa/me"},
+			region: "us-east-1",
+		},
+		{
+			name:   "GovCloud default",
+			input:  ProcessInput{RoleArn: "arn:aws-us-gov:iam::123456789012:role/admin", MFASerial: "arn:aws-us-gov:iam::123456789012:mfa/me"},
+			region: "us-gov-west-1",
+		},
+		{
+			name:   "China default",
+			input:  ProcessInput{RoleArn: "arn:aws-cn:iam::123456789012:role/admin", MFASerial: "arn:aws-cn:iam::123456789012:mfa/me"},
+			region: "cn-north-1",
+		},
+		{
+			name:   "Explicit region is kept",
+			input:  ProcessInput{RoleArn: "arn:aws-us-gov:iam::123456789012:role/admin", MFASerial: "arn:aws-us-gov:iam::123456789012:mfa/me", Region: "us-gov-east-1"},
+			region: "us-gov-east-1",
+		},
+		{
+			name:   "Hardware MFA device",
+			input:  ProcessInput{RoleArn: "arn:aws-cn:iam::123456789012:role/admin", MFASerial: "GAHT12345678"},
+			region: "cn-north-1",
+		},
+		{
+			name:      "MFA device in another partition",
+			input:     ProcessInput{RoleArn: "arn:aws-cn:iam::123456789012:role/admin", MFASerial: "arn:aws:iam::123456789012:mfa/me"},
+			errorWrap: ErrPartitionMismatch,
+		},
+		{
+			name:      "Region in another partition",
+			input:     ProcessInput{RoleArn: "arn:aws-us-gov:iam::123456789012:role/admin", MFASerial: "arn:aws-us-gov:iam::123456789012:mfa/me", Region: "us-east-1"},
+			errorWrap: ErrPartitionMismatch,
+		},
+		{
+			name:   "ISO-F default",
+			input:  ProcessInput{RoleArn: "arn:aws-iso-f:iam::123456789012:role/admin", MFASerial: "arn:aws-iso-f:iam::123456789012:mfa/me"},
+			region: "us-isof-south-1",
+		},
+		{
+			name:   "ISO-E explicit region",
+			input:  ProcessInput{RoleArn: "arn:aws-iso-e:iam::123456789012:role/admin", MFASerial: "arn:aws-iso-e:iam::123456789012:mfa/me", Region: "eu-isoe-west-1"},
+			region: "eu-isoe-west-1",
+		},
+		{
+			name:   "European Sovereign Cloud default",
+			input:  ProcessInput{RoleArn: "arn:aws-eusc:iam::123456789012:role/admin", MFASerial: "arn:aws-eusc:iam::123456789012:mfa/me"},
+			region: "eusc-de-east-1",
+		},
+		{
+			name:      "ISO-F region for ISO role",
+			input:     ProcessInput{RoleArn: "arn:aws-iso:iam::123456789012:role/admin", MFASerial: "arn:aws-iso:iam::123456789012:mfa/me", Region: "us-isof-east-1"},
+			errorWrap: ErrPartitionMismatch,
+		},
+		{
+			name:   "Unknown partition with custom STS endpoint",
+			input:  ProcessInput{RoleArn: "arn:aws-new:iam::123456789012:role/admin", MFASerial: "arn:aws-new:iam::123456789012:mfa/me", Region: "new-east-1", STSEndpoint: "https://sts.new-east-1.example"},
+			region: "new-east-1",
+		},
+		{
+			name:      "Unknown partition with custom STS endpoint but no region",
+			input:     ProcessInput{RoleArn: "arn:aws-new:iam::123456789012:role/admin", MFASerial: "arn:aws-new:iam::123456789012:mfa/me", STSEndpoint: "https://sts.new-east-1.example"},
+			errorWrap: ErrInvalidInput,
+		},
+		{
+			name:      "Unknown partition",
+			input:     ProcessInput{RoleArn: "arn:aws-new:iam::123456789012:role/admin", MFASerial: "arn:aws-new:iam::123456789012:mfa/me", Region: "new-east-1"},
+			errorWrap: errUnknownPartition,
+		},
+		{
+			name:      "ISO region for commercial role",
+			input:     ProcessInput{RoleArn: "arn:aws:iam::123456789012:role/admin", MFASerial: "arn:aws:iam::123456789012:mfa/me", Region: "us-isob-east-1"},
+			errorWrap: ErrPartitionMismatch,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			input := tc.input
+
+			err := input.ResolveRegion()
+
+			if tc.errorWrap != nil {
+				assert.ErrorIs(t, err, tc.errorWrap)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.region, input.Region)
+		})
+	}
+}
+
+func TestCustomSTSEndpoint(t *testing.T) {
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+
+	t.Setenv("TOOLKIT_CACHE_DIR", cacheDir)
+
+	kp := storetest.KeyProvider(t)
+
+	input := ProcessInput{
+		RoleArn:         "arn:aws-cn:iam::123456789012:role/admin",
+		MFASerial:       "arn:aws-cn:iam::123456789012:mfa/me",
+		Profile:         "profile",
+		RoleSessionName: "ToolkitCLI",
+		DurationSeconds: 3600,
+	}
+
+	require.NoError(t, input.ResolveRegion())
+
+	expiration := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	var requests int
+
+	fakeSTS := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "AssumeRole", r.PostForm.Get("Action"))
+		assert.Equal(t, input.RoleArn, r.PostForm.Get("RoleArn"))
+		assert.Equal(t, input.MFASerial, r.PostForm.Get("SerialNumber"))
+		assert.Equal(t, "123456", r.PostForm.Get("TokenCode"))
+		assert.Contains(t, r.Header.Get("Authorization"), "/cn-north-1/sts/", "requests should be signed for the partition's default region")
+
+		w.Header().Set("Content-Type", "text/xml")
+
+		_, _ = fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>access-key-id</AccessKeyId>
+      <SecretAccessKey>secret-access-key</SecretAccessKey>
+      <SessionToken>session-token</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+  <ResponseMetadata>
+    <RequestId>request-id</RequestId>
+  </ResponseMetadata>
+</AssumeRoleResponse>`, expiration.Format(time.RFC3339))
+	}))
+	defer fakeSTS.Close()
+
+	input.STSEndpoint = fakeSTS.URL
+
+	cfg := aws.Config{
+		Region:      input.Region,
+		Credentials: credentials.NewStaticCredentialsProvider("source-access-key-id", "source-secret-access-key", ""),
+	}
+
+	mockedTerminal := &MockTerminal{}
+
+	_, err := mockedTerminal.r.WriteString("123456\n")
+	require.NoError(t, err, "should be able to write token to mocked TTY file descriptor")
+
+	tty := terminal.NewTTY(mockedTerminal, "toolkit-assume-role: ", 0)
+
+	dest := MockTerminal{}
+
+	err = NewProcessor(input, tty, cfg, kp).Run(context.Background(), &dest)
+	require.NoError(t, err, "should be able to retrieve credentials from the custom STS endpoint")
+
+	assert.Equal(t, 1, requests, "the custom STS endpoint should be called exactly once")
+
+	var output ProcessOutput
+
+	require.NoError(t, json.Unmarshal(dest.w.Bytes(), &output))
+	assert.Equal(t, "access-key-id", output.AccessKeyId)
+	assert.Equal(t, expiration.Format(time.RFC3339), output.Expiration)
+	assert.True(t, strings.HasPrefix(mockedTerminal.w.String(), "MFA code: "), "the MFA code should have been prompted for")
+}
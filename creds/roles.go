@@ -0,0 +1,231 @@
+package creds
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+type (
+	// Role is an assumable role found in the AWS config file or a team roles file.
+	Role struct {
+		Name            string `toml:"name"`
+		RoleArn         string `toml:"role_arn"`
+		MFASerial       string `toml:"mfa_serial"`
+		SourceProfile   string `toml:"source_profile"`
+		Region          string `toml:"region"`
+		RoleSessionName string `toml:"role_session_name"`
+		DurationSeconds int64  `toml:"duration_seconds"`
+		// Source is the file the role was found in.
+		Source string `toml:"-"`
+	}
+
+	teamRolesFile struct {
+		Roles []Role `toml:"role"`
+	}
+)
+
+// ConfigFile returns the path of the shared AWS config file, honoring AWS_CONFIG_FILE.
+func ConfigFile() (string, error) {
+	if path := os.Getenv("AWS_CONFIG_FILE"); path != "" {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not locate user home directory: %w", err)
+	}
+
+	return filepath.Join(home, ".aws", "config"), nil
+}
+
+// CacheKey identifies the cached credentials of r in the result of [CachedExpirations].
+func (r Role) CacheKey() string {
+	return cacheKey(r.Input())
+}
+
+// Input returns the input for assuming r, with the same defaults as the toolkit-assume-role command.
+func (r Role) Input() ProcessInput {
+	input := ProcessInput{
+		RoleArn:         r.RoleArn,
+		MFASerial:       r.MFASerial,
+		Profile:         r.SourceProfile,
+		Region:          r.Region,
+		RoleSessionName: r.RoleSessionName,
+		DurationSeconds: r.DurationSeconds,
+	}
+
+	if input.RoleSessionName == "" {
+		input.RoleSessionName = "ToolkitCLI"
+	}
+
+	if input.DurationSeconds == 0 {
+		input.DurationSeconds = 3600
+	}
+
+	return input
+}
+
+// LoadConfigRoles finds roles in the AWS config file at path.
+// These are profiles with role_arn, and profiles whose credential_process runs toolkit-assume-role.
+// A role profile without mfa_serial inherits it from its source profile.
+func LoadConfigRoles(path string) ([]Role, error) {
+	profiles, order, err := parseConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var roles []Role
+
+	for _, name := range order {
+		props := profiles[name]
+
+		role := Role{
+			Name:            name,
+			RoleArn:         props["role_arn"],
+			MFASerial:       props["mfa_serial"],
+			SourceProfile:   props["source_profile"],
+			Region:          props["region"],
+			RoleSessionName: props["role_session_name"],
+			Source:          path,
+		}
+
+		if seconds, err1 := strconv.ParseInt(props["duration_seconds"], 10, 64); err1 == nil {
+			role.DurationSeconds = seconds
+		}
+
+		if process := props["credential_process"]; role.RoleArn == "" && strings.Contains(process, "toolkit-assume-role") {
+			parseCredentialProcess(process, &role)
+		}
+
+		if role.RoleArn == "" {
+			continue
+		}
+
+		if source, ok := profiles[role.SourceProfile]; ok && role.MFASerial == "" {
+			role.MFASerial = source["mfa_serial"]
+		}
+
+		roles = append(roles, role)
+	}
+
+	return roles, nil
+}
+
+// LoadTeamRoles reads roles from a TOML file with a [[role]] table per role.
+func LoadTeamRoles(path string) ([]Role, error) {
+	var f teamRolesFile
+
+	if _, err := toml.DecodeFile(path, &f); err != nil {
+		return nil, fmt.Errorf("failed to read team roles file %q: %w", path, err)
+	}
+
+	for i := range f.Roles {
+		if f.Roles[i].Name == "" || f.Roles[i].RoleArn == "" {
+			return nil, fmt.Errorf("role #%d of team roles file %q must have name and role_arn", i+1, path)
+		}
+
+		f.Roles[i].Source = path
+	}
+
+	return f.Roles, nil
+}
+
+// parseConfigFile returns the properties of each profile, and the profile names in the order they appear.
+func parseConfigFile(path string) (profiles map[string]map[string]string, order []string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open AWS config file: %w", err)
+	}
+
+	defer func() { _ = f.Close() }()
+
+	profiles = make(map[string]map[string]string)
+
+	var current map[string]string
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+			continue
+		case line[0] == '[' && line[len(line)-1] == ']':
+			name := strings.TrimSpace(line[1 : len(line)-1])
+
+			if name != "default" {
+				var ok bool
+
+				// Other sections, e.g. [sso-session ...], are not profiles.
+				if name, ok = strings.CutPrefix(name, "profile "); !ok {
+					current = nil
+
+					continue
+				}
+
+				name = strings.TrimSpace(name)
+			}
+
+			if _, ok := profiles[name]; !ok {
+				profiles[name] = make(map[string]string)
+				order = append(order, name)
+			}
+
+			current = profiles[name]
+		case current != nil:
+			if k, v, ok := strings.Cut(line, "="); ok {
+				current[strings.TrimSpace(k)] = strings.TrimSpace(v)
+			}
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read AWS config file: %w", err)
+	}
+
+	return profiles, order, nil
+}
+
+// booleanFlags are the flags of toolkit-assume-role that don't take a separate value.
+var booleanFlags = map[string]bool{"no-agent": true, "metrics": true}
+
+// parseCredentialProcess fills role from the flags and the argument of a toolkit-assume-role command line.
+func parseCredentialProcess(process string, role *Role) {
+	fields := strings.Fields(process)
+
+	for i := 1; i < len(fields); i++ {
+		field := fields[i]
+
+		if !strings.HasPrefix(field, "-") {
+			role.RoleArn = field
+
+			continue
+		}
+
+		name, value, ok := strings.Cut(strings.TrimLeft(field, "-"), "=")
+		if !ok && !booleanFlags[name] && i+1 < len(fields) {
+			i++
+			value = fields[i]
+		}
+
+		switch name {
+		case "mfa-serial":
+			role.MFASerial = value
+		case "profile":
+			role.SourceProfile = value
+		case "region":
+			role.Region = value
+		case "role-session-name":
+			role.RoleSessionName = value
+		case "duration-seconds":
+			role.DurationSeconds, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+}
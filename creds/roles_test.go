@@ -0,0 +1,127 @@
+package creds
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfigRoles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+
+	contents := `
+[default]
+region = us-east-1
+mfa_serial = arn:aws:iam::123456789012:mfa/me
+
+# Roles assumed by the AWS CLI itself.
+[profile prod]
+role_arn = arn:aws:iam::210987654321:role/admin
+source_profile = default
+duration_seconds = 7200
+
+[profile gov]
+role_arn = arn:aws-us-gov:iam::123456789012:role/admin
+mfa_serial = arn:aws-us-gov:iam::123456789012:mfa/me
+source_profile = gov-source
+region = us-gov-east-1
+
+; Roles assumed via toolkit-assume-role.
+[profile dev]
+credential_process = toolkit-assume-role -mfa-serial=arn:aws:iam::123456789012:mfa/me -profile default -no-agent -duration-seconds=1800 arn:aws:iam::111111111111:role/dev
+
+[profile metered]
+credential_process = toolkit-assume-role -metrics -profile default -region eu-west-1 arn:aws:iam::111111111111:role/metered
+
+[profile plain]
+region = us-west-2
+
+[sso-session corp]
+role_arn = arn:aws:iam::123456789012:role/not-a-profile
+`
+
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+
+	roles, err := LoadConfigRoles(path)
+	require.NoError(t, err, "should be able to parse the AWS config file")
+
+	require.Len(t, roles, 4, "only profiles of roles should be returned")
+
+	assert.Equal(t, Role{
+		Name:            "prod",
+		RoleArn:         "arn:aws:iam::210987654321:role/admin",
+		MFASerial:       "arn:aws:iam::123456789012:mfa/me",
+		SourceProfile:   "default",
+		DurationSeconds: 7200,
+		Source:          path,
+	}, roles[0], "the MFA serial should be inherited from the source profile")
+
+	assert.Equal(t, Role{
+		Name:          "gov",
+		RoleArn:       "arn:aws-us-gov:iam::123456789012:role/admin",
+		MFASerial:     "arn:aws-us-gov:iam::123456789012:mfa/me",
+		SourceProfile: "gov-source",
+		Region:        "us-gov-east-1",
+		Source:        path,
+	}, roles[1])
+
+	assert.Equal(t, Role{
+		Name:            "dev",
+		RoleArn:         "arn:aws:iam::111111111111:role/dev",
+		MFASerial:       "arn:aws:iam::123456789012:mfa/me",
+		SourceProfile:   "default",
+		DurationSeconds: 1800,
+		Source:          path,
+	}, roles[2], "roles should be read from toolkit-assume-role command lines")
+
+	assert.Equal(t, Role{
+		Name:          "metered",
+		RoleArn:       "arn:aws:iam::111111111111:role/metered",
+		MFASerial:     "arn:aws:iam::123456789012:mfa/me",
+		SourceProfile: "default",
+		Region:        "eu-west-1",
+		Source:        path,
+	}, roles[3], "boolean flags should not take the next argument as their value")
+
+	input := roles[1].Input()
+
+	assert.Equal(t, "gov-source", input.Profile, "the source profile should be used to call STS")
+	assert.Equal(t, "ToolkitCLI", input.RoleSessionName, "the default role session name should be used")
+	assert.Equal(t, int64(3600), input.DurationSeconds, "the default duration should be used")
+	assert.NoError(t, input.Validate())
+}
+
+func TestLoadTeamRoles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roles.toml")
+
+	contents := `
+[[role]]
+name = "shared-readonly"
+role_arn = "arn:aws:iam::123456789012:role/readonly"
+mfa_serial = "arn:aws:iam::123456789012:mfa/me"
+source_profile = "default"
+duration_seconds = 900
+`
+
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+
+	roles, err := LoadTeamRoles(path)
+	require.NoError(t, err, "should be able to parse the team roles file")
+
+	assert.Equal(t, []Role{{
+		Name:            "shared-readonly",
+		RoleArn:         "arn:aws:iam::123456789012:role/readonly",
+		MFASerial:       "arn:aws:iam::123456789012:mfa/me",
+		SourceProfile:   "default",
+		DurationSeconds: 900,
+		Source:          path,
+	}}, roles)
+
+	require.NoError(t, os.WriteFile(path, []byte("[[role]]\nname = \"nameless\"\n"), 0600))
+
+	_, err = LoadTeamRoles(path)
+	assert.Error(t, err, "roles without ARNs should be rejected")
+}
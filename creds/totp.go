@@ -0,0 +1,80 @@
+package creds
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kxue43/cli-toolkit/secret"
+)
+
+// totpPeriod is the time step of virtual MFA devices.
+const totpPeriod = 30 * time.Second
+
+// totpCode computes the RFC 6238 time-based one-time password of key at t, using HMAC-SHA1.
+func totpCode(key []byte, t time.Time, digits int) string {
+	var counter [8]byte
+
+	binary.BigEndian.PutUint64(counter[:], uint64(t.Unix()/int64(totpPeriod/time.Second)))
+
+	mac := hmac.New(sha1.New, key)
+	_, _ = mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for range digits {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, code%mod)
+}
+
+// readTOTPSecret reads the base32 encoded seed of a virtual MFA device from a file that only its owner can access.
+// The caller should destroy the returned buffer after use.
+func readTOTPSecret(path string) (*secret.Buffer, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate TOTP secret file: %w", err)
+	}
+
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return nil, fmt.Errorf("TOTP secret file %q has permissions %s, but it must not be accessible by group or others", path, perm)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TOTP secret file: %w", err)
+	}
+
+	defer secret.Wipe(raw)
+
+	// Seeds are often shown in groups of four characters and without padding.
+	raw = bytes.ToUpper(bytes.ReplaceAll(bytes.TrimSpace(raw), []byte(" "), nil))
+	raw = bytes.TrimRight(raw, "=")
+
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	seed := secret.NewBuffer(encoding.DecodedLen(len(raw)))
+
+	n, err := encoding.Decode(seed.Bytes(), raw)
+	if err != nil {
+		seed.Destroy()
+
+		return nil, fmt.Errorf("TOTP secret file %q is not valid base32: %s", path, err.Error())
+	}
+
+	if n != seed.Len() {
+		defer seed.Destroy()
+
+		return secret.FromBytes(seed.Bytes()[:n]), nil
+	}
+
+	return seed, nil
+}
@@ -0,0 +1,110 @@
+package creds
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/smithy-go"
+
+	"github.com/kxue43/cli-toolkit/terminal"
+)
+
+var (
+	ErrInvalidInput = errors.New("invalid input")
+
+	ErrAccessDenied       = errors.New("access denied by STS")
+	ErrMFACodeInvalid     = errors.New("MFA code is invalid or expired")
+	ErrMFACodeReused      = errors.New("MFA code has already been used")
+	ErrMaxSessionDuration = errors.New("duration exceeds the maximum session duration of the role")
+)
+
+const (
+	minDurationSeconds = 900
+	maxDurationSeconds = 14400
+
+	maxMFAAttempts = 10
+)
+
+var (
+	roleArnRegexp = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:role/[\w+=,.@/-]{1,512}$`)
+	// Virtual MFA devices are identified by ARNs. Hardware devices are identified by serial numbers.
+	mfaArnRegexp          = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:mfa/[\w+=,.@/-]{1,512}$`)
+	mfaSerialRegexp       = regexp.MustCompile(`^[\w+=,.@-]{9,256}$`)
+	roleSessionNameRegexp = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+
+	// errorHints are printed to help users act on typed errors.
+	// ErrMFACodeReused comes before ErrMFACodeInvalid, because errors of reused codes wrap both.
+	errorHints = []struct {
+		target error
+		hint   string
+	}{
+		{target: terminal.ErrNoTTY, hint: "Run in a terminal, or pass -totp-secret-file to generate MFA codes without prompting."},
+		{target: ErrAccessDenied, hint: "Check that the source profile is allowed to assume the role and that the role trusts it."},
+		{target: ErrMFACodeReused, hint: "Wait for the MFA device to show a new code and try again."},
+		{target: ErrMFACodeInvalid, hint: "Check the MFA serial and enter the code currently shown by the MFA device."},
+		{target: ErrMaxSessionDuration, hint: "Lower the duration or raise MaxSessionDuration of the role."},
+	}
+)
+
+// Validate checks the syntax of every field of in.
+// Non-nil returned error wraps [ErrInvalidInput].
+func (in *ProcessInput) Validate() error {
+	switch {
+	case in.RoleArn == "":
+		return fmt.Errorf("%w: role ARN is required", ErrInvalidInput)
+	case !roleArnRegexp.MatchString(in.RoleArn):
+		return fmt.Errorf("%w: %q is not the ARN of an IAM role", ErrInvalidInput, in.RoleArn)
+	case in.MFASerial == "":
+		return fmt.Errorf("%w: MFA serial is required", ErrInvalidInput)
+	case !mfaArnRegexp.MatchString(in.MFASerial) && !mfaSerialRegexp.MatchString(in.MFASerial):
+		return fmt.Errorf("%w: %q is neither the ARN of a virtual MFA device nor the serial number of a hardware MFA device", ErrInvalidInput, in.MFASerial)
+	case in.Profile == "":
+		return fmt.Errorf("%w: profile is required", ErrInvalidInput)
+	case !roleSessionNameRegexp.MatchString(in.RoleSessionName):
+		return fmt.Errorf("%w: role session name %q must have 2 to 64 characters of letters, digits and +=,.@_-", ErrInvalidInput, in.RoleSessionName)
+	case in.DurationSeconds < minDurationSeconds || in.DurationSeconds > maxDurationSeconds:
+		return fmt.Errorf("%w: duration seconds %d is not between %d and %d", ErrInvalidInput, in.DurationSeconds, minDurationSeconds, maxDurationSeconds)
+	case in.MFAAttempts < 0 || in.MFAAttempts > maxMFAAttempts:
+		return fmt.Errorf("%w: MFA attempts %d is not between 0 and %d", ErrInvalidInput, in.MFAAttempts, maxMFAAttempts)
+	case in.CacheKeep < 0:
+		return fmt.Errorf("%w: cache keep %d is negative", ErrInvalidInput, in.CacheKeep)
+	}
+
+	return nil
+}
+
+// classifySTSError wraps err with one of the typed STS errors if it matches.
+// Otherwise err is returned as is.
+func classifySTSError(err error) error {
+	var apiErr smithy.APIError
+
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	msg := apiErr.ErrorMessage()
+
+	switch {
+	case strings.Contains(msg, "MaxSessionDuration"):
+		return fmt.Errorf("%w: %w", ErrMaxSessionDuration, err)
+	case strings.Contains(msg, "MultiFactorAuthentication"):
+		return fmt.Errorf("%w: %w", ErrMFACodeInvalid, err)
+	case apiErr.ErrorCode() == "AccessDenied":
+		return fmt.Errorf("%w: %w", ErrAccessDenied, err)
+	}
+
+	return err
+}
+
+// Hint returns advice for users on how to act on err, or an empty string if there is none.
+func Hint(err error) string {
+	for _, h := range errorHints {
+		if errors.Is(err, h.target) {
+			return h.hint
+		}
+	}
+
+	return ""
+}
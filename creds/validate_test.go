@@ -0,0 +1,113 @@
+package creds
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	valid := ProcessInput{
+		RoleArn:         "arn:aws:iam::123456789012:role/path/admin",
+		MFASerial:       "arn:aws:iam::123456789012:mfa/me",
+		Profile:         "profile",
+		RoleSessionName: "ToolkitCLI",
+		DurationSeconds: 3600,
+	}
+
+	require.NoError(t, valid.Validate(), "valid input should pass validation")
+
+	tests := []struct {
+		name   string
+		modify func(*ProcessInput)
+		ok     bool
+	}{
+		{name: "Missing role ARN", modify: func(in *ProcessInput) { in.RoleArn = "" }},
+		{name: "Role ARN of a user", modify: func(in *ProcessInput) { in.RoleArn = "arn:aws:iam::123456789012:user/me" }},
+		{name: "Role ARN with short account ID", modify: func(in *ProcessInput) { in.RoleArn = "arn:aws:iam::1234:role/admin" }},
+		{name: "GovCloud role ARN", modify: func(in *ProcessInput) { in.RoleArn = "arn:aws-us-gov:iam::123456789012:role/admin" }, ok: true},
+		{name: "Missing MFA serial", modify: func(in *ProcessInput) { in.MFASerial = "" }},
+		{name: "Hardware MFA serial", modify: func(in *ProcessInput) { in.MFASerial = "GAHT12345678" }, ok: true},
+		{name: "MFA serial too short", modify: func(in *ProcessInput) { in.MFASerial = "GAHT" }},
+		{name: "MFA serial not of a device", modify: func(in *ProcessInput) { in.MFASerial = "arn:aws:iam::123456789012:role/admin" }},
+		{name: "Missing profile", modify: func(in *ProcessInput) { in.Profile = "" }},
+		{name: "Session name with space", modify: func(in *ProcessInput) { in.RoleSessionName = "Toolkit CLI" }},
+		{name: "Session name too short", modify: func(in *ProcessInput) { in.RoleSessionName = "T" }},
+		{name: "Session name with symbols", modify: func(in *ProcessInput) { in.RoleSessionName = "me+cli=1,a.b@c-d_e" }, ok: true},
+		{name: "Duration too short", modify: func(in *ProcessInput) { in.DurationSeconds = 899 }},
+		{name: "Minimum duration", modify: func(in *ProcessInput) { in.DurationSeconds = 900 }, ok: true},
+		{name: "Maximum duration", modify: func(in *ProcessInput) { in.DurationSeconds = 14400 }, ok: true},
+		{name: "Duration too long", modify: func(in *ProcessInput) { in.DurationSeconds = 14401 }},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			input := valid
+
+			tc.modify(&input)
+
+			err := input.Validate()
+
+			if tc.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrInvalidInput)
+			}
+		})
+	}
+}
+
+func TestClassifySTSError(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    string
+		message string
+		target  error
+	}{
+		{
+			name:    "Access denied",
+			code:    "AccessDenied",
+			message: "User: arn:aws:iam::123456789012:user/me is not authorized to perform: sts:AssumeRole",
+			target:  ErrAccessDenied,
+		},
+		{
+			name:    "Invalid MFA code",
+			code:    "AccessDenied",
+			message: "MultiFactorAuthentication failed with invalid MFA one time pass code.",
+			target:  ErrMFACodeInvalid,
+		},
+		{
+			name:    "Duration too long",
+			code:    "ValidationError",
+			message: "The requested DurationSeconds exceeds the MaxSessionDuration set for this role.",
+			target:  ErrMaxSessionDuration,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			apiErr := &smithy.GenericAPIError{Code: tc.code, Message: tc.message}
+
+			err := fmt.Errorf("failed to retrieve STS credentials: %w", classifySTSError(fmt.Errorf("operation error: %w", apiErr)))
+
+			assert.ErrorIs(t, err, tc.target)
+			assert.ErrorAs(t, err, new(smithy.APIError), "the original API error should stay in the chain")
+			assert.NotEmpty(t, Hint(err), "typed STS errors should come with a hint")
+		})
+	}
+
+	t.Run("Unknown errors stay as they are", func(t *testing.T) {
+		err := errors.New("connection reset")
+
+		assert.Equal(t, err, classifySTSError(err))
+		assert.Empty(t, Hint(err))
+
+		apiErr := &smithy.GenericAPIError{Code: "Throttling", Message: "Rate exceeded"}
+
+		assert.Equal(t, error(apiErr), classifySTSError(apiErr))
+	})
+}
@@ -6,13 +6,40 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"strings"
 )
 
 type Angler struct {
-	dec         *json.Decoder
-	keys        []string
-	currentPath strings.Builder
+	scan     *scanner
+	segments []segment
+}
+
+var (
+	// ErrNotFound means that a key or array element of the path does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrTypeMismatch means that the value at the path, or one on the way to it, is not of the expected JSON type.
+	ErrTypeMismatch = errors.New("type mismatch")
+	// ErrMalformed means that the stream is not valid JSON, including when it ends prematurely.
+	ErrMalformed = errors.New("malformed JSON")
+)
+
+// classify wraps err with [ErrMalformed] or [ErrTypeMismatch] if it's one of the errors of encoding/json that they stand for.
+func classify(err error) error {
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
+
+	switch {
+	case errors.Is(err, ErrMalformed):
+		// The scanner reports malformed JSON itself.
+		return err
+	case errors.As(err, &syntaxErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("%w: %w", ErrMalformed, err)
+	case errors.As(err, &typeErr):
+		return fmt.Errorf("%w: %w", ErrTypeMismatch, err)
+	default:
+		return err
+	}
 }
 
 func IsObjectStart(t json.Token) bool {
@@ -47,101 +74,225 @@ func IsTargetKey(t json.Token, key string) bool {
 	return false
 }
 
-func NewAngler(stream io.Reader, path string) (*Angler, error) {
-	if !strings.HasPrefix(path, ".") {
-		return nil, errors.New(`path must start with the dot character "."`)
+func IsArrayStart(t json.Token) bool {
+	if d, ok := t.(json.Delim); ok && d == '[' {
+		return true
 	}
 
-	if strings.HasSuffix(path, ".") {
-		return nil, errors.New(`path must not end with the dot character "."`)
-	}
+	return false
+}
 
-	keys := strings.Split(path, ".")[1:]
+// NewAngler returns an Angler for the value at path, e.g. `.releases[0].tag_name` or `.versions["1.2.3"].dist`.
+// Keys are separated by dots, or quoted in brackets as JSON strings if they contain dots or brackets themselves.
+// Array indices are in brackets, and negative ones count from the end of the array.
+// path can also be a JSON Pointer of RFC 6901 that starts with "/", e.g. `/releases/0/tag_name`, or the empty string.
+// Error messages report locations in both syntaxes.
+// Non-nil returned error is a [*PathError] for path syntax errors.
+func NewAngler(stream io.Reader, path string) (*Angler, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
 
-	return &Angler{dec: json.NewDecoder(stream), keys: keys}, nil
+	return &Angler{scan: newScanner(stream), segments: segments}, nil
 }
 
+// Land returns the scalar value at the path, i.e. a string, float64, bool or nil.
+// Use [Angler.LandInto] for objects and arrays.
+// Non-nil returned error wraps [ErrNotFound], [ErrTypeMismatch] or [ErrMalformed] when it's one of them.
 func (a *Angler) Land(ctx context.Context) (value any, err error) {
-	a.currentPath.WriteString(".")
+	if err = a.toTarget(ctx); err != nil {
+		return nil, classify(err)
+	}
 
-	for _, key := range a.keys {
-		if err = a.toTargetKey(ctx, key); err != nil {
-			return nil, err
-		}
+	if value, err = a.getValue(); err != nil {
+		return nil, classify(err)
 	}
 
-	return a.getValue()
+	return value, nil
 }
 
-func (a *Angler) toTargetKey(ctx context.Context, key string) (err error) {
-	var t json.Token
+// LandInto decodes the value at the path into v, as [json.Unmarshal] does.
+// Unlike [Angler.Land], the value can be an object or array, which is decoded as a whole,
+// e.g. into *any, *[json.RawMessage] or a pointer to a struct. Values before it are still skipped without being decoded.
+// Non-nil returned error wraps [ErrNotFound], [ErrTypeMismatch] or [ErrMalformed] when it's one of them.
+func (a *Angler) LandInto(ctx context.Context, v any) error {
+	if err := a.toTarget(ctx); err != nil {
+		return classify(err)
+	}
 
-	// consume the starting '{' token
-	if t, err = a.dec.Token(); err != nil {
-		return
-	} else if !IsObjectStart(t) {
-		return fmt.Errorf("the value at path %q is not a JSON object", a.currentPath.String())
+	raw, err := a.scan.raw()
+	if err == nil {
+		err = json.Unmarshal(raw, v)
 	}
 
-	if key == "" || strings.Contains(key, " ") {
-		a.currentPath.WriteString(`"` + key + `"`)
-	} else {
-		a.currentPath.WriteString(key)
+	if err != nil {
+		return classify(fmt.Errorf("failed to decode the value at path %s: %w", formatLocation(a.segments), err))
 	}
 
+	return nil
+}
+
+// toTarget moves the scanner right before the value at the path.
+// Segments of JSON Pointers that are keys or indices are resolved in place once the type of their parent is known.
+func (a *Angler) toTarget(ctx context.Context) error {
+	for i, seg := range a.segments {
+		parent := a.segments[:i]
+
+		c, err := a.scan.peek()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case c == '[' && (seg.isIndex || seg.orIndex):
+			a.segments[i] = segment{index: seg.index, isIndex: true}
+
+			// consume the starting '[' delimiter
+			a.scan.pos++
+
+			err = a.toIndex(ctx, seg.index, parent)
+		case c == '{' && !seg.isIndex:
+			a.segments[i] = segment{key: seg.key}
+
+			// consume the starting '{' delimiter
+			a.scan.pos++
+
+			err = a.toTargetKey(ctx, seg.key, parent)
+		case seg.isIndex:
+			err = fmt.Errorf("%w: the value at path %s is not a JSON array", ErrTypeMismatch, formatLocation(parent))
+		case seg.orIndex:
+			err = fmt.Errorf("%w: the value at path %s is neither a JSON object nor a JSON array", ErrTypeMismatch, formatLocation(parent))
+		default:
+			err = fmt.Errorf("%w: the value at path %s is not a JSON object", ErrTypeMismatch, formatLocation(parent))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// toTargetKey moves the scanner right before the value of key in the object at parent, whose '{' has been consumed.
+// Keys are compared and other values skipped at the byte level, without being decoded.
+func (a *Angler) toTargetKey(ctx context.Context, key string, parent []segment) error {
 	done := ctx.Done()
 
-	// the last token; it always starts with '{'
-	last := t
-	// level of the current token; start with -1 as there's no "current" token in the beginning
-	level := -1
-	// the count of level-zero tokens so far
-	count := 0
+	for i := 0; ; i++ {
+		more, err := a.scan.more('}', i)
+		if err != nil {
+			return err
+		}
+
+		if !more {
+			break
+		}
 
-	for level > 0 || a.dec.More() {
 		// check for context expiration
 		select {
 		case <-done:
-			return fmt.Errorf("failed to find target key %q in time: %w", a.currentPath.String(), context.Cause(ctx))
+			return fmt.Errorf("failed to find target key %s in time: %w", formatLocation(appendSegment(parent, segment{key: key})), context.Cause(ctx))
 		default:
 		}
 
-		// get the next token
-		if t, err = a.dec.Token(); err != nil {
-			return
+		escaped, err := a.scan.readKey()
+		if err != nil {
+			return err
 		}
 
-		if IsStartingDelim(last) {
-			level += 1
+		if found, err := a.scan.keyIs(key, escaped); err != nil || found {
+			return err
 		}
 
-		if IsEndingDelim(last) {
-			level -= 1
+		if err = a.scan.skipValue(); err != nil {
+			return err
 		}
+	}
+
+	return fmt.Errorf("target key %s: %w", formatLocation(appendSegment(parent, segment{key: key})), ErrNotFound)
+}
+
+// toIndex moves the scanner right before the element at index of the array at parent, whose '[' has been consumed.
+// For a negative index, the last -index elements are buffered, and scanning continues from the buffered element,
+// because the length of the array is only known at its end.
+func (a *Angler) toIndex(ctx context.Context, index int, parent []segment) error {
+	done := ctx.Done()
 
-		if level == 0 {
-			count += 1
+	var last [][]byte
+
+	if index < 0 {
+		last = make([][]byte, -index)
+	}
+
+	count := 0
+
+	for ; ; count++ {
+		more, err := a.scan.more(']', count)
+		if err != nil {
+			return err
+		}
+
+		if !more {
+			break
 		}
 
-		if level == 0 && (count%2 == 1) && IsTargetKey(t, key) {
+		if count == index {
 			return nil
 		}
 
-		last = t
+		// check for context expiration
+		select {
+		case <-done:
+			return fmt.Errorf("failed to find array element %s in time: %w", formatLocation(appendSegment(parent, segment{index: index, isIndex: true})), context.Cause(ctx))
+		default:
+		}
+
+		if index < 0 {
+			var raw []byte
+
+			if raw, err = a.scan.raw(); err == nil {
+				slot := &last[count%len(last)]
+				*slot = append((*slot)[:0], raw...)
+			}
+		} else {
+			err = a.scan.skipValue()
+		}
+
+		if err != nil {
+			return err
+		}
 	}
 
-	return fmt.Errorf("failed to find target key %q", a.currentPath.String())
+	if index < 0 && count >= len(last) {
+		a.scan = newBytesScanner(last[count%len(last)])
+
+		return nil
+	}
+
+	return fmt.Errorf("array element %s: %w, because the array has only %d elements", formatLocation(appendSegment(parent, segment{index: index, isIndex: true})), ErrNotFound, count)
+}
+
+// appendSegment returns the path of seg in the value at parent, leaving parent intact.
+func appendSegment(parent []segment, seg segment) []segment {
+	return append(parent[:len(parent):len(parent)], seg)
 }
 
-func (a *Angler) getValue() (t json.Token, err error) {
-	t, err = a.dec.Token()
+// getValue materializes the scalar value right after the scanner.
+func (a *Angler) getValue() (value any, err error) {
+	raw, err := a.scan.raw()
 	if err != nil {
 		return nil, err
 	}
 
-	if d, ok := t.(json.Delim); ok {
-		return nil, fmt.Errorf("the value at path %q is the delimiter %v", a.currentPath.String(), d)
+	if raw[0] == '{' || raw[0] == '[' {
+		return nil, fmt.Errorf("%w: the value at path %s is the delimiter %c, use LandInto for objects and arrays", ErrTypeMismatch, formatLocation(a.segments), raw[0])
+	}
+
+	if err = json.Unmarshal(raw, &value); err != nil {
+		return nil, err
 	}
 
-	return t, nil
+	return value, nil
 }
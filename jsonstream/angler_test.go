@@ -2,6 +2,7 @@ package jsonstream
 
 import (
 	"context"
+	"encoding/json"
 	"iter"
 	"math"
 	"strings"
@@ -108,6 +109,72 @@ func TestLand(t *testing.T) {
 				"y",
 			},
 		},
+		{
+			contents: `
+			{
+				"releases": [
+					{"tag_name": "v1.0.0", "assets": [1, 2]},
+					{"tag_name": "v1.1.0", "assets": []},
+					{"tag_name": "v2.0.0", "assets": [3]}
+				],
+				"dist-tags": {"latest": "2.0.0"},
+				"versions": {
+					"1.2.3": {"yanked": true},
+					"say \"hi\"": "quoted"
+				}
+			}
+			`,
+			paths: []string{
+				".releases[0].tag_name",
+				".releases[2].assets[0]",
+				".releases[-1].tag_name",
+				".releases[-3].assets[-1]",
+				".dist-tags.latest",
+				`.versions["1.2.3"].yanked`,
+				`.versions["say \"hi\""]`,
+			},
+			expected: []any{
+				"v1.0.0",
+				float64(3),
+				"v2.0.0",
+				float64(2),
+				"2.0.0",
+				true,
+				"quoted",
+			},
+		},
+		{
+			contents: `
+			{
+				"paths": {"/users/{id}": {"get": "getUser"}},
+				"a~b": {"0": "key", "1": [true, false]},
+				"": "empty"
+			}
+			`,
+			paths: []string{
+				"/paths/~1users~1{id}/get",
+				"/a~0b/0",
+				"/a~0b/1/1",
+				"/",
+			},
+			expected: []any{
+				"getUser",
+				"key",
+				false,
+				"empty",
+			},
+		},
+		{
+			contents: `[[1, 2], [3, 4]]`,
+			paths: []string{
+				".[1][0]",
+				".[-2][-1]",
+			},
+			expected: []any{
+				float64(3),
+				float64(2),
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -122,3 +189,97 @@ func TestLand(t *testing.T) {
 		}
 	}
 }
+
+func TestLandErrors(t *testing.T) {
+	contents := `{"a": [1, 2], "b": {"c": 3}}`
+
+	tests := map[string]struct {
+		message   string
+		errorWrap error
+	}{
+		".a[2]":   {`array element ".a[2]" (JSON Pointer "/a/2"): not found, because the array has only 2 elements`, ErrNotFound},
+		".a[-3]":  {`array element ".a[-3]": not found, because the array has only 2 elements`, ErrNotFound},
+		".b[0]":   {`type mismatch: the value at path ".b" (JSON Pointer "/b") is not a JSON array`, ErrTypeMismatch},
+		".a.c":    {`type mismatch: the value at path ".a" (JSON Pointer "/a") is not a JSON object`, ErrTypeMismatch},
+		".b.d":    {`target key ".b.d" (JSON Pointer "/b/d"): not found`, ErrNotFound},
+		".b":      {`type mismatch: the value at path ".b" (JSON Pointer "/b") is the delimiter {, use LandInto for objects and arrays`, ErrTypeMismatch},
+		"/a/2":    {`array element ".a[2]" (JSON Pointer "/a/2"): not found, because the array has only 2 elements`, ErrNotFound},
+		"/b/c/0":  {`type mismatch: the value at path ".b.c" (JSON Pointer "/b/c") is neither a JSON object nor a JSON array`, ErrTypeMismatch},
+		"/b/d~1e": {`target key ".b.d/e" (JSON Pointer "/b/d~1e"): not found`, ErrNotFound},
+	}
+
+	for path, tc := range tests {
+		angler, err := NewAngler(strings.NewReader(contents), path)
+		require.NoError(t, err)
+
+		_, err = angler.Land(context.Background())
+		assert.EqualError(t, err, tc.message, "path %q", path)
+		assert.ErrorIs(t, err, tc.errorWrap, "path %q", path)
+	}
+
+	for _, malformed := range []string{`{"a": [1`, `{"a" 1}`, ``} {
+		angler, err := NewAngler(strings.NewReader(malformed), ".a[1]")
+		require.NoError(t, err)
+
+		_, err = angler.Land(context.Background())
+		assert.ErrorIs(t, err, ErrMalformed, "stream %q", malformed)
+	}
+}
+
+func TestLandInto(t *testing.T) {
+	contents := `
+	{
+		"skipped": {"deeply": [{"nested": [1, 2, 3]}]},
+		"info": {
+			"name": "toolkit",
+			"version": "1.2.3",
+			"classifiers": ["Go", "CLI"]
+		},
+		"releases": [
+			{"tag_name": "v1.0.0", "draft": false},
+			{"tag_name": "v2.0.0", "draft": true}
+		]
+	}
+	`
+
+	type release struct {
+		TagName string `json:"tag_name"`
+		Draft   bool   `json:"draft"`
+	}
+
+	angler, err := NewAngler(strings.NewReader(contents), ".releases[-1]")
+	require.NoError(t, err)
+
+	var r release
+
+	require.NoError(t, angler.LandInto(context.Background(), &r), "should be able to decode an object into a struct")
+	assert.Equal(t, release{TagName: "v2.0.0", Draft: true}, r)
+
+	angler, err = NewAngler(strings.NewReader(contents), ".info")
+	require.NoError(t, err)
+
+	var info any
+
+	require.NoError(t, angler.LandInto(context.Background(), &info), "should be able to decode an object into any")
+	assert.Equal(t, map[string]any{
+		"name":        "toolkit",
+		"version":     "1.2.3",
+		"classifiers": []any{"Go", "CLI"},
+	}, info)
+
+	angler, err = NewAngler(strings.NewReader(contents), ".info.classifiers")
+	require.NoError(t, err)
+
+	var raw json.RawMessage
+
+	require.NoError(t, angler.LandInto(context.Background(), &raw), "should be able to capture an array verbatim")
+	assert.JSONEq(t, `["Go", "CLI"]`, string(raw))
+
+	angler, err = NewAngler(strings.NewReader(contents), ".info.name")
+	require.NoError(t, err)
+
+	var n int
+
+	err = angler.LandInto(context.Background(), &n)
+	assert.ErrorContains(t, err, `failed to decode the value at path ".info.name"`, "type mismatches should be reported with the path")
+}
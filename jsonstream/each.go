@@ -0,0 +1,150 @@
+package jsonstream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+)
+
+// Element is an element of an array or a member of an object.
+type Element struct {
+	// Key is the name of an object member, and empty for array elements.
+	Key string
+	// Index counts elements and members from zero in document order.
+	Index int
+	Value json.RawMessage
+}
+
+// Elements yields the elements of the array, or the members of the object, at path one at a time,
+// so that arbitrarily large arrays and objects are walked without being buffered.
+// path uses the syntax of [NewAngler] and must end with "[*]", e.g. `.versions[*]`.
+// Elements are decoded lazily as the iteration proceeds, and reading stops when it's stopped early.
+// An error is yielded at most once, as the last pair, and it's a [*PathError] for path syntax errors
+// or wraps [ErrNotFound], [ErrTypeMismatch] or [ErrMalformed] when it's one of them.
+func Elements(ctx context.Context, stream io.Reader, path string) iter.Seq2[Element, error] {
+	return func(yield func(Element, error) bool) {
+		segments, err := parseElementsPath(path)
+		if err != nil {
+			yield(Element{}, err)
+
+			return
+		}
+
+		a := Angler{scan: newScanner(stream), segments: segments}
+
+		if err = a.toTarget(ctx); err != nil {
+			yield(Element{}, classify(err))
+
+			return
+		}
+
+		c, err := a.scan.peek()
+		if err != nil {
+			yield(Element{}, classify(err))
+
+			return
+		}
+
+		end := byte(']')
+
+		switch c {
+		case '{':
+			end = '}'
+		case '[':
+		default:
+			yield(Element{}, fmt.Errorf("%w: the value at path %s is neither a JSON array nor a JSON object", ErrTypeMismatch, formatLocation(segments)))
+
+			return
+		}
+
+		// consume the starting delimiter
+		a.scan.pos++
+
+		done := ctx.Done()
+
+		for i := 0; ; i++ {
+			// The ending delimiter is consumed too, so that a truncated stream is reported.
+			more, err := a.scan.more(end, i)
+			if err != nil || !more {
+				if err != nil {
+					yield(Element{}, classify(err))
+				}
+
+				return
+			}
+
+			// check for context expiration
+			select {
+			case <-done:
+				yield(Element{}, fmt.Errorf("failed to iterate over the value at path %s in time: %w", formatLocation(segments), context.Cause(ctx)))
+
+				return
+			default:
+			}
+
+			e := Element{Index: i}
+
+			if end == '}' {
+				var escaped bool
+
+				if escaped, err = a.scan.readKey(); err == nil {
+					e.Key, err = a.scan.keyString(escaped)
+				}
+			}
+
+			var raw []byte
+
+			if err == nil {
+				raw, err = a.scan.raw()
+			}
+
+			if err != nil {
+				yield(Element{}, classify(err))
+
+				return
+			}
+
+			// The raw bytes are reused by the scanner.
+			e.Value = append(json.RawMessage(nil), raw...)
+
+			if !yield(e, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Each is like [Elements], but only yields the values of elements and members.
+func Each(ctx context.Context, stream io.Reader, path string) iter.Seq2[json.RawMessage, error] {
+	return func(yield func(json.RawMessage, error) bool) {
+		for e, err := range Elements(ctx, stream, path) {
+			if !yield(e.Value, err) {
+				return
+			}
+		}
+	}
+}
+
+// parseElementsPath returns the segments of path up to its trailing wildcard.
+func parseElementsPath(path string) ([]segment, error) {
+	segments, offsets, err := parseSteps(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(segments) == 0 || !segments[len(segments)-1].wildcard {
+		return nil, &PathError{Path: path, Offset: len(path), Msg: `path must end with "[*]"`}
+	}
+
+	segments = segments[:len(segments)-1]
+
+	for i := range segments {
+		if segments[i].wildcard {
+			return nil, &PathError{Path: path, Offset: offsets[i], Msg: "only the last step of the path can be a wildcard"}
+		}
+	}
+
+	return segments, nil
+}
@@ -0,0 +1,104 @@
+package jsonstream
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestElements(t *testing.T) {
+	contents := `
+	{
+		"name": "aws-cdk-lib",
+		"versions": {
+			"2.0.0-alpha.0": {"version": "2.0.0-alpha.0"},
+			"2.0.0": {"version": "2.0.0"}
+		},
+		"releases": [{"tag_name": "v1"}, {"tag_name": "v2"}, {"tag_name": "v3"}]
+	}
+	`
+
+	var members []Element
+
+	for e, err := range Elements(context.Background(), strings.NewReader(contents), ".versions[*]") {
+		require.NoError(t, err)
+
+		members = append(members, e)
+	}
+
+	require.Len(t, members, 2, "every member of the object should be yielded")
+	assert.Equal(t, "2.0.0-alpha.0", members[0].Key)
+	assert.Equal(t, 1, members[1].Index)
+	assert.JSONEq(t, `{"version": "2.0.0"}`, string(members[1].Value))
+
+	var tags []string
+
+	for raw, err := range Each(context.Background(), strings.NewReader(contents), ".releases[*]") {
+		require.NoError(t, err)
+
+		var release struct {
+			TagName string `json:"tag_name"`
+		}
+
+		require.NoError(t, json.Unmarshal(raw, &release))
+
+		tags = append(tags, release.TagName)
+	}
+
+	assert.Equal(t, []string{"v1", "v2", "v3"}, tags, "array elements should be yielded in order")
+}
+
+func TestElementsStopsEarly(t *testing.T) {
+	stream := io.MultiReader(strings.NewReader(`{"releases": [{"tag_name": "v1"}, `), failingReader{})
+
+	for raw, err := range Each(context.Background(), stream, ".releases[*]") {
+		require.NoError(t, err, "elements should be decoded lazily")
+		assert.JSONEq(t, `{"tag_name": "v1"}`, string(raw))
+
+		break
+	}
+}
+
+func TestElementsErrors(t *testing.T) {
+	tests := map[string]struct {
+		contents  string
+		path      string
+		errorWrap error
+	}{
+		"Scalar":    {contents: `{"a": 1}`, path: ".a[*]", errorWrap: ErrTypeMismatch},
+		"Missing":   {contents: `{"a": []}`, path: ".b[*]", errorWrap: ErrNotFound},
+		"Truncated": {contents: `{"a": [1, 2`, path: ".a[*]", errorWrap: ErrMalformed},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var last error
+
+			for _, err := range Each(context.Background(), strings.NewReader(tc.contents), tc.path) {
+				last = err
+			}
+
+			assert.ErrorIs(t, last, tc.errorWrap)
+		})
+	}
+
+	for _, path := range []string{".a", ".a[*].b", ".a[*][*]"} {
+		var pathErr *PathError
+
+		for _, err := range Each(context.Background(), strings.NewReader(`{}`), path) {
+			assert.ErrorAs(t, err, &pathErr, "path %q should be rejected", path)
+		}
+	}
+
+	_, err := NewAngler(strings.NewReader(`{}`), ".a[*]")
+
+	var pathErr *PathError
+
+	require.ErrorAs(t, err, &pathErr, "Angler should reject wildcards")
+	assert.Equal(t, 2, pathErr.Offset)
+}
@@ -0,0 +1,351 @@
+package jsonstream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+type (
+	// trie merges paths by their common prefixes, so that a single walk over the stream serves all of them.
+	trie struct {
+		children map[segment]*trie
+		// paths are the original strings of the paths that end at this node.
+		paths []string
+	}
+
+	fisher struct {
+		ctx       context.Context
+		scan      *scanner
+		found     map[string]any
+		remaining int
+	}
+)
+
+func (n *trie) insert(segments []segment, path string) {
+	for _, seg := range segments {
+		child, ok := n.children[seg]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[segment]*trie)
+			}
+
+			child = &trie{}
+			n.children[seg] = child
+		}
+
+		n = child
+	}
+
+	n.paths = append(n.paths, path)
+}
+
+// match returns the children of n for the member key of an object, or for the element at index of an array.
+// Segments of JSON Pointers match both, if the key is the index.
+func (n *trie) match(key string, index int) []*trie {
+	var children []*trie
+
+	if index < 0 {
+		if child, ok := n.children[segment{key: key}]; ok {
+			children = append(children, child)
+		}
+	} else if child, ok := n.children[segment{index: index, isIndex: true}]; ok {
+		children = append(children, child)
+	}
+
+	if seg := pointerSegment(key); seg.orIndex && (index < 0 || seg.index == index) {
+		if child, ok := n.children[seg]; ok {
+			children = append(children, child)
+		}
+	}
+
+	return children
+}
+
+// Fish returns the values at all paths, reading stream only once and regardless of the order of the paths in the document.
+// Reading stops as soon as all values have been found. Paths use the syntax of [NewAngler].
+// Values are decoded as [json.Unmarshal] does into any, so objects and arrays are returned as a whole.
+// Paths that are not found are left out of the returned map.
+// If a key appears more than once in an object, its first value wins, as with [Angler.Land].
+// Non-nil returned error is a [*PathError] for path syntax errors, and wraps [ErrMalformed] if the stream is not valid JSON.
+func Fish(ctx context.Context, stream io.Reader, paths ...string) (map[string]any, error) {
+	root, count, err := newTrie(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	return fish(ctx, newScanner(stream), root, count)
+}
+
+// newTrie parses paths into a trie, and returns the number of distinct paths in it.
+func newTrie(paths []string) (*trie, int, error) {
+	root := &trie{}
+	seen := make(map[string]bool, len(paths))
+
+	for _, path := range paths {
+		if seen[path] {
+			continue
+		}
+
+		segments, err := parsePath(path)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		root.insert(segments, path)
+		seen[path] = true
+	}
+
+	return root, len(seen), nil
+}
+
+// fish collects the count paths of root from the next value of scan.
+func fish(ctx context.Context, scan *scanner, root *trie, count int) (map[string]any, error) {
+	found := make(map[string]any, count)
+
+	if count == 0 {
+		return found, nil
+	}
+
+	f := fisher{ctx: ctx, scan: scan, found: found, remaining: count}
+
+	if err := f.walk(root); err != nil {
+		return found, classify(err)
+	}
+
+	return found, nil
+}
+
+func (f *fisher) checkContext() error {
+	select {
+	case <-f.ctx.Done():
+		return fmt.Errorf("failed to find %d of the paths in time: %w", f.remaining, context.Cause(f.ctx))
+	default:
+		return nil
+	}
+}
+
+// walk consumes the next value of the stream and collects the paths of node from it.
+func (f *fisher) walk(node *trie) error {
+	if len(node.paths) > 0 {
+		v, err := f.decode()
+		if err != nil {
+			return err
+		}
+
+		f.collect(node, v)
+
+		return nil
+	}
+
+	c, err := f.scan.peek()
+	if err != nil {
+		return err
+	}
+
+	switch c {
+	case '{':
+		// consume the starting '{' delimiter
+		f.scan.pos++
+
+		return f.walkObject(node)
+	case '[':
+		// consume the starting '[' delimiter
+		f.scan.pos++
+
+		return f.walkArray(node)
+	default:
+		// A scalar has nothing below it, so the paths of node don't exist.
+		return f.scan.skipValue()
+	}
+}
+
+// decode materializes the next value of the stream, as [json.Unmarshal] does into any.
+func (f *fisher) decode() (v any, err error) {
+	raw, err := f.scan.raw()
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(raw, &v)
+
+	return
+}
+
+// walkObject walks the members of an object whose '{' has been consumed.
+func (f *fisher) walkObject(node *trie) error {
+	for i := 0; f.remaining > 0; i++ {
+		more, err := f.scan.more('}', i)
+		if err != nil || !more {
+			return err
+		}
+
+		if err = f.checkContext(); err != nil {
+			return err
+		}
+
+		escaped, err := f.scan.readKey()
+		if err != nil {
+			return err
+		}
+
+		key, err := f.scan.keyString(escaped)
+		if err != nil {
+			return err
+		}
+
+		if err = f.walkChildren(node.match(key, -1)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkArray walks the elements of an array whose '[' has been consumed.
+// It resolves negative indices at the end of the array from its last elements, which are buffered.
+func (f *fisher) walkArray(node *trie) error {
+	var keep int
+
+	for seg := range node.children {
+		if seg.isIndex && seg.index < 0 {
+			keep = max(keep, -seg.index)
+		}
+	}
+
+	last := make([][]byte, keep)
+	count := 0
+
+	for ; f.remaining > 0; count++ {
+		more, err := f.scan.more(']', count)
+		if err != nil {
+			return err
+		}
+
+		if !more {
+			break
+		}
+
+		if err = f.checkContext(); err != nil {
+			return err
+		}
+
+		children := node.match(strconv.Itoa(count), count)
+
+		if keep > 0 {
+			var raw []byte
+
+			if raw, err = f.scan.raw(); err == nil {
+				slot := &last[count%keep]
+				*slot = append((*slot)[:0], raw...)
+
+				for _, child := range children {
+					if err = f.walkRaw(child, *slot); err != nil {
+						break
+					}
+				}
+			}
+		} else {
+			err = f.walkChildren(children)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if f.remaining == 0 {
+		return nil
+	}
+
+	for seg, child := range node.children {
+		if seg.isIndex && seg.index < 0 && count+seg.index >= 0 {
+			if err := f.walkRaw(child, last[(count+seg.index)%keep]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// walkChildren walks the next value of the stream for the trie nodes that it matches.
+// A value matched by more than one node is decoded, and the paths of all the nodes are collected from it.
+func (f *fisher) walkChildren(children []*trie) error {
+	switch len(children) {
+	case 0:
+		return f.scan.skipValue()
+	case 1:
+		return f.walk(children[0])
+	}
+
+	v, err := f.decode()
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		f.collect(child, v)
+	}
+
+	return nil
+}
+
+// walkRaw collects the paths of node from a buffered value.
+func (f *fisher) walkRaw(node *trie, raw []byte) error {
+	scan := f.scan
+	defer func() { f.scan = scan }()
+
+	f.scan = newBytesScanner(raw)
+
+	return f.walk(node)
+}
+
+// collect records v for the paths of node, and the values inside v for the paths below node.
+func (f *fisher) collect(node *trie, v any) {
+	for _, path := range node.paths {
+		if _, ok := f.found[path]; !ok {
+			f.found[path] = v
+			f.remaining--
+		}
+	}
+
+	for seg, child := range node.children {
+		if cv, ok := lookup(v, seg); ok {
+			f.collect(child, cv)
+		}
+	}
+}
+
+// lookup returns the value of seg in a decoded object or array.
+func lookup(v any, seg segment) (any, bool) {
+	switch v := v.(type) {
+	case map[string]any:
+		if seg.isIndex {
+			return nil, false
+		}
+
+		cv, ok := v[seg.key]
+
+		return cv, ok
+	case []any:
+		if !seg.isIndex && !seg.orIndex {
+			return nil, false
+		}
+
+		i := seg.index
+		if i < 0 {
+			i += len(v)
+		}
+
+		if i < 0 || i >= len(v) {
+			return nil, false
+		}
+
+		return v[i], true
+	default:
+		return nil, false
+	}
+}
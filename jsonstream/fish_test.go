@@ -0,0 +1,86 @@
+package jsonstream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("read past the targets")
+}
+
+func TestFish(t *testing.T) {
+	contents := `
+	{
+		"info": {"name": "toolkit", "version": "1.2.3"},
+		"releases": [
+			{"tag_name": "v1.0.0", "assets": [1, 2]},
+			{"tag_name": "v1.1.0", "assets": []},
+			{"tag_name": "v2.0.0", "assets": [3]}
+		],
+		"dist-tags": {"latest": "2.0.0"},
+		"versions": {"1.2.3": {"yanked": true}}
+	}
+	`
+
+	found, err := Fish(context.Background(), strings.NewReader(contents),
+		`.versions["1.2.3"].yanked`,
+		".releases[-1].tag_name",
+		".info.version",
+		".releases[0]",
+		".releases[0].assets[-1]",
+		".info",
+		".info.version",
+		".missing",
+		".info.name.too_deep",
+		".releases[5]",
+		"/releases/2/assets/0",
+		"/versions/1.2.3/yanked",
+	)
+	require.NoError(t, err, "should be able to fish all paths in one pass")
+
+	assert.Equal(t, map[string]any{
+		`.versions["1.2.3"].yanked`: true,
+		".releases[-1].tag_name":    "v2.0.0",
+		".info.version":             "1.2.3",
+		".releases[0]":              map[string]any{"tag_name": "v1.0.0", "assets": []any{float64(1), float64(2)}},
+		".releases[0].assets[-1]":   float64(2),
+		".info":                     map[string]any{"name": "toolkit", "version": "1.2.3"},
+		"/releases/2/assets/0":      float64(3),
+		"/versions/1.2.3/yanked":    true,
+	}, found, "values should be found regardless of the order of the paths, and missing paths should be left out")
+
+	_, err = Fish(context.Background(), strings.NewReader(contents), ".info", ".a[")
+
+	var pathErr *PathError
+
+	assert.ErrorAs(t, err, &pathErr, "invalid paths should be reported before reading")
+}
+
+func TestFishStopsEarly(t *testing.T) {
+	stream := io.MultiReader(strings.NewReader(`{"b": {"c": "x"}, "a": [1, "y", `), failingReader{})
+
+	found, err := Fish(context.Background(), stream, ".a[1]", ".b.c")
+	require.NoError(t, err, "the stream should not be read past the last target")
+
+	assert.Equal(t, map[string]any{".a[1]": "y", ".b.c": "x"}, found)
+}
+
+func TestFishScanning(t *testing.T) {
+	found, err := Fish(context.Background(), strings.NewReader(`{"a": {"skipped": [1, {"x": null}], "\u0062": "y"}, "c": 2}`), ".a.b", ".c")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{".a.b": "y", ".c": float64(2)}, found, "escaped keys should be decoded before they are matched")
+
+	for _, contents := range []string{`{"a": [1, 2`, `{"a": [1, 2}`, `{"b": tru, "a": 1}`, `{"b": [01], "a": 1}`} {
+		_, err = Fish(context.Background(), strings.NewReader(contents), ".a[-1]", ".a[0]")
+		assert.ErrorIs(t, err, ErrMalformed, "malformed JSON should be reported for %s", contents)
+	}
+}
@@ -0,0 +1,859 @@
+package jsonstream
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSONPath is a compiled JSONPath query, see [Compile] for the supported subset of RFC 9535.
+type JSONPath struct {
+	src      string
+	segments []jpSegment
+}
+
+type (
+	// jpSegment selects children of the input nodes, or of the input nodes and all their descendants.
+	jpSegment struct {
+		descendant bool
+		selectors  []selector
+	}
+
+	selectorKind int
+
+	selector struct {
+		kind  selectorKind
+		name  string
+		index int
+		// start, end and step of slices. Missing start and end take defaults that depend on the array's length.
+		start, end, step int
+		hasStart, hasEnd bool
+		filter           filterExpr
+	}
+
+	filterExpr interface {
+		test(current any) bool
+	}
+
+	orExpr  []filterExpr
+	andExpr []filterExpr
+	notExpr struct{ expr filterExpr }
+
+	// existsExpr tests whether a relative query selects a node, even if its value is null.
+	existsExpr struct{ query singularQuery }
+
+	compareExpr struct {
+		op          string
+		left, right operand
+	}
+
+	// operand is a singular relative query, or a literal if query is nil.
+	operand struct {
+		query   singularQuery
+		literal any
+	}
+
+	// singularQuery is a relative query of names and indices, which selects at most one node.
+	singularQuery []segment
+
+	jpParser struct {
+		src string
+		pos int
+	}
+)
+
+const (
+	nameSelector selectorKind = iota
+	wildcardSelector
+	indexSelector
+	sliceSelector
+	filterSelector
+)
+
+// maxExactInt is the largest integer that I-JSON numbers represent exactly, which bounds indices and slice parameters.
+const maxExactInt = 1<<53 - 1
+
+// Compile parses a JSONPath query of the following subset of RFC 9535.
+//
+//   - The root identifier "$", followed by child segments (".name", ".*" and "[selectors]")
+//     and descendant segments ("..name", "..*" and "..[selectors]").
+//   - Name, wildcard, index and slice selectors, separated by commas in brackets, e.g. `$.a['b.c', 0, -1, 1:5:2]`.
+//   - Filter selectors with literals, singular relative queries such as `@.a[0]['b']`, comparisons,
+//     existence tests, "!", "&&", "||" and parentheses, e.g. `$.releases[?@.prerelease == false && @.assets[0]]`.
+//
+// Absolute queries in filters ("$" inside "[?...]") and function extensions such as length() are not supported.
+// Non-nil returned error is a [*PathError] reporting where the query is invalid or unsupported.
+func Compile(query string) (*JSONPath, error) {
+	p := jpParser{src: query}
+
+	if !p.consume("$") {
+		return nil, p.errorf("query must start with the root identifier $")
+	}
+
+	var segments []jpSegment
+
+	for !p.eof() {
+		start := p.pos
+
+		p.skipSpace()
+
+		if p.eof() {
+			p.pos = start
+
+			return nil, p.errorf("query must not end with blank space")
+		}
+
+		seg, err := p.segment()
+		if err != nil {
+			return nil, err
+		}
+
+		segments = append(segments, seg)
+	}
+
+	return &JSONPath{src: query, segments: segments}, nil
+}
+
+// MustCompile is like [Compile] but panics if query is invalid, e.g. for package level variables.
+func MustCompile(query string) *JSONPath {
+	jp, err := Compile(query)
+	if err != nil {
+		panic(err)
+	}
+
+	return jp
+}
+
+func (jp *JSONPath) String() string {
+	return jp.src
+}
+
+func (p *jpParser) errorf(format string, args ...any) *PathError {
+	return &PathError{Path: p.src, Offset: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *jpParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *jpParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+
+	return p.src[p.pos]
+}
+
+func (p *jpParser) consume(s string) bool {
+	if strings.HasPrefix(p.src[p.pos:], s) {
+		p.pos += len(s)
+
+		return true
+	}
+
+	return false
+}
+
+// skipSpace skips blank space, which is space, tab, line feed and carriage return.
+func (p *jpParser) skipSpace() {
+	for !p.eof() && strings.IndexByte(" \t\n\r", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *jpParser) segment() (seg jpSegment, err error) {
+	switch {
+	case p.consume(".."):
+		seg.descendant = true
+
+		var sel selector
+
+		switch {
+		case p.peek() == '[':
+			seg.selectors, err = p.bracketed()
+		case p.consume("*"):
+			seg.selectors = []selector{{kind: wildcardSelector}}
+		default:
+			sel, err = p.shorthand()
+			seg.selectors = []selector{sel}
+		}
+	case p.consume("."):
+		var sel selector
+
+		if p.consume("*") {
+			sel.kind = wildcardSelector
+		} else {
+			sel, err = p.shorthand()
+		}
+
+		seg.selectors = []selector{sel}
+	case p.peek() == '[':
+		seg.selectors, err = p.bracketed()
+	default:
+		err = p.errorf(`expected ".", ".." or "["`)
+	}
+
+	return seg, err
+}
+
+// shorthand parses the member name of ".name".
+func (p *jpParser) shorthand() (selector, error) {
+	start := p.pos
+
+	for !p.eof() {
+		r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		if !isNameChar(r, p.pos == start) {
+			break
+		}
+
+		p.pos += size
+	}
+
+	if p.pos == start {
+		return selector{}, p.errorf("expected a member name or *")
+	}
+
+	return selector{kind: nameSelector, name: p.src[start:p.pos]}, nil
+}
+
+func isNameChar(r rune, first bool) bool {
+	switch {
+	case r == '_', 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+		return true
+	case '0' <= r && r <= '9':
+		return !first
+	default:
+		return r >= 0x80 && r != utf8.RuneError
+	}
+}
+
+func (p *jpParser) bracketed() ([]selector, error) {
+	// consume '['
+	p.pos++
+
+	var selectors []selector
+
+	for {
+		p.skipSpace()
+
+		sel, err := p.selector()
+		if err != nil {
+			return nil, err
+		}
+
+		selectors = append(selectors, sel)
+
+		p.skipSpace()
+
+		if p.consume("]") {
+			return selectors, nil
+		}
+
+		if !p.consume(",") {
+			return nil, p.errorf(`expected "," or "]"`)
+		}
+	}
+}
+
+func (p *jpParser) selector() (sel selector, err error) {
+	switch c := p.peek(); {
+	case c == '\'' || c == '"':
+		sel.kind = nameSelector
+		sel.name, err = p.stringLiteral()
+
+		return sel, err
+	case p.consume("*"):
+		sel.kind = wildcardSelector
+
+		return sel, nil
+	case p.consume("?"):
+		p.skipSpace()
+
+		sel.kind = filterSelector
+		sel.filter, err = p.logicalOr()
+
+		return sel, err
+	}
+
+	sel.kind = indexSelector
+
+	if c := p.peek(); c == '-' || '0' <= c && c <= '9' {
+		if sel.start, err = p.integer(); err != nil {
+			return sel, err
+		}
+
+		sel.index, sel.hasStart = sel.start, true
+
+		p.skipSpace()
+	}
+
+	if !p.consume(":") {
+		if !sel.hasStart {
+			return sel, p.errorf("expected a selector")
+		}
+
+		return sel, nil
+	}
+
+	sel.kind = sliceSelector
+	sel.step = 1
+
+	p.skipSpace()
+
+	if c := p.peek(); c == '-' || '0' <= c && c <= '9' {
+		if sel.end, err = p.integer(); err != nil {
+			return sel, err
+		}
+
+		sel.hasEnd = true
+
+		p.skipSpace()
+	}
+
+	if p.consume(":") {
+		p.skipSpace()
+
+		if c := p.peek(); c == '-' || '0' <= c && c <= '9' {
+			if sel.step, err = p.integer(); err != nil {
+				return sel, err
+			}
+		}
+	}
+
+	return sel, nil
+}
+
+// integer parses an integer without leading zeros, and rejects "-0" and integers that I-JSON cannot represent exactly.
+func (p *jpParser) integer() (int, error) {
+	start := p.pos
+
+	p.consume("-")
+
+	digits := p.pos
+
+	for !p.eof() && '0' <= p.src[p.pos] && p.src[p.pos] <= '9' {
+		p.pos++
+	}
+
+	s := p.src[start:p.pos]
+
+	switch {
+	case p.pos == digits:
+		return 0, p.errorf("expected digits")
+	case p.src[digits] == '0' && (p.pos-digits > 1 || digits > start):
+		p.pos = start
+
+		return 0, p.errorf("integers must not have leading zeros or be -0")
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n > maxExactInt || n < -maxExactInt {
+		p.pos = start
+
+		return 0, p.errorf("integer %s is out of range", s)
+	}
+
+	return n, nil
+}
+
+// stringLiteral parses a single or double quoted string with the escape sequences of RFC 9535.
+func (p *jpParser) stringLiteral() (string, error) {
+	quote := p.src[p.pos]
+
+	p.pos++
+
+	var sb strings.Builder
+
+	for !p.eof() {
+		c := p.src[p.pos]
+
+		switch {
+		case c == quote:
+			p.pos++
+
+			return sb.String(), nil
+		case c < 0x20:
+			return "", p.errorf("control characters must be escaped in string literals")
+		case c == '\\':
+			if err := p.escape(&sb, quote); err != nil {
+				return "", err
+			}
+		default:
+			sb.WriteByte(c)
+			p.pos++
+		}
+	}
+
+	return "", p.errorf("unterminated string literal")
+}
+
+func (p *jpParser) escape(sb *strings.Builder, quote byte) error {
+	start := p.pos
+
+	// consume '\'
+	p.pos++
+
+	if p.eof() {
+		return p.errorf("unterminated escape sequence")
+	}
+
+	c := p.src[p.pos]
+	p.pos++
+
+	switch c {
+	case 'b':
+		sb.WriteByte('\b')
+	case 'f':
+		sb.WriteByte('\f')
+	case 'n':
+		sb.WriteByte('\n')
+	case 'r':
+		sb.WriteByte('\r')
+	case 't':
+		sb.WriteByte('\t')
+	case '/', '\\', quote:
+		sb.WriteByte(c)
+	case 'u':
+		r, err := p.hex4()
+		if err != nil {
+			return err
+		}
+
+		if 0xD800 <= r && r <= 0xDBFF {
+			if !p.consume(`\u`) {
+				return p.errorf("expected the low surrogate of a surrogate pair")
+			}
+
+			low, err := p.hex4()
+			if err != nil {
+				return err
+			}
+
+			if low < 0xDC00 || low > 0xDFFF {
+				return p.errorf("invalid low surrogate")
+			}
+
+			r = 0x10000 + (r-0xD800)<<10 + (low - 0xDC00)
+		} else if 0xDC00 <= r && r <= 0xDFFF {
+			return p.errorf("unpaired low surrogate")
+		}
+
+		sb.WriteRune(r)
+	default:
+		p.pos = start
+
+		return p.errorf(`invalid escape sequence "\%c"`, c)
+	}
+
+	return nil
+}
+
+func (p *jpParser) hex4() (rune, error) {
+	if p.pos+4 > len(p.src) {
+		return 0, p.errorf("expected 4 hexadecimal digits")
+	}
+
+	n, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 32)
+	if err != nil {
+		return 0, p.errorf("expected 4 hexadecimal digits")
+	}
+
+	p.pos += 4
+
+	return rune(n), nil
+}
+
+func (p *jpParser) logicalOr() (filterExpr, error) {
+	var or orExpr
+
+	for {
+		and, err := p.logicalAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		or = append(or, and)
+
+		start := p.pos
+
+		p.skipSpace()
+
+		if !p.consume("||") {
+			p.pos = start
+
+			break
+		}
+
+		p.skipSpace()
+	}
+
+	if len(or) == 1 {
+		return or[0], nil
+	}
+
+	return or, nil
+}
+
+func (p *jpParser) logicalAnd() (filterExpr, error) {
+	var and andExpr
+
+	for {
+		basic, err := p.basic()
+		if err != nil {
+			return nil, err
+		}
+
+		and = append(and, basic)
+
+		start := p.pos
+
+		p.skipSpace()
+
+		if !p.consume("&&") {
+			p.pos = start
+
+			break
+		}
+
+		p.skipSpace()
+	}
+
+	if len(and) == 1 {
+		return and[0], nil
+	}
+
+	return and, nil
+}
+
+// basic parses parenthesized expressions, comparisons and existence tests.
+func (p *jpParser) basic() (filterExpr, error) {
+	if p.consume("!") {
+		p.skipSpace()
+
+		if p.peek() == '(' {
+			expr, err := p.paren()
+
+			return notExpr{expr: expr}, err
+		}
+
+		start := p.pos
+
+		query, err := p.relativeQuery()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.comparisonAhead() {
+			p.pos = start
+
+			return nil, p.errorf("comparisons cannot be negated without parentheses")
+		}
+
+		return notExpr{expr: existsExpr{query: query}}, nil
+	}
+
+	if p.peek() == '(' {
+		return p.paren()
+	}
+
+	left, isQuery, err := p.comparable()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.comparisonAhead() {
+		if !isQuery {
+			return nil, p.errorf("expected a comparison operator after a literal")
+		}
+
+		return existsExpr{query: left.query}, nil
+	}
+
+	p.skipSpace()
+
+	cmp := compareExpr{left: left}
+
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.consume(op) {
+			cmp.op = op
+
+			break
+		}
+	}
+
+	p.skipSpace()
+
+	if cmp.right, _, err = p.comparable(); err != nil {
+		return nil, err
+	}
+
+	return cmp, nil
+}
+
+func (p *jpParser) paren() (filterExpr, error) {
+	// consume '('
+	p.pos++
+
+	p.skipSpace()
+
+	expr, err := p.logicalOr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+
+	if !p.consume(")") {
+		return nil, p.errorf(`expected ")"`)
+	}
+
+	return expr, nil
+}
+
+// comparisonAhead reports whether a comparison operator follows, without consuming anything.
+func (p *jpParser) comparisonAhead() bool {
+	start := p.pos
+	defer func() { p.pos = start }()
+
+	p.skipSpace()
+
+	c := p.peek()
+
+	return c == '<' || c == '>' || strings.HasPrefix(p.src[p.pos:], "==") || strings.HasPrefix(p.src[p.pos:], "!=")
+}
+
+func (p *jpParser) comparable() (op operand, isQuery bool, err error) {
+	switch c := p.peek(); {
+	case c == '@':
+		op.query, err = p.relativeQuery()
+
+		return op, true, err
+	case c == '$':
+		return op, false, p.errorf("absolute queries in filters are not supported")
+	case c == '\'' || c == '"':
+		op.literal, err = p.stringLiteral()
+	case c == '-' || '0' <= c && c <= '9':
+		op.literal, err = p.number()
+	case p.consume("true"):
+		op.literal = true
+	case p.consume("false"):
+		op.literal = false
+	case p.consume("null"):
+		op.literal = nil
+	default:
+		if isNameChar(rune(c), true) {
+			return op, false, p.errorf("function extensions are not supported")
+		}
+
+		return op, false, p.errorf("expected a literal or a query starting with @")
+	}
+
+	return op, false, err
+}
+
+// number parses a number literal, which is a JSON number except that "-0" is allowed.
+func (p *jpParser) number() (float64, error) {
+	start := p.pos
+
+	p.consume("-")
+
+	digits := p.pos
+
+	for !p.eof() && '0' <= p.peek() && p.peek() <= '9' {
+		p.pos++
+	}
+
+	if p.pos == digits || p.src[digits] == '0' && p.pos-digits > 1 {
+		p.pos = start
+
+		return 0, p.errorf("invalid number literal")
+	}
+
+	if p.consume(".") {
+		fraction := p.pos
+
+		for !p.eof() && '0' <= p.peek() && p.peek() <= '9' {
+			p.pos++
+		}
+
+		if p.pos == fraction {
+			return 0, p.errorf("expected digits after the decimal point")
+		}
+	}
+
+	if c := p.peek(); c == 'e' || c == 'E' {
+		p.pos++
+
+		if c = p.peek(); c == '+' || c == '-' {
+			p.pos++
+		}
+
+		exponent := p.pos
+
+		for !p.eof() && '0' <= p.peek() && p.peek() <= '9' {
+			p.pos++
+		}
+
+		if p.pos == exponent {
+			return 0, p.errorf("expected digits in the exponent")
+		}
+	}
+
+	f, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+	if err != nil || math.IsInf(f, 0) {
+		p.pos = start
+
+		return 0, p.errorf("number literal is out of range")
+	}
+
+	return f, nil
+}
+
+// relativeQuery parses a singular relative query, i.e. "@" followed by names and indices.
+func (p *jpParser) relativeQuery() (singularQuery, error) {
+	if !p.consume("@") {
+		return nil, p.errorf("expected a query starting with @")
+	}
+
+	query := singularQuery{}
+
+	for {
+		switch {
+		case strings.HasPrefix(p.src[p.pos:], ".."):
+			return nil, p.errorf("only names and indices are supported in filter queries")
+		case p.consume("."):
+			sel, err := p.shorthand()
+			if err != nil {
+				return nil, err
+			}
+
+			query = append(query, segment{key: sel.name})
+		case p.peek() == '[':
+			start := p.pos
+
+			selectors, err := p.bracketed()
+			if err != nil {
+				return nil, err
+			}
+
+			if len(selectors) != 1 || selectors[0].kind != nameSelector && selectors[0].kind != indexSelector {
+				p.pos = start
+
+				return nil, p.errorf("only names and indices are supported in filter queries")
+			}
+
+			if sel := selectors[0]; sel.kind == nameSelector {
+				query = append(query, segment{key: sel.name})
+			} else {
+				query = append(query, segment{index: sel.index, isIndex: true})
+			}
+		default:
+			return query, nil
+		}
+	}
+}
+
+func (e orExpr) test(current any) bool {
+	for _, expr := range e {
+		if expr.test(current) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e andExpr) test(current any) bool {
+	for _, expr := range e {
+		if !expr.test(current) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (e notExpr) test(current any) bool {
+	return !e.expr.test(current)
+}
+
+func (e existsExpr) test(current any) bool {
+	_, ok := e.query.eval(current)
+
+	return ok
+}
+
+// eval returns the value of the operand, and false if it's a query that selects nothing.
+func (op operand) eval(current any) (any, bool) {
+	if op.query == nil {
+		return op.literal, true
+	}
+
+	return op.query.eval(current)
+}
+
+func (q singularQuery) eval(current any) (any, bool) {
+	v := current
+
+	for _, seg := range q {
+		var ok bool
+
+		if v, ok = lookup(v, seg); !ok {
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+// test compares the operands as RFC 9535 does, where Nothing only equals Nothing,
+// and only numbers and strings are ordered.
+func (e compareExpr) test(current any) bool {
+	left, leftOK := e.left.eval(current)
+	right, rightOK := e.right.eval(current)
+
+	equal := func() bool {
+		if !leftOK || !rightOK {
+			return leftOK == rightOK
+		}
+
+		return reflect.DeepEqual(left, right)
+	}
+
+	less := func(a, b any) bool {
+		if !leftOK || !rightOK {
+			return false
+		}
+
+		switch a := a.(type) {
+		case float64:
+			b, ok := b.(float64)
+
+			return ok && a < b
+		case string:
+			b, ok := b.(string)
+
+			return ok && a < b
+		default:
+			return false
+		}
+	}
+
+	switch e.op {
+	case "==":
+		return equal()
+	case "!=":
+		return !equal()
+	case "<":
+		return less(left, right)
+	case "<=":
+		return less(left, right) || equal()
+	case ">":
+		return less(right, left)
+	default:
+		return less(right, left) || equal()
+	}
+}
@@ -0,0 +1,242 @@
+package jsonstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The documents and queries below are the examples of RFC 9535.
+const (
+	bookstore = `
+	{ "store": {
+	    "book": [
+	      { "category": "reference", "author": "Nigel Rees", "title": "Sayings of the Century", "price": 8.95 },
+	      { "category": "fiction", "author": "Evelyn Waugh", "title": "Sword of Honour", "price": 12.99 },
+	      { "category": "fiction", "author": "Herman Melville", "title": "Moby Dick", "isbn": "0-553-21311-3", "price": 8.99 },
+	      { "category": "fiction", "author": "J. R. R. Tolkien", "title": "The Lord of the Rings", "isbn": "0-395-19395-8", "price": 22.99 }
+	    ],
+	    "bicycle": { "color": "red", "price": 399 }
+	  }
+	}`
+	filterDoc = `
+	{
+	  "a": [3, 5, 1, 2, 4, 6, {"b": "j"}, {"b": "k"}, {"b": {}}, {"b": "kilo"}],
+	  "o": {"p": 1, "q": 2, "r": 3, "s": 5, "t": {"u": 6}},
+	  "e": "f"
+	}`
+	descendantDoc = `{"o": {"j": 1, "k": 2}, "a": [5, 3, [{"j": 4}, {"k": 6}]]}`
+	letters       = `["a", "b", "c", "d", "e", "f", "g"]`
+	nulls         = `{"a": null, "b": [null], "c": [{}], "null": 1}`
+)
+
+func query(t *testing.T, expr, contents string) []Node {
+	t.Helper()
+
+	jp, err := Compile(expr)
+	require.NoError(t, err, "query %q should compile", expr)
+
+	var nodes []Node
+
+	for node, err := range jp.Query(context.Background(), strings.NewReader(contents)) {
+		require.NoError(t, err)
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes
+}
+
+func decodeValues(t *testing.T, nodes []Node) []any {
+	t.Helper()
+
+	values := make([]any, 0, len(nodes))
+
+	for _, node := range nodes {
+		var v any
+
+		require.NoError(t, json.Unmarshal(node.Value, &v))
+
+		values = append(values, v)
+	}
+
+	return values
+}
+
+func TestQueryConformance(t *testing.T) {
+	tests := []struct {
+		query    string
+		contents string
+		expected string
+	}{
+		{query: "$.store.book[*].author", contents: bookstore, expected: `["Nigel Rees", "Evelyn Waugh", "Herman Melville", "J. R. R. Tolkien"]`},
+		{query: "$..author", contents: bookstore, expected: `["Nigel Rees", "Evelyn Waugh", "Herman Melville", "J. R. R. Tolkien"]`},
+		{query: "$.store.*", contents: bookstore, expected: `[[{"category": "reference", "author": "Nigel Rees", "title": "Sayings of the Century", "price": 8.95}, {"category": "fiction", "author": "Evelyn Waugh", "title": "Sword of Honour", "price": 12.99}, {"category": "fiction", "author": "Herman Melville", "title": "Moby Dick", "isbn": "0-553-21311-3", "price": 8.99}, {"category": "fiction", "author": "J. R. R. Tolkien", "title": "The Lord of the Rings", "isbn": "0-395-19395-8", "price": 22.99}], {"color": "red", "price": 399}]`},
+		{query: "$.store..price", contents: bookstore, expected: `[8.95, 12.99, 8.99, 22.99, 399]`},
+		{query: "$..book[2]", contents: bookstore, expected: `[{"category": "fiction", "author": "Herman Melville", "title": "Moby Dick", "isbn": "0-553-21311-3", "price": 8.99}]`},
+		{query: "$..book[2].author", contents: bookstore, expected: `["Herman Melville"]`},
+		{query: "$..book[2].publisher", contents: bookstore, expected: `[]`},
+		{query: "$..book[-1].title", contents: bookstore, expected: `["The Lord of the Rings"]`},
+		{query: "$..book[0,1].title", contents: bookstore, expected: `["Sayings of the Century", "Sword of Honour"]`},
+		{query: "$..book[:2].title", contents: bookstore, expected: `["Sayings of the Century", "Sword of Honour"]`},
+		{query: "$..book[?@.isbn].title", contents: bookstore, expected: `["Moby Dick", "The Lord of the Rings"]`},
+		{query: "$..book[?@.price<10].title", contents: bookstore, expected: `["Sayings of the Century", "Moby Dick"]`},
+		{query: `$.o['j j']`, contents: `{"o": {"j j": {"k.k": 3}}, "'": {"@": 2}}`, expected: `[{"k.k": 3}]`},
+		{query: `$.o['j j']['k.k']`, contents: `{"o": {"j j": {"k.k": 3}}, "'": {"@": 2}}`, expected: `[3]`},
+		{query: `$.o["j j"]["k.k"]`, contents: `{"o": {"j j": {"k.k": 3}}, "'": {"@": 2}}`, expected: `[3]`},
+		{query: `$["'"]["@"]`, contents: `{"o": {"j j": {"k.k": 3}}, "'": {"@": 2}}`, expected: `[2]`},
+		{query: "$[*]", contents: `{"o": {"j": 1, "k": 2}, "a": [5, 3]}`, expected: `[{"j": 1, "k": 2}, [5, 3]]`},
+		{query: "$.o[*]", contents: `{"o": {"j": 1, "k": 2}, "a": [5, 3]}`, expected: `[1, 2]`},
+		{query: "$.a[*]", contents: `{"o": {"j": 1, "k": 2}, "a": [5, 3]}`, expected: `[5, 3]`},
+		{query: "$[1]", contents: `["a", "b"]`, expected: `["b"]`},
+		{query: "$[-2]", contents: `["a", "b"]`, expected: `["a"]`},
+		{query: "$[1:3]", contents: letters, expected: `["b", "c"]`},
+		{query: "$[5:]", contents: letters, expected: `["f", "g"]`},
+		{query: "$[1:5:2]", contents: letters, expected: `["b", "d"]`},
+		{query: "$[5:1:-2]", contents: letters, expected: `["f", "d"]`},
+		{query: "$[::-1]", contents: letters, expected: `["g", "f", "e", "d", "c", "b", "a"]`},
+		{query: "$.a[?@.b == 'kilo']", contents: filterDoc, expected: `[{"b": "kilo"}]`},
+		{query: "$.a[?(@.b == 'kilo')]", contents: filterDoc, expected: `[{"b": "kilo"}]`},
+		{query: "$.a[?@>3.5]", contents: filterDoc, expected: `[5, 4, 6]`},
+		{query: "$.a[?@.b]", contents: filterDoc, expected: `[{"b": "j"}, {"b": "k"}, {"b": {}}, {"b": "kilo"}]`},
+		{query: `$.a[?@<2 || @.b == "k"]`, contents: filterDoc, expected: `[1, {"b": "k"}]`},
+		{query: "$.o[?@>1 && @<4]", contents: filterDoc, expected: `[2, 3]`},
+		{query: "$.o[?@.u || @.x]", contents: filterDoc, expected: `[{"u": 6}]`},
+		{query: "$.a[?@ == @]", contents: filterDoc, expected: `[3, 5, 1, 2, 4, 6, {"b": "j"}, {"b": "k"}, {"b": {}}, {"b": "kilo"}]`},
+		{query: "$..j", contents: descendantDoc, expected: `[1, 4]`},
+		{query: "$..[0]", contents: descendantDoc, expected: `[5, {"j": 4}]`},
+		{query: "$..o", contents: descendantDoc, expected: `[{"j": 1, "k": 2}]`},
+		{query: "$.a..[0, 1]", contents: descendantDoc, expected: `[5, 3, {"j": 4}, {"k": 6}]`},
+		{query: "$..*", contents: descendantDoc, expected: `[{"j": 1, "k": 2}, [5, 3, [{"j": 4}, {"k": 6}]], 1, 2, 5, 3, [{"j": 4}, {"k": 6}], {"j": 4}, {"k": 6}, 4, 6]`},
+		{query: "$..[*]", contents: descendantDoc, expected: `[{"j": 1, "k": 2}, [5, 3, [{"j": 4}, {"k": 6}]], 1, 2, 5, 3, [{"j": 4}, {"k": 6}], {"j": 4}, {"k": 6}, 4, 6]`},
+		{query: "$[1, 0]", contents: letters, expected: `["b", "a"]`},
+		{query: "$[0, 0]", contents: letters, expected: `["a", "a"]`},
+		{query: "$[-1, 1:3, 0]", contents: letters, expected: `["g", "b", "c", "a"]`},
+		{query: "$[::-2]", contents: letters, expected: `["g", "e", "c", "a"]`},
+		{query: "$[-1::-3]", contents: letters, expected: `["g", "d", "a"]`},
+		{query: "$[1:5:0]", contents: letters, expected: `[]`},
+		{query: "$['k', 'j']", contents: `{"j": 1, "k": 2}`, expected: `[2, 1]`},
+		{query: "$[*, 'j']", contents: `{"j": 1, "k": 2}`, expected: `[1, 2, 1]`},
+		{query: "$..[1]", contents: `[[5, 6], 7]`, expected: `[7, 6]`},
+		{query: "$..x", contents: `{"a": {"x": 1}, "x": 2}`, expected: `[2, 1]`},
+		{query: "$..[?@ > 1]", contents: `[[3, 1], 2]`, expected: `[2, 3]`},
+		{query: "$[*][::-1]", contents: `[[1, 2], [3, 4]]`, expected: `[2, 1, 4, 3]`},
+		{query: "$[*]..[0]", contents: `[[[1], 2], [[3], 4]]`, expected: `[[1], 1, [3], 3]`},
+		{query: "$.a", contents: nulls, expected: `[null]`},
+		{query: "$.a[0]", contents: nulls, expected: `[]`},
+		{query: "$.a.d", contents: nulls, expected: `[]`},
+		{query: "$.b[0]", contents: nulls, expected: `[null]`},
+		{query: "$.b[*]", contents: nulls, expected: `[null]`},
+		{query: "$.b[?@]", contents: nulls, expected: `[null]`},
+		{query: "$.b[?@==null]", contents: nulls, expected: `[null]`},
+		{query: "$.c[?@.d==null]", contents: nulls, expected: `[]`},
+		{query: "$.null", contents: nulls, expected: `[1]`},
+		{query: "$", contents: `{"k": "v"}`, expected: `[{"k": "v"}]`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			var expected []any
+
+			require.NoError(t, json.Unmarshal([]byte(tc.expected), &expected))
+
+			assert.Equal(t, expected, decodeValues(t, query(t, tc.query, tc.contents)))
+		})
+	}
+}
+
+func TestQueryPaths(t *testing.T) {
+	nodes := query(t, "$..book[?@.price > 20].author", bookstore)
+
+	require.Len(t, nodes, 1)
+	assert.Equal(t, "$['store']['book'][3]['author']", nodes[0].Path)
+
+	nodes = query(t, `$[*]`, `{"it's": 1, "a\\b": 2, "\u000b": 3}`)
+
+	require.Len(t, nodes, 3)
+	assert.Equal(t, `$['it\'s']`, nodes[0].Path)
+	assert.Equal(t, `$['a\\b']`, nodes[1].Path)
+	assert.Equal(t, `$['\u000b']`, nodes[2].Path, "control characters should be escaped in lowercase hexadecimal")
+}
+
+func TestQueryReleases(t *testing.T) {
+	contents := `
+	{
+		"releases": [
+			{"tag_name": "v3.0.0-rc.1", "prerelease": true},
+			{"tag_name": "v2.1.0", "prerelease": false},
+			{"tag_name": "v2.0.0", "prerelease": false}
+		]
+	}
+	`
+
+	values := decodeValues(t, query(t, "$.releases[?@.prerelease == false].tag_name", contents))
+
+	assert.Equal(t, []any{"v2.1.0", "v2.0.0"}, values)
+
+	values = decodeValues(t, query(t, "$.releases[?!@.prerelease || @.tag_name >= 'v3'].tag_name", contents))
+
+	assert.Equal(t, []any{"v3.0.0-rc.1"}, values, "strings should be ordered and existence negated")
+}
+
+func TestQueryStopsEarly(t *testing.T) {
+	jp := MustCompile("$.a[*].version")
+	stream := io.MultiReader(strings.NewReader(`{"a": [{"version": "1.0.0"}, `), failingReader{})
+
+	for node, err := range jp.Query(context.Background(), stream) {
+		require.NoError(t, err, "nodes should be yielded as soon as they are found")
+		assert.Equal(t, `"1.0.0"`, string(node.Value))
+
+		break
+	}
+}
+
+func TestQueryMalformed(t *testing.T) {
+	var last error
+
+	for _, err := range MustCompile("$..a").Query(context.Background(), strings.NewReader(`{"a": [1, 2`)) {
+		last = err
+	}
+
+	assert.ErrorIs(t, last, ErrMalformed)
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := map[string]struct {
+		query  string
+		offset int
+	}{
+		"NoRoot":            {query: "a.b", offset: 0},
+		"TrailingDot":       {query: "$.", offset: 2},
+		"TrailingSpace":     {query: "$.a ", offset: 3},
+		"Unclosed":          {query: "$['a'", offset: 5},
+		"LeadingZero":       {query: "$[01]", offset: 2},
+		"MinusZero":         {query: "$[-0]", offset: 2},
+		"OutOfRange":        {query: "$[9007199254740992]", offset: 2},
+		"BadEscape":         {query: `$['\"']`, offset: 3},
+		"SpaceAfterDot":     {query: "$. a", offset: 2},
+		"DigitFirst":        {query: "$.1a", offset: 2},
+		"AbsoluteInFilter":  {query: "$[?@.a == $.b]", offset: 10},
+		"Function":          {query: "$[?length(@) < 3]", offset: 3},
+		"NegatedComparison": {query: "$[?!@.a == 1]", offset: 4},
+		"LiteralTest":       {query: "$[?1]", offset: 4},
+		"WildcardInFilter":  {query: "$[?@.a[*]]", offset: 6},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Compile(tc.query)
+
+			var pathErr *PathError
+
+			require.True(t, errors.As(err, &pathErr), "error should be a *PathError, got %v", err)
+			assert.Equal(t, tc.offset, pathErr.Offset, pathErr.Error())
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package jsonstream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// LandAs decodes the value at path in stream into a T, e.g. a string, float64, [json.Number], bool, struct or slice.
+// Paths use the syntax of [NewAngler].
+// Unlike [json.Unmarshal], a null value is a type mismatch unless T is a pointer, interface, map or slice,
+// so that a missing value is never mistaken for the zero value of T.
+// Non-nil returned error is a [*PathError] for path syntax errors, and wraps [ErrNotFound], [ErrTypeMismatch] or
+// [ErrMalformed] otherwise when it's one of them.
+func LandAs[T any](ctx context.Context, stream io.Reader, path string) (value T, err error) {
+	angler, err := NewAngler(stream, path)
+	if err != nil {
+		return value, err
+	}
+
+	var raw json.RawMessage
+
+	if err = angler.LandInto(ctx, &raw); err != nil {
+		return value, err
+	}
+
+	if bytes.Equal(raw, []byte("null")) && !nullable(reflect.TypeFor[T]()) {
+		return value, fmt.Errorf("%w: the value at path %s is null, which cannot be a %s", ErrTypeMismatch, formatLocation(angler.segments), reflect.TypeFor[T]())
+	}
+
+	if err = json.Unmarshal(raw, &value); err != nil {
+		return value, classify(fmt.Errorf("failed to decode the value at path %s: %w", formatLocation(angler.segments), err))
+	}
+
+	return value, nil
+}
+
+func nullable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,74 @@
+package jsonstream
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLandAs(t *testing.T) {
+	contents := `
+	{
+		"info": {"version": "1.2.3", "downloads": 12345678901234567890, "yanked": false, "summary": null},
+		"releases": [{"tag_name": "v1.0.0"}, {"tag_name": "v2.0.0"}]
+	}
+	`
+
+	type release struct {
+		TagName string `json:"tag_name"`
+	}
+
+	ctx := context.Background()
+
+	version, err := LandAs[string](ctx, strings.NewReader(contents), ".info.version")
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.3", version)
+
+	downloads, err := LandAs[json.Number](ctx, strings.NewReader(contents), ".info.downloads")
+	require.NoError(t, err)
+	assert.Equal(t, json.Number("12345678901234567890"), downloads, "json.Number should keep all digits")
+
+	yanked, err := LandAs[bool](ctx, strings.NewReader(contents), ".info.yanked")
+	require.NoError(t, err)
+	assert.False(t, yanked)
+
+	releases, err := LandAs[[]release](ctx, strings.NewReader(contents), ".releases")
+	require.NoError(t, err)
+	assert.Equal(t, []release{{TagName: "v1.0.0"}, {TagName: "v2.0.0"}}, releases)
+
+	latest, err := LandAs[release](ctx, strings.NewReader(contents), ".releases[-1]")
+	require.NoError(t, err)
+	assert.Equal(t, release{TagName: "v2.0.0"}, latest)
+
+	summary, err := LandAs[*string](ctx, strings.NewReader(contents), ".info.summary")
+	require.NoError(t, err, "null should be decoded into pointers")
+	assert.Nil(t, summary)
+
+	_, err = LandAs[string](ctx, strings.NewReader(contents), ".info.summary")
+	assert.ErrorIs(t, err, ErrTypeMismatch, "null should not be decoded into a string")
+
+	_, err = LandAs[string](ctx, strings.NewReader(contents), "/info/summary")
+	assert.EqualError(t, err, `type mismatch: the value at path ".info.summary" (JSON Pointer "/info/summary") is null, which cannot be a string`)
+
+	_, err = LandAs[float64](ctx, strings.NewReader(contents), "/releases/0/tag_name")
+	assert.ErrorContains(t, err, `failed to decode the value at path ".releases[0].tag_name" (JSON Pointer "/releases/0/tag_name")`)
+
+	_, err = LandAs[float64](ctx, strings.NewReader(contents), ".info.version")
+	assert.ErrorIs(t, err, ErrTypeMismatch, "a string should not be decoded into a float64")
+
+	_, err = LandAs[string](ctx, strings.NewReader(contents), ".info.license")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = LandAs[string](ctx, strings.NewReader(`{"info": {"version": `), ".info.version")
+	assert.ErrorIs(t, err, ErrMalformed)
+
+	_, err = LandAs[string](ctx, strings.NewReader(contents), "info")
+
+	var pathErr *PathError
+
+	assert.ErrorAs(t, err, &pathErr)
+}
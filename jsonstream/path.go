@@ -0,0 +1,275 @@
+package jsonstream
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type (
+	// PathError reports a syntax error in a path.
+	PathError struct {
+		Path string
+		// Offset is the byte offset in Path where the error was found.
+		Offset int
+		Msg    string
+	}
+
+	// segment is a step of a path: an object key, an array index, or a wildcard for all elements or members.
+	segment struct {
+		key      string
+		index    int
+		isIndex  bool
+		wildcard bool
+		// orIndex means that key is also index, which applies if the value is an array.
+		// JSON Pointer doesn't tell keys and array indices apart, so it's resolved by the document.
+		orIndex bool
+	}
+)
+
+func (e *PathError) Error() string {
+	return fmt.Sprintf("invalid path %q at offset %d: %s", e.Path, e.Offset, e.Msg)
+}
+
+// parsePath parses paths of the following grammar, where keys of the dot notation are any characters but "." and "[",
+// and quoted keys are JSON strings.
+//
+//	path    = "." [ key ] *step
+//	step    = "." [ key ] / "[" ( index / quoted / "*" ) "]"
+//	index   = [ "-" ] 1*DIGIT
+//
+// A dot directly followed by "[" has no key, so that ".[0]" is the first element of a top-level array.
+// Wildcards are rejected, because they are only supported by [Elements] and [Each].
+// The empty string and paths that start with "/" are JSON Pointers instead, see parsePointer.
+func parsePath(path string) ([]segment, error) {
+	if path == "" || strings.HasPrefix(path, "/") {
+		return parsePointer(path)
+	}
+
+	segments, offsets, err := parseSteps(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range segments {
+		if segments[i].wildcard {
+			return nil, &PathError{Path: path, Offset: offsets[i], Msg: "wildcards are only supported by Elements and Each"}
+		}
+	}
+
+	return segments, nil
+}
+
+// parsePointer parses a JSON Pointer of RFC 6901, e.g. "/info/version" or "/paths/~1users/get", where "~1" stands for "/"
+// and "~0" for "~". Tokens that are array indices without leading zeros are keys or indices, depending on the document.
+func parsePointer(path string) ([]segment, error) {
+	var segments []segment
+
+	for i := 0; i < len(path); {
+		// skip the '/' before the token
+		i++
+
+		end := i + strings.IndexByte(path[i:], '/')
+		if end < i {
+			end = len(path)
+		}
+
+		token := path[i:end]
+
+		for j := 0; j < len(token); j++ {
+			if token[j] == '~' && (j+1 == len(token) || token[j+1] != '0' && token[j+1] != '1') {
+				return nil, &PathError{Path: path, Offset: i + j, Msg: `"~" must be followed by "0" or "1" in JSON Pointers`}
+			}
+		}
+
+		segments = append(segments, pointerSegment(strings.NewReplacer("~1", "/", "~0", "~").Replace(token)))
+		i = end
+	}
+
+	return segments, nil
+}
+
+func pointerSegment(token string) segment {
+	seg := segment{key: token}
+
+	if token == "0" || token != "" && token[0] != '0' && strings.Trim(token, "0123456789") == "" {
+		if index, err := strconv.Atoi(token); err == nil {
+			seg.index, seg.orIndex = index, true
+		}
+	}
+
+	return seg
+}
+
+// parseSteps parses path like parsePath does, but accepts wildcards.
+// It also returns the offset in path where each segment starts.
+func parseSteps(path string) (segments []segment, offsets []int, err error) {
+	if !strings.HasPrefix(path, ".") {
+		return nil, nil, &PathError{Path: path, Offset: 0, Msg: `path must start with the dot character "."`}
+	}
+
+	var seg segment
+
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			i++
+
+			if i == len(path) {
+				return nil, nil, &PathError{Path: path, Offset: i - 1, Msg: `path must not end with the dot character "."`}
+			}
+
+			if path[i] == '[' {
+				continue
+			}
+
+			end := i + strings.IndexAny(path[i:], ".[")
+			if end < i {
+				end = len(path)
+			}
+
+			segments = append(segments, segment{key: path[i:end]})
+			offsets = append(offsets, i-1)
+			i = end
+		case '[':
+			offsets = append(offsets, i)
+
+			seg, i, err = parseBracket(path, i)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			segments = append(segments, seg)
+		default:
+			return nil, nil, &PathError{Path: path, Offset: i, Msg: `expected "." or "[" after "]"`}
+		}
+	}
+
+	return segments, offsets, nil
+}
+
+// parseBracket parses the bracket step that starts at path[start], and returns the offset right after it.
+func parseBracket(path string, start int) (seg segment, next int, err error) {
+	i := start + 1
+
+	if i < len(path) && path[i] == '*' {
+		seg.wildcard = true
+		i++
+	} else if i < len(path) && path[i] == '"' {
+		end := quotedEnd(path, i)
+		if end < 0 {
+			return seg, 0, &PathError{Path: path, Offset: i, Msg: "unterminated quoted key"}
+		}
+
+		if err = json.Unmarshal([]byte(path[i:end]), &seg.key); err != nil {
+			return seg, 0, &PathError{Path: path, Offset: i, Msg: "invalid quoted key: " + err.Error()}
+		}
+
+		i = end
+	} else {
+		end := i
+		for end < len(path) && (path[end] == '-' || '0' <= path[end] && path[end] <= '9') {
+			end++
+		}
+
+		if seg.index, err = strconv.Atoi(path[i:end]); err != nil {
+			return seg, 0, &PathError{Path: path, Offset: i, Msg: `expected an array index, a quoted key or "*"`}
+		}
+
+		seg.isIndex = true
+		i = end
+	}
+
+	if i == len(path) || path[i] != ']' {
+		return seg, 0, &PathError{Path: path, Offset: i, Msg: `expected "]"`}
+	}
+
+	return seg, i + 1, nil
+}
+
+// quotedEnd returns the offset right after the JSON string that starts at path[start], or -1 if it's unterminated.
+func quotedEnd(path string, start int) int {
+	for i := start + 1; i < len(path); i++ {
+		switch path[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+
+	return -1
+}
+
+// formatPath is the reverse of parsePath. Keys that cannot be written in the dot notation, or would be hard to read, are quoted.
+func formatPath(segments []segment) string {
+	if len(segments) == 0 {
+		return "."
+	}
+
+	var sb strings.Builder
+
+	for i, seg := range segments {
+		simple := !seg.isIndex && !seg.wildcard && seg.key != "" && !strings.ContainsAny(seg.key, `.[]" `)
+
+		// Paths always start with a dot.
+		if i == 0 && !simple {
+			sb.WriteString(".")
+		}
+
+		switch {
+		case seg.wildcard:
+			sb.WriteString("[*]")
+		case seg.isIndex:
+			fmt.Fprintf(&sb, "[%d]", seg.index)
+		case simple:
+			sb.WriteString("." + seg.key)
+		default:
+			sb.WriteString("[" + quoteKey(seg.key) + "]")
+		}
+	}
+
+	return sb.String()
+}
+
+// formatPointer formats segments as a JSON Pointer, or returns false if a negative index cannot be written as one.
+func formatPointer(segments []segment) (string, bool) {
+	var sb strings.Builder
+
+	for _, seg := range segments {
+		switch {
+		case seg.isIndex && seg.index < 0:
+			return "", false
+		case seg.isIndex:
+			fmt.Fprintf(&sb, "/%d", seg.index)
+		default:
+			sb.WriteString("/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(seg.key))
+		}
+	}
+
+	return sb.String(), true
+}
+
+// formatLocation formats segments for error messages, both in the dot notation and as a JSON Pointer.
+func formatLocation(segments []segment) string {
+	pointer, ok := formatPointer(segments)
+	if !ok {
+		return strconv.Quote(formatPath(segments))
+	}
+
+	return fmt.Sprintf("%q (JSON Pointer %q)", formatPath(segments), pointer)
+}
+
+func quoteKey(key string) string {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	// Encoding a string cannot fail.
+	_ = enc.Encode(key)
+
+	return strings.TrimSuffix(buf.String(), "\n")
+}
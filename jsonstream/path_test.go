@@ -0,0 +1,97 @@
+package jsonstream
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected []segment
+	}{
+		{path: ".a.b", expected: []segment{{key: "a"}, {key: "b"}}},
+		{path: ".d..s", expected: []segment{{key: "d"}, {key: ""}, {key: "s"}}},
+		{path: ".e f.g", expected: []segment{{key: "e f"}, {key: "g"}}},
+		{path: ".releases[0].tag_name", expected: []segment{{key: "releases"}, {index: 0, isIndex: true}, {key: "tag_name"}}},
+		{path: ".[-1][2]", expected: []segment{{index: -1, isIndex: true}, {index: 2, isIndex: true}}},
+		{path: `.releases["1.2.3"]`, expected: []segment{{key: "releases"}, {key: "1.2.3"}}},
+		{path: `.["say \"hi\"]"].x`, expected: []segment{{key: `say "hi"]`}, {key: "x"}}},
+		{path: `.[""]`, expected: []segment{{key: ""}}},
+	}
+
+	for _, tc := range tests {
+		segments, err := parsePath(tc.path)
+		require.NoError(t, err, "path %q should be valid", tc.path)
+
+		assert.Equal(t, tc.expected, segments, "segments of %q", tc.path)
+
+		roundTrip, err := parsePath(formatPath(segments))
+		require.NoError(t, err, "formatted path of %q should be valid", tc.path)
+
+		assert.Equal(t, segments, roundTrip, "formatted path of %q should parse to the same segments", tc.path)
+	}
+}
+
+func TestParsePointer(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected []segment
+	}{
+		{path: "", expected: nil},
+		{path: "/", expected: []segment{{key: ""}}},
+		{path: "/info/version", expected: []segment{{key: "info"}, {key: "version"}}},
+		{path: "/releases/0/tag_name", expected: []segment{{key: "releases"}, {key: "0", orIndex: true}, {key: "tag_name"}}},
+		{path: "/a~1b/m~0n", expected: []segment{{key: "a/b"}, {key: "m~n"}}},
+		{path: "/~01", expected: []segment{{key: "~1"}}},
+		{path: "/12/01/-1/-", expected: []segment{{key: "12", index: 12, orIndex: true}, {key: "01"}, {key: "-1"}, {key: "-"}}},
+	}
+
+	for _, tc := range tests {
+		segments, err := parsePath(tc.path)
+		require.NoError(t, err, "JSON Pointer %q should be valid", tc.path)
+
+		assert.Equal(t, tc.expected, segments, "segments of %q", tc.path)
+	}
+
+	pointer, ok := formatPointer([]segment{{key: "a/b"}, {index: 3, isIndex: true}, {key: "m~n"}})
+
+	assert.True(t, ok)
+	assert.Equal(t, "/a~1b/3/m~0n", pointer)
+
+	_, ok = formatPointer([]segment{{index: -1, isIndex: true}})
+
+	assert.False(t, ok, "negative indices cannot be written as JSON Pointers")
+}
+
+func TestParsePathErrors(t *testing.T) {
+	tests := []struct {
+		path   string
+		offset int
+	}{
+		{path: "a.b", offset: 0},
+		{path: ".a.", offset: 2},
+		{path: ".a[", offset: 3},
+		{path: ".a[1", offset: 4},
+		{path: ".a[x]", offset: 3},
+		{path: ".a[1]b", offset: 5},
+		{path: `.a["b]`, offset: 3},
+		{path: `.a["b"`, offset: 6},
+		{path: `.a["\x"]`, offset: 3},
+		{path: ".a[--1]", offset: 3},
+		{path: "/a/b~2", offset: 4},
+		{path: "/a~", offset: 2},
+	}
+
+	for _, tc := range tests {
+		_, err := parsePath(tc.path)
+
+		var pathErr *PathError
+
+		require.ErrorAs(t, err, &pathErr, "path %q should be invalid", tc.path)
+
+		assert.Equal(t, tc.offset, pathErr.Offset, "error position in %q: %s", tc.path, pathErr.Msg)
+	}
+}
@@ -0,0 +1,592 @@
+package jsonstream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"strings"
+)
+
+type (
+	// Node is a value selected by a [JSONPath] query.
+	Node struct {
+		// Path is the normalized path of RFC 9535 that locates the value in the document, e.g. `$['releases'][0]`.
+		Path  string
+		Value json.RawMessage
+	}
+
+	// evaluator walks the stream once for the leading segments of the query that select nodes in document order,
+	// and evaluates the rest of the query in memory on each node that they select.
+	evaluator struct {
+		ctx      context.Context
+		segments []jpSegment
+		// streamed is how many leading segments are evaluated on the stream.
+		streamed int
+		scan     *scanner
+		path     []segment
+		yield    func(Node, error) bool
+	}
+
+	// child describes an element or member, as seen by selectors.
+	child struct {
+		segment
+		// value is the decoded value, which is only available when filters need it.
+		value any
+		// length is the length of the parent array, or -1 if it's not known yet.
+		length int
+	}
+
+	// tree is a value buffered in memory, with its children in document order.
+	tree struct {
+		raw json.RawMessage
+		// keys are the keys of the members of an object, which are its children. They're nil for arrays and scalars.
+		keys     []string
+		children []*tree
+		isObject bool
+		// value is the decoded value for filters, which is only set once decoded is.
+		value   any
+		decoded bool
+	}
+)
+
+// errStopped unwinds the walk when the consumer stops iterating.
+var errStopped = errors.New("iteration stopped")
+
+// Query yields the nodes that jp selects from stream, in the order of RFC 9535, reading the stream only once.
+//
+// Child segments with a single selector, other than slices with negative steps, select nodes in document order.
+// As long as the query starts with such segments, it's evaluated as the stream is read, so that nodes are yielded
+// as soon as they are found: filters decode one element or member at a time to test it, and negative indices and
+// slices that count from the end buffer the array.
+// The other segments order nodes differently from the document, so each node that the first of them starts from
+// is buffered and the rest of the query is evaluated in memory.
+// For example, `$..name` buffers the whole document, while `$.items[*]..name` buffers one item at a time.
+// An error is yielded at most once, as the last pair, and it wraps [ErrMalformed] if the stream is not valid JSON.
+func (jp *JSONPath) Query(ctx context.Context, stream io.Reader) iter.Seq2[Node, error] {
+	return func(yield func(Node, error) bool) {
+		e := evaluator{ctx: ctx, segments: jp.segments, scan: newScanner(stream), yield: yield}
+
+		for e.streamed < len(e.segments) && e.segments[e.streamed].inDocumentOrder() {
+			e.streamed++
+		}
+
+		err := e.walk(0)
+
+		switch {
+		case errors.Is(err, errStopped):
+		case err != nil:
+			yield(Node{}, classify(err))
+		}
+	}
+}
+
+// inDocumentOrder reports whether seg selects the children of a node in document order.
+func (seg jpSegment) inDocumentOrder() bool {
+	if seg.descendant || len(seg.selectors) != 1 {
+		return false
+	}
+
+	return seg.selectors[0].kind != sliceSelector || seg.selectors[0].step >= 0
+}
+
+func (e *evaluator) checkContext() error {
+	select {
+	case <-e.ctx.Done():
+		return fmt.Errorf("failed to query the stream in time: %w", context.Cause(e.ctx))
+	default:
+		return nil
+	}
+}
+
+// walk consumes the next value of the stream, which the first k segments have selected.
+func (e *evaluator) walk(k int) error {
+	if k == e.streamed {
+		raw, err := e.scan.raw()
+		if err != nil {
+			return err
+		}
+
+		// The bytes of raw are reused by the scanner, but nodes are kept by the consumer.
+		raw = bytes.Clone(raw)
+
+		if k == len(e.segments) {
+			return e.emit(raw)
+		}
+
+		t, err := parseTree(raw)
+		if err != nil {
+			return err
+		}
+
+		return e.eval(t, e.segments[k:])
+	}
+
+	c, err := e.scan.peek()
+	if err != nil {
+		return err
+	}
+
+	switch c {
+	case '{':
+		// consume the starting '{' delimiter
+		e.scan.pos++
+
+		return e.walkObject(k)
+	case '[':
+		// consume the starting '[' delimiter
+		e.scan.pos++
+
+		return e.walkArray(k)
+	default:
+		// A scalar has no children.
+		return e.scan.skipValue()
+	}
+}
+
+func (e *evaluator) emit(raw json.RawMessage) error {
+	if !e.yield(Node{Path: normalizedPath(e.path), Value: raw}, nil) {
+		return errStopped
+	}
+
+	return nil
+}
+
+// walkRaw walks a buffered value with fn.
+func (e *evaluator) walkRaw(raw []byte, fn func() error) error {
+	scan := e.scan
+	defer func() { e.scan = scan }()
+
+	e.scan = newBytesScanner(raw)
+
+	return fn()
+}
+
+// walkObject walks the members of an object whose '{' has been consumed.
+func (e *evaluator) walkObject(k int) error {
+	for i := 0; ; i++ {
+		more, err := e.scan.more('}', i)
+		if err != nil || !more {
+			return err
+		}
+
+		if err = e.checkContext(); err != nil {
+			return err
+		}
+
+		escaped, err := e.scan.readKey()
+		if err != nil {
+			return err
+		}
+
+		key, err := e.scan.keyString(escaped)
+		if err != nil {
+			return err
+		}
+
+		if err = e.walkChild(k, child{segment: segment{key: key}, length: -1}); err != nil {
+			return err
+		}
+	}
+}
+
+// walkArray walks the elements of an array whose '[' has been consumed.
+// It buffers the whole array if the selector needs its length, and walks its elements one at a time otherwise.
+func (e *evaluator) walkArray(k int) error {
+	buffer := e.segments[k].selectors[0].needsLength()
+
+	var elements [][]byte
+
+	for i := 0; ; i++ {
+		more, err := e.scan.more(']', i)
+		if err != nil {
+			return err
+		}
+
+		if !more {
+			break
+		}
+
+		if err = e.checkContext(); err != nil {
+			return err
+		}
+
+		if !buffer {
+			if err = e.walkChild(k, child{segment: segment{index: i, isIndex: true}, length: -1}); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		raw, err := e.scan.raw()
+		if err != nil {
+			return err
+		}
+
+		elements = append(elements, bytes.Clone(raw))
+	}
+
+	for i, raw := range elements {
+		c := child{segment: segment{index: i, isIndex: true}, length: len(elements)}
+
+		if err := e.walkRaw(raw, func() error { return e.walkChild(k, c) }); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkChild walks the next value of the stream, which is c, if the selector of the k-th segment selects it.
+func (e *evaluator) walkChild(k int, c child) error {
+	e.path = append(e.path, c.segment)
+	defer func() { e.path = e.path[:len(e.path)-1] }()
+
+	sel := e.segments[k].selectors[0]
+
+	if sel.kind != filterSelector {
+		if !sel.matches(c) {
+			return e.scan.skipValue()
+		}
+
+		return e.walk(k + 1)
+	}
+
+	raw, err := e.scan.raw()
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(raw, &c.value); err != nil {
+		return err
+	}
+
+	if !sel.matches(c) {
+		return nil
+	}
+
+	// The scanner is set aside while raw is walked, so its bytes aren't reused in the meantime.
+	return e.walkRaw(raw, func() error { return e.walk(k + 1) })
+}
+
+func (sel selector) needsLength() bool {
+	switch sel.kind {
+	case indexSelector:
+		return sel.index < 0
+	case sliceSelector:
+		return sel.hasStart && sel.start < 0 || sel.hasEnd && sel.end < 0
+	default:
+		return false
+	}
+}
+
+func (sel selector) matches(c child) bool {
+	switch sel.kind {
+	case nameSelector:
+		return !c.isIndex && c.key == sel.name
+	case wildcardSelector:
+		return true
+	case indexSelector:
+		if !c.isIndex {
+			return false
+		}
+
+		if sel.index < 0 {
+			return c.length >= 0 && c.index == c.length+sel.index
+		}
+
+		return c.index == sel.index
+	case sliceSelector:
+		return c.isIndex && sel.inSlice(c.index, c.length)
+	default:
+		return sel.filter.test(c.value)
+	}
+}
+
+// inSlice reports whether the slice, whose step is positive, selects index i of an array of length n.
+// n is -1 if it's not known, which is only the case when the slice doesn't count from the end.
+func (sel selector) inSlice(i, n int) bool {
+	if sel.step <= 0 {
+		return false
+	}
+
+	if n < 0 {
+		n = maxExactInt
+	}
+
+	lower, upper := sel.bounds(n)
+
+	return lower <= i && i < upper && (i-lower)%sel.step == 0
+}
+
+// bounds returns the bounds of the slice for an array of length n, as specified in section 2.3.4.2.2 of RFC 9535.
+func (sel selector) bounds(n int) (lower, upper int) {
+	normalize := func(j int) int {
+		if j < 0 {
+			return n + j
+		}
+
+		return j
+	}
+
+	if sel.step >= 0 {
+		start, end := 0, n
+
+		if sel.hasStart {
+			start = normalize(sel.start)
+		}
+
+		if sel.hasEnd {
+			end = normalize(sel.end)
+		}
+
+		return min(max(start, 0), n), min(max(end, 0), n)
+	}
+
+	start, end := n-1, -n-1
+
+	if sel.hasStart {
+		start = normalize(sel.start)
+	}
+
+	if sel.hasEnd {
+		end = normalize(sel.end)
+	}
+
+	return min(max(end, -1), n-1), min(max(start, -1), n-1)
+}
+
+// eval applies segments to t, which is at e.path, yielding the selected nodes in the order of RFC 9535.
+// A descendant segment applies to t before its descendants, which are visited depth first in document order.
+func (e *evaluator) eval(t *tree, segments []jpSegment) error {
+	if len(segments) == 0 {
+		return e.emit(t.raw)
+	}
+
+	if err := e.checkContext(); err != nil {
+		return err
+	}
+
+	seg := segments[0]
+
+	for _, sel := range seg.selectors {
+		if err := t.selectChildren(sel, func(i int) error { return e.evalChild(t, i, segments[1:]) }); err != nil {
+			return err
+		}
+	}
+
+	if !seg.descendant {
+		return nil
+	}
+
+	for i := range t.children {
+		if err := e.evalChild(t, i, segments); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *evaluator) evalChild(t *tree, i int, segments []jpSegment) error {
+	c := segment{index: i, isIndex: true}
+	if t.isObject {
+		c = segment{key: t.keys[i]}
+	}
+
+	e.path = append(e.path, c)
+	defer func() { e.path = e.path[:len(e.path)-1] }()
+
+	return e.eval(t.children[i], segments)
+}
+
+// selectChildren calls fn with the index of each child of t that sel selects, in the order of RFC 9535.
+func (t *tree) selectChildren(sel selector, fn func(int) error) error {
+	n := len(t.children)
+
+	switch sel.kind {
+	case nameSelector:
+		for i, key := range t.keys {
+			if key == sel.name {
+				return fn(i)
+			}
+		}
+	case wildcardSelector:
+		for i := range n {
+			if err := fn(i); err != nil {
+				return err
+			}
+		}
+	case indexSelector:
+		i := sel.index
+		if i < 0 {
+			i += n
+		}
+
+		if !t.isObject && i >= 0 && i < n {
+			return fn(i)
+		}
+	case sliceSelector:
+		if t.isObject {
+			return nil
+		}
+
+		lower, upper := sel.bounds(n)
+
+		switch {
+		case sel.step > 0:
+			for i := lower; i < upper; i += sel.step {
+				if err := fn(i); err != nil {
+					return err
+				}
+			}
+		case sel.step < 0:
+			for i := upper; lower < i; i += sel.step {
+				if err := fn(i); err != nil {
+					return err
+				}
+			}
+		}
+	default:
+		for i, c := range t.children {
+			if c.decode() != nil || !sel.filter.test(c.value) {
+				continue
+			}
+
+			if err := fn(i); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (t *tree) decode() error {
+	if t.decoded {
+		return nil
+	}
+
+	if err := json.Unmarshal(t.raw, &t.value); err != nil {
+		return err
+	}
+
+	t.decoded = true
+
+	return nil
+}
+
+// parseTree buffers data, which holds a single valid JSON value, as a tree.
+func parseTree(data []byte) (*tree, error) {
+	return newBytesScanner(data).tree(data)
+}
+
+// tree consumes the next value as a tree, whose raw bytes are sliced from data, the bytes that s scans.
+func (s *scanner) tree(data []byte) (*tree, error) {
+	c, err := s.peek()
+	if err != nil {
+		return nil, err
+	}
+
+	t := tree{isObject: c == '{'}
+	start := s.pos
+
+	switch c {
+	case '{', '[':
+		end := byte(']')
+		if t.isObject {
+			end = '}'
+		}
+
+		// consume the starting delimiter
+		s.pos++
+
+		for i := 0; ; i++ {
+			more, err := s.more(end, i)
+			if err != nil {
+				return nil, err
+			} else if !more {
+				break
+			}
+
+			if t.isObject {
+				escaped, err := s.readKey()
+				if err != nil {
+					return nil, err
+				}
+
+				key, err := s.keyString(escaped)
+				if err != nil {
+					return nil, err
+				}
+
+				t.keys = append(t.keys, key)
+			}
+
+			child, err := s.tree(data)
+			if err != nil {
+				return nil, err
+			}
+
+			t.children = append(t.children, child)
+		}
+	default:
+		if err = s.skipValue(); err != nil {
+			return nil, err
+		}
+	}
+
+	t.raw = data[start:s.pos]
+
+	return &t, nil
+}
+
+// normalizedPath formats path as a normalized path of RFC 9535.
+func normalizedPath(path []segment) string {
+	var sb strings.Builder
+
+	sb.WriteString("$")
+
+	for _, seg := range path {
+		if seg.isIndex {
+			fmt.Fprintf(&sb, "[%d]", seg.index)
+
+			continue
+		}
+
+		sb.WriteString("['")
+
+		for _, r := range seg.key {
+			switch r {
+			case '\b':
+				sb.WriteString(`\b`)
+			case '\f':
+				sb.WriteString(`\f`)
+			case '\n':
+				sb.WriteString(`\n`)
+			case '\r':
+				sb.WriteString(`\r`)
+			case '\t':
+				sb.WriteString(`\t`)
+			case '\'':
+				sb.WriteString(`\'`)
+			case '\\':
+				sb.WriteString(`\\`)
+			default:
+				if r < 0x20 {
+					fmt.Fprintf(&sb, `\u%04x`, r)
+				} else {
+					sb.WriteRune(r)
+				}
+			}
+		}
+
+		sb.WriteString("']")
+	}
+
+	return sb.String()
+}
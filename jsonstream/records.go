@@ -0,0 +1,101 @@
+package jsonstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+)
+
+type (
+	// Record holds the values found in a document of a stream of documents.
+	Record struct {
+		// Number counts documents from 1, including malformed ones.
+		Number int
+		// Values maps paths to their values in the document, as [Fish] returns them.
+		Values map[string]any
+	}
+
+	// RecordError reports an error in a document of a stream of documents.
+	RecordError struct {
+		Number int
+		Err    error
+	}
+)
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("record %d: %s", e.Number, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// Records yields the values at paths in each document of stream, which is a sequence of JSON documents,
+// such as newline-delimited JSON (NDJSON or JSON Lines), or documents that are simply concatenated.
+// Documents are read one at a time, and paths are found in each of them as [Fish] does.
+//
+// Errors in documents are yielded as [*RecordError], which wraps [ErrMalformed] if the document is not valid JSON.
+// Reading resumes after the line where a malformed document starts, so that the rest of an NDJSON stream is still read
+// even if a line is truncated.
+// Iteration ends after read errors and context expiration, and a [*PathError] is yielded for path syntax errors.
+func Records(ctx context.Context, stream io.Reader, paths ...string) iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		root, count, err := newTrie(paths)
+		if err != nil {
+			yield(Record{}, err)
+
+			return
+		}
+
+		scan := newScanner(stream)
+
+		for number := 1; ; number++ {
+			if end, err := scan.atEnd(); end {
+				return
+			} else if err != nil {
+				yield(Record{}, &RecordError{Number: number, Err: classify(err)})
+
+				return
+			}
+
+			// check for context expiration
+			select {
+			case <-ctx.Done():
+				yield(Record{}, &RecordError{Number: number, Err: fmt.Errorf("failed to read the record in time: %w", context.Cause(ctx))})
+
+				return
+			default:
+			}
+
+			raw, err := scan.raw()
+			if err != nil {
+				if !errors.Is(err, ErrMalformed) {
+					yield(Record{}, &RecordError{Number: number, Err: err})
+
+					return
+				}
+
+				scan.resync()
+
+				if !yield(Record{}, &RecordError{Number: number, Err: err}) {
+					return
+				}
+
+				continue
+			}
+
+			values, err := fish(ctx, newBytesScanner(raw), root, count)
+			if err != nil {
+				yield(Record{}, &RecordError{Number: number, Err: err})
+
+				return
+			}
+
+			if !yield(Record{Number: number, Values: values}, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package jsonstream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecords(t *testing.T) {
+	// the output of `go test -json`
+	contents := `{"Action":"start","Package":"a"}
+{"Action":"run","Package":"a","Test":"TestX"}
+
+{"Action":"pass","Package":"a","Test":"TestX","Elapsed":0.01}
+`
+
+	var (
+		numbers []int
+		actions []any
+		tests   []any
+	)
+
+	for record, err := range Records(context.Background(), iotest.OneByteReader(strings.NewReader(contents)), ".Action", ".Test") {
+		require.NoError(t, err)
+
+		numbers = append(numbers, record.Number)
+		actions = append(actions, record.Values[".Action"])
+		tests = append(tests, record.Values[".Test"])
+	}
+
+	assert.Equal(t, []int{1, 2, 3}, numbers, "blank lines should not count as records")
+	assert.Equal(t, []any{"start", "run", "pass"}, actions)
+	assert.Equal(t, []any{nil, "TestX", "TestX"}, tests, "missing paths should be left out of a record")
+
+	var values []any
+
+	for record, err := range Records(context.Background(), strings.NewReader(`{"a": 1}{"a": 2} {"a": [3]}[]`), ".a") {
+		require.NoError(t, err)
+
+		values = append(values, record.Values[".a"])
+	}
+
+	assert.Equal(t, []any{float64(1), float64(2), []any{float64(3)}, nil}, values, "concatenated documents should be split")
+}
+
+func TestRecordsErrors(t *testing.T) {
+	contents := `{"a": 1}
+{"a": oops}
+{"a": 3}
+{"a": 4`
+
+	var (
+		values []any
+		errs   []error
+	)
+
+	for record, err := range Records(context.Background(), strings.NewReader(contents), ".a") {
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		values = append(values, record.Values[".a"])
+	}
+
+	assert.Equal(t, []any{float64(1), float64(3)}, values, "malformed lines should be skipped")
+	require.Len(t, errs, 2)
+
+	for i, number := range []int{2, 4} {
+		var recordErr *RecordError
+
+		require.True(t, errors.As(errs[i], &recordErr), "errors should be *RecordError, got %v", errs[i])
+		assert.Equal(t, number, recordErr.Number)
+		assert.ErrorIs(t, errs[i], ErrMalformed)
+	}
+
+	// The truncated line is only found malformed at the start of the next one, which must not be skipped.
+	contents = "{\"a\":1}\n{\"a\":2\n{\"a\":3}\n{\"a\":[\n\n{\"a\":5}\n{\"a\":6}\n"
+
+	for _, stream := range []io.Reader{strings.NewReader(contents), iotest.OneByteReader(strings.NewReader(contents))} {
+		var numbers, failed []int
+
+		for record, err := range Records(context.Background(), stream, ".a") {
+			var recordErr *RecordError
+
+			if errors.As(err, &recordErr) {
+				failed = append(failed, recordErr.Number)
+
+				continue
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, float64(record.Number), record.Values[".a"], "record %d should be numbered by where it starts", record.Number)
+
+			numbers = append(numbers, record.Number)
+		}
+
+		assert.Equal(t, []int{1, 3, 5, 6}, numbers, "lines after truncated lines should be read")
+		assert.Equal(t, []int{2, 4}, failed)
+	}
+
+	for _, err := range Records(context.Background(), strings.NewReader(contents), ".a[") {
+		var pathErr *PathError
+
+		assert.ErrorAs(t, err, &pathErr, "invalid paths should be reported before reading")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, err := range Records(ctx, strings.NewReader(contents), ".a") {
+		assert.ErrorIs(t, err, context.Canceled)
+	}
+}
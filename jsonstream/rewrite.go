@@ -0,0 +1,260 @@
+package jsonstream
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Rule rewrites the value at Path, which uses the syntax of [NewAngler] without negative indices.
+	Rule struct {
+		Path string
+		// Value replaces the value at Path. The value is deleted if Value is nil,
+		// together with its key if it's an object member.
+		Value json.RawMessage
+	}
+
+	rewriter struct {
+		ctx   context.Context
+		scan  *scanner
+		rules map[string]Rule
+	}
+)
+
+// redacted replaces the values that [Redact] removes.
+var redacted = json.RawMessage(`"REDACTED"`)
+
+// Redact copies the JSON document in stream to w, replacing the values at paths with the string "REDACTED",
+// e.g. to share credential-process output without `.SecretAccessKey` and `.SessionToken`. See [Rewrite].
+func Redact(ctx context.Context, w io.Writer, stream io.Reader, paths ...string) error {
+	rules := make([]Rule, 0, len(paths))
+
+	for _, path := range paths {
+		rules = append(rules, Rule{Path: path, Value: redacted})
+	}
+
+	return Rewrite(ctx, w, stream, rules...)
+}
+
+// Rewrite copies the JSON document in stream to w, replacing or deleting the values at the paths of rules.
+// Everything else is copied byte for byte, so the order of members and the formatting are preserved,
+// except for the white space around deleted values.
+// The document is not buffered, and values are only read as far as needed to copy them.
+// Sequences of documents, such as NDJSON, are rewritten document by document.
+// If a path appears in more than one rule, the first rule wins.
+//
+// Non-nil returned error is a [*PathError] for path syntax errors and negative indices, and wraps [ErrMalformed]
+// if stream is not valid JSON, in which case w has received part of the document.
+func Rewrite(ctx context.Context, w io.Writer, stream io.Reader, rules ...Rule) error {
+	root := &trie{}
+	byPath := make(map[string]Rule, len(rules))
+
+	for _, rule := range rules {
+		if _, ok := byPath[rule.Path]; ok {
+			continue
+		}
+
+		segments, err := parsePath(rule.Path)
+		if err != nil {
+			return err
+		}
+
+		for _, seg := range segments {
+			if seg.isIndex && seg.index < 0 {
+				return &PathError{Path: rule.Path, Offset: strings.Index(rule.Path, "[-"), Msg: "negative indices cannot be rewritten, because the stream is not buffered"}
+			}
+		}
+
+		if rule.Value != nil && !json.Valid(rule.Value) {
+			return fmt.Errorf("the value that replaces path %q is not valid JSON", rule.Path)
+		}
+
+		root.insert(segments, rule.Path)
+		byPath[rule.Path] = rule
+	}
+
+	bw := bufio.NewWriter(w)
+
+	r := rewriter{ctx: ctx, scan: newScanner(stream), rules: byPath}
+	r.scan.tee = bw
+
+	for {
+		end, err := r.scan.atEnd()
+		if err == nil && !end {
+			err = r.value([]*trie{root})
+		}
+
+		if err != nil {
+			return classify(err)
+		}
+
+		if end {
+			break
+		}
+	}
+
+	r.scan.flushTee()
+
+	if r.scan.teeErr == nil {
+		r.scan.teeErr = bw.Flush()
+	}
+
+	if r.scan.teeErr != nil {
+		return fmt.Errorf("failed to write the rewritten document: %w", r.scan.teeErr)
+	}
+
+	return nil
+}
+
+// rule returns the rule of the first node that is the end of a path.
+func (r *rewriter) rule(nodes []*trie) (Rule, bool) {
+	for _, node := range nodes {
+		if len(node.paths) > 0 {
+			return r.rules[node.paths[0]], true
+		}
+	}
+
+	return Rule{}, false
+}
+
+// value copies the next value, rewriting it or the values below it for nodes.
+func (r *rewriter) value(nodes []*trie) error {
+	if rule, ok := r.rule(nodes); ok {
+		return r.replace(rule.Value)
+	}
+
+	c, err := r.scan.peek()
+	if err != nil {
+		return err
+	}
+
+	// Values without paths below them are copied as they are.
+	if !slices.ContainsFunc(nodes, func(node *trie) bool { return len(node.children) > 0 }) {
+		return r.scan.skipValue()
+	}
+
+	switch c {
+	case '{':
+		return r.container(nodes, '}')
+	case '[':
+		return r.container(nodes, ']')
+	default:
+		return r.scan.skipValue()
+	}
+}
+
+// replace skips the next value and writes value instead.
+func (r *rewriter) replace(value json.RawMessage) error {
+	// keep the white space before the value
+	if _, err := r.scan.peek(); err != nil {
+		return err
+	}
+
+	r.scan.flushTee()
+	r.scan.teeMode = teeDrop
+
+	if err := r.scan.skipValue(); err != nil {
+		return err
+	}
+
+	r.scan.dropTee()
+	r.scan.write(value)
+	r.scan.teeMode = teeWrite
+
+	return nil
+}
+
+// container copies an object or array, depending on its end delimiter.
+// The separator and key of each member are held until it's known whether the member is deleted.
+func (r *rewriter) container(nodes []*trie, end byte) error {
+	// consume the starting delimiter
+	r.scan.pos++
+
+	done := r.ctx.Done()
+	written := 0
+
+	for i := 0; ; i++ {
+		// check for context expiration
+		select {
+		case <-done:
+			return fmt.Errorf("failed to rewrite the stream in time: %w", context.Cause(r.ctx))
+		default:
+		}
+
+		if r.scan.teeErr != nil {
+			return fmt.Errorf("failed to write the rewritten document: %w", r.scan.teeErr)
+		}
+
+		r.scan.flushTee()
+		r.scan.teeMode = teeHold
+
+		more, err := r.scan.more(end, i)
+		if err != nil {
+			return err
+		}
+
+		if !more {
+			r.scan.flushTee()
+
+			return nil
+		}
+
+		// hold the white space before the member too
+		if _, err = r.scan.peek(); err != nil {
+			return err
+		}
+
+		// The members before were all deleted, so this one must not be preceded by a separator.
+		if i > 0 && written == 0 {
+			r.scan.dropTee()
+		}
+
+		var children []*trie
+
+		if end == '}' {
+			escaped, err := r.scan.readKey()
+			if err != nil {
+				return err
+			}
+
+			key, err := r.scan.keyString(escaped)
+			if err != nil {
+				return err
+			}
+
+			for _, node := range nodes {
+				children = append(children, node.match(key, -1)...)
+			}
+		} else {
+			for _, node := range nodes {
+				children = append(children, node.match(strconv.Itoa(i), i)...)
+			}
+		}
+
+		if rule, ok := r.rule(children); ok && rule.Value == nil {
+			r.scan.teeMode = teeDrop
+
+			if err = r.scan.skipValue(); err != nil {
+				return err
+			}
+
+			r.scan.dropTee()
+
+			continue
+		}
+
+		written++
+
+		r.scan.flushTee()
+
+		if err = r.value(children); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package jsonstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedact(t *testing.T) {
+	// credential-process output
+	contents := `{
+  "Version": 1,
+  "AccessKeyId": "ASIAEXAMPLE",
+  "SecretAccessKey": "secret",
+  "SessionToken": "token",
+  "Expiration": "2026-10-18T12:00:00Z"
+}
+`
+
+	var sb strings.Builder
+
+	require.NoError(t, Redact(context.Background(), &sb, iotest.OneByteReader(strings.NewReader(contents)), ".SecretAccessKey", ".SessionToken"))
+
+	assert.Equal(t, `{
+  "Version": 1,
+  "AccessKeyId": "ASIAEXAMPLE",
+  "SecretAccessKey": "REDACTED",
+  "SessionToken": "REDACTED",
+  "Expiration": "2026-10-18T12:00:00Z"
+}
+`, sb.String(), "only the values at the paths should change")
+}
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		contents string
+		rules    []Rule
+		expected string
+	}{
+		{
+			contents: `{"a": 1, "s": "x", "b": 2}`,
+			rules:    []Rule{{Path: ".s"}},
+			expected: `{"a": 1, "b": 2}`,
+		},
+		{
+			contents: `{"s": "x", "t": [1], "a": 1}`,
+			rules:    []Rule{{Path: ".s"}, {Path: ".t"}},
+			expected: `{"a": 1}`,
+		},
+		{
+			contents: `{"a": 1, "s": {"deep": [1, 2]}}`,
+			rules:    []Rule{{Path: ".s"}},
+			expected: `{"a": 1}`,
+		},
+		{
+			contents: `{"s": "x"}`,
+			rules:    []Rule{{Path: ".s"}},
+			expected: `{}`,
+		},
+		{
+			contents: `{"creds": [{"key": "k1", "secret": "s1"}, {"key": "k2"}], "secret": "kept"}`,
+			rules:    []Rule{{Path: ".creds[0].secret", Value: json.RawMessage(`null`)}, {Path: ".creds[1]"}},
+			expected: `{"creds": [{"key": "k1", "secret": null}], "secret": "kept"}`,
+		},
+		{
+			contents: `[0, 1, 2]`,
+			rules:    []Rule{{Path: ".[0]"}, {Path: "/2", Value: json.RawMessage(`{"x": true}`)}},
+			expected: `[1, {"x": true}]`,
+		},
+		{
+			contents: `{"a": {"b": 1}, "a": {"b": 2}, "s": 3}`,
+			rules:    []Rule{{Path: ".a.b", Value: json.RawMessage(`0`)}, {Path: ".s", Value: json.RawMessage(`4`)}, {Path: ".s"}},
+			expected: `{"a": {"b": 0}, "a": {"b": 0}, "s": 4}`,
+		},
+		{
+			contents: "{\"t\": \"x\", \"n\": 1}\n{\"n\": 2}\n[\"not an object\"]\n",
+			rules:    []Rule{{Path: ".t", Value: json.RawMessage(`"y"`)}},
+			expected: "{\"t\": \"y\", \"n\": 1}\n{\"n\": 2}\n[\"not an object\"]\n",
+		},
+		{
+			contents: ` {"a": 1} `,
+			rules:    []Rule{{Path: "", Value: json.RawMessage(`"replaced"`)}},
+			expected: ` "replaced" `,
+		},
+	}
+
+	for _, tc := range tests {
+		var sb strings.Builder
+
+		require.NoError(t, Rewrite(context.Background(), &sb, strings.NewReader(tc.contents), tc.rules...), "rewriting %s", tc.contents)
+
+		assert.Equal(t, tc.expected, sb.String(), "rewriting %s", tc.contents)
+	}
+}
+
+func TestRewriteStreams(t *testing.T) {
+	// A value that spans many reads is dropped as it's read, and the rest is copied as it is.
+	secret := strings.Repeat("s", 3*scanBufferSize)
+	kept := strings.Repeat("k", 3*scanBufferSize)
+	contents := `{"secret": "` + secret + `", "kept": "` + kept + `"}`
+
+	var sb strings.Builder
+
+	require.NoError(t, Rewrite(context.Background(), &sb, strings.NewReader(contents), Rule{Path: ".secret"}))
+
+	assert.Equal(t, `{"kept": "`+kept+`"}`, sb.String())
+}
+
+func TestRewriteErrors(t *testing.T) {
+	var sb strings.Builder
+
+	err := Rewrite(context.Background(), &sb, strings.NewReader(`[1]`), Rule{Path: ".a[-1]"})
+
+	var pathErr *PathError
+
+	require.True(t, errors.As(err, &pathErr), "negative indices should be rejected, got %v", err)
+	assert.Equal(t, 2, pathErr.Offset)
+
+	err = Rewrite(context.Background(), &sb, strings.NewReader(`[1]`), Rule{Path: ".[0]", Value: json.RawMessage(`{`)})
+	assert.Error(t, err, "replacements should be valid JSON")
+
+	err = Redact(context.Background(), &sb, strings.NewReader(`{"a": 1, "b" 2}`), ".a")
+	assert.ErrorIs(t, err, ErrMalformed)
+
+	err = Redact(context.Background(), failingWriter{}, strings.NewReader(`{"a": 1}`), ".a")
+	assert.ErrorContains(t, err, "failed to write the rewritten document")
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
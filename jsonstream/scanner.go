@@ -0,0 +1,599 @@
+package jsonstream
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type (
+	// scanner reads JSON at the byte level. Unlike [json.Decoder.Token], it doesn't allocate for keys and values that are
+	// skipped, and only the value that is wanted is materialized, as raw bytes.
+	// Skipped values are still validated, so that malformed streams are reported.
+	scanner struct {
+		r   io.Reader
+		buf []byte
+		// pos is the next unread byte of buf, and end is where the data read into buf ends.
+		pos, end int
+		// offset is the offset of buf[0] in the stream.
+		offset int64
+		// err is the error that ended reading, reported once buf is exhausted.
+		err error
+		// capture accumulates the bytes of the value being materialized that have been discarded from buf,
+		// and captureAt is where the rest of the value starts in buf.
+		capture   []byte
+		captureAt int
+		capturing bool
+		// key holds the raw bytes of the last key that was read.
+		key   []byte
+		depth int
+		// tee receives the bytes that are consumed, from teeAt in buf, unless it's nil. How depends on teeMode.
+		tee     io.Writer
+		teeAt   int
+		teeMode teeMode
+		teeErr  error
+		// held are bytes discarded from buf in teeHold mode, which are written by flushTee or dropped by dropTee.
+		held []byte
+	}
+
+	// teeMode tells how the scanner's tee receives consumed bytes.
+	teeMode int
+)
+
+const (
+	// teeWrite writes consumed bytes to the tee.
+	teeWrite teeMode = iota
+	// teeHold holds consumed bytes until it's known whether they are wanted.
+	teeHold
+	// teeDrop drops consumed bytes.
+	teeDrop
+)
+
+const (
+	scanBufferSize = 4 << 10
+	// maxScanDepth bounds the nesting of objects and arrays, as encoding/json does.
+	maxScanDepth = 10000
+)
+
+func newScanner(r io.Reader) *scanner {
+	return &scanner{r: r, buf: make([]byte, scanBufferSize)}
+}
+
+// newBytesScanner returns a scanner over data, which is never written to.
+func newBytesScanner(data []byte) *scanner {
+	return &scanner{buf: data, end: len(data), err: io.EOF}
+}
+
+// fill reads more data into buf after it's exhausted, and returns false if there is none.
+func (s *scanner) fill() bool {
+	if s.err != nil {
+		return false
+	}
+
+	if s.capturing {
+		s.capture = append(s.capture, s.buf[s.captureAt:s.end]...)
+		s.captureAt = 0
+	}
+
+	if s.tee != nil {
+		switch s.teeMode {
+		case teeWrite:
+			s.write(s.buf[s.teeAt:s.end])
+		case teeHold:
+			s.held = append(s.held, s.buf[s.teeAt:s.end]...)
+		}
+
+		s.teeAt = 0
+	}
+
+	s.offset += int64(s.end)
+	s.pos, s.end = 0, 0
+
+	for s.end == 0 && s.err == nil {
+		s.end, s.err = s.r.Read(s.buf)
+	}
+
+	return s.end > 0
+}
+
+func (s *scanner) write(p []byte) {
+	if s.teeErr == nil && len(p) > 0 {
+		_, s.teeErr = s.tee.Write(p)
+	}
+}
+
+// flushTee writes the held bytes and those consumed since, and switches to teeWrite mode.
+func (s *scanner) flushTee() {
+	s.write(s.held)
+	s.write(s.buf[s.teeAt:s.pos])
+
+	s.held = s.held[:0]
+	s.teeAt = s.pos
+	s.teeMode = teeWrite
+}
+
+// dropTee drops the held bytes and those consumed since.
+func (s *scanner) dropTee() {
+	s.held = s.held[:0]
+	s.teeAt = s.pos
+}
+
+// endErr reports the end of data, which is premature if the stream ended.
+func (s *scanner) endErr() error {
+	if s.err == nil || errors.Is(s.err, io.EOF) {
+		return fmt.Errorf("%w: %w", ErrMalformed, io.ErrUnexpectedEOF)
+	}
+
+	return s.err
+}
+
+// unexpected reports the byte at pos as invalid.
+func (s *scanner) unexpected() error {
+	if s.pos >= s.end {
+		return s.endErr()
+	}
+
+	return fmt.Errorf("%w: invalid character %q at offset %d", ErrMalformed, s.buf[s.pos], s.offset+int64(s.pos))
+}
+
+// peek skips white space and returns the next byte without consuming it.
+func (s *scanner) peek() (byte, error) {
+	for {
+		for ; s.pos < s.end; s.pos++ {
+			switch c := s.buf[s.pos]; c {
+			case ' ', '\t', '\n', '\r':
+			default:
+				return c, nil
+			}
+		}
+
+		if !s.fill() {
+			return 0, s.endErr()
+		}
+	}
+}
+
+// peekRaw returns the next byte without skipping white space. ok is false at the end of the stream.
+func (s *scanner) peekRaw() (c byte, ok bool, err error) {
+	if s.pos == s.end && !s.fill() {
+		if errors.Is(s.err, io.EOF) {
+			return 0, false, nil
+		}
+
+		return 0, false, s.err
+	}
+
+	return s.buf[s.pos], true, nil
+}
+
+// atEnd skips white space and reports whether the stream has ended, e.g. after the last of a sequence of documents.
+func (s *scanner) atEnd() (bool, error) {
+	if _, err := s.peek(); err != nil {
+		if s.pos >= s.end && errors.Is(s.err, io.EOF) {
+			return true, nil
+		}
+
+		return false, err
+	}
+
+	return false, nil
+}
+
+// skipLine consumes the rest of the current line, including its line feed.
+func (s *scanner) skipLine() {
+	for {
+		if i := bytes.IndexByte(s.buf[s.pos:s.end], '\n'); i >= 0 {
+			s.pos += i + 1
+
+			return
+		}
+
+		s.pos = s.end
+
+		if !s.fill() {
+			return
+		}
+	}
+}
+
+func (s *scanner) readByte() (byte, error) {
+	if s.pos == s.end && !s.fill() {
+		return 0, s.endErr()
+	}
+
+	s.pos++
+
+	return s.buf[s.pos-1], nil
+}
+
+// expect consumes c after white space.
+func (s *scanner) expect(c byte) error {
+	if next, err := s.peek(); err != nil {
+		return err
+	} else if next != c {
+		return s.unexpected()
+	}
+
+	s.pos++
+
+	return nil
+}
+
+// more consumes the separator before the element or member at i of an array or object whose end delimiter is end,
+// and reports whether there is one. The end delimiter is consumed if there isn't.
+func (s *scanner) more(end byte, i int) (bool, error) {
+	c, err := s.peek()
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case c == end:
+		s.pos++
+
+		return false, nil
+	case i == 0:
+		return true, nil
+	case c == ',':
+		s.pos++
+
+		return true, nil
+	default:
+		return false, s.unexpected()
+	}
+}
+
+// raw materializes the next value. The returned bytes are only valid until the next call.
+func (s *scanner) raw() ([]byte, error) {
+	if _, err := s.peek(); err != nil {
+		return nil, err
+	}
+
+	s.capture = s.capture[:0]
+	s.captureAt = s.pos
+	s.capturing = true
+
+	err := s.skipValue()
+
+	s.capturing = false
+	// On errors, capture keeps the bytes consumed so far for resync.
+	s.capture = append(s.capture, s.buf[s.captureAt:s.pos]...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s.capture, nil
+}
+
+// resync moves to the line after the one where the value that raw failed to materialize starts.
+// The bytes of the value that were consumed after that line are scanned again.
+func (s *scanner) resync() {
+	i := bytes.IndexByte(s.capture, '\n')
+	if i < 0 {
+		s.skipLine()
+
+		return
+	}
+
+	consumed := s.capture[i+1:]
+	rest := s.buf[s.pos:s.end]
+
+	buf := make([]byte, max(scanBufferSize, len(consumed)+len(rest)))
+	n := copy(buf, consumed)
+
+	s.offset += int64(s.pos - n)
+	s.end = n + copy(buf[n:], rest)
+	s.buf, s.pos = buf, 0
+}
+
+// skipValue consumes the next value, including all nested values if it's an object or array.
+func (s *scanner) skipValue() error {
+	c, err := s.peek()
+	if err != nil {
+		return err
+	}
+
+	switch c {
+	case '{':
+		return s.skipContainer('}')
+	case '[':
+		return s.skipContainer(']')
+	case '"':
+		s.pos++
+
+		return s.skipString()
+	case 't':
+		return s.literal("true")
+	case 'f':
+		return s.literal("false")
+	case 'n':
+		return s.literal("null")
+	default:
+		return s.skipNumber()
+	}
+}
+
+// skipContainer consumes an object or array, depending on its end delimiter.
+func (s *scanner) skipContainer(end byte) (err error) {
+	if s.depth++; s.depth > maxScanDepth {
+		return fmt.Errorf("%w: exceeded max depth at offset %d", ErrMalformed, s.offset+int64(s.pos))
+	}
+
+	defer func() { s.depth-- }()
+
+	// consume the starting delimiter
+	s.pos++
+
+	var more bool
+
+	for i := 0; ; i++ {
+		if more, err = s.more(end, i); err != nil || !more {
+			return
+		}
+
+		if end == '}' {
+			if err = s.expect('"'); err != nil {
+				return
+			}
+
+			if err = s.skipString(); err != nil {
+				return
+			}
+
+			if err = s.expect(':'); err != nil {
+				return
+			}
+		}
+
+		if err = s.skipValue(); err != nil {
+			return
+		}
+	}
+}
+
+// skipString consumes the rest of a string whose opening quote has been consumed.
+func (s *scanner) skipString() error {
+	for {
+		i := s.pos
+		for i < s.end && s.buf[i] >= 0x20 && s.buf[i] != '"' && s.buf[i] != '\\' {
+			i++
+		}
+
+		s.pos = i
+
+		if i == s.end {
+			if !s.fill() {
+				return s.endErr()
+			}
+
+			continue
+		}
+
+		switch s.buf[i] {
+		case '"':
+			s.pos++
+
+			return nil
+		case '\\':
+			s.pos++
+
+			if err := s.skipEscape(); err != nil {
+				return err
+			}
+		default:
+			return s.unexpected()
+		}
+	}
+}
+
+// skipEscape consumes an escape sequence whose backslash has been consumed.
+func (s *scanner) skipEscape() error {
+	c, err := s.readByte()
+	if err != nil {
+		return err
+	}
+
+	switch c {
+	case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+		return nil
+	case 'u':
+		for range 4 {
+			if c, err = s.readByte(); err != nil {
+				return err
+			}
+
+			if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+				s.pos--
+
+				return s.unexpected()
+			}
+		}
+
+		return nil
+	default:
+		s.pos--
+
+		return s.unexpected()
+	}
+}
+
+func (s *scanner) literal(word string) error {
+	for i := range len(word) {
+		c, err := s.readByte()
+		if err != nil {
+			return err
+		}
+
+		if c != word[i] {
+			s.pos--
+
+			return s.unexpected()
+		}
+	}
+
+	return nil
+}
+
+// skipNumber consumes a number, i.e. an optional minus sign, an integer without leading zeros,
+// an optional fraction and an optional exponent.
+func (s *scanner) skipNumber() error {
+	if c, _, _ := s.peekRaw(); c == '-' {
+		s.pos++
+	}
+
+	first, n, err := s.digits()
+	if err != nil {
+		return err
+	} else if n == 0 {
+		return s.unexpected()
+	} else if first == '0' && n > 1 {
+		return fmt.Errorf("%w: leading zero in number at offset %d", ErrMalformed, s.offset+int64(s.pos-n))
+	}
+
+	c, ok, err := s.peekRaw()
+	if err != nil || !ok {
+		return err
+	}
+
+	if c == '.' {
+		s.pos++
+
+		if _, n, err = s.digits(); err != nil {
+			return err
+		} else if n == 0 {
+			return s.unexpected()
+		}
+
+		if c, ok, err = s.peekRaw(); err != nil || !ok {
+			return err
+		}
+	}
+
+	if c == 'e' || c == 'E' {
+		s.pos++
+
+		if c, _, _ = s.peekRaw(); c == '+' || c == '-' {
+			s.pos++
+		}
+
+		if _, n, err = s.digits(); err != nil {
+			return err
+		} else if n == 0 {
+			return s.unexpected()
+		}
+	}
+
+	return nil
+}
+
+// digits consumes decimal digits, and returns the first of them and how many there are.
+func (s *scanner) digits() (first byte, n int, err error) {
+	for {
+		c, ok, err := s.peekRaw()
+		if err != nil || !ok || c < '0' || c > '9' {
+			return first, n, err
+		}
+
+		if n == 0 {
+			first = c
+		}
+
+		s.pos++
+		n++
+	}
+}
+
+// readKey consumes a key and the colon after it. The raw bytes of the key, without quotes, are kept in s.key,
+// and escaped reports whether they contain escape sequences.
+func (s *scanner) readKey() (escaped bool, err error) {
+	if err = s.expect('"'); err != nil {
+		return
+	}
+
+	s.key = s.key[:0]
+
+	for {
+		i := s.pos
+		for i < s.end && s.buf[i] >= 0x20 && s.buf[i] != '"' && s.buf[i] != '\\' {
+			i++
+		}
+
+		s.key = append(s.key, s.buf[s.pos:i]...)
+		s.pos = i
+
+		if i == s.end {
+			if !s.fill() {
+				return escaped, s.endErr()
+			}
+
+			continue
+		}
+
+		switch s.buf[i] {
+		case '"':
+			s.pos++
+
+			return escaped, s.expect(':')
+		case '\\':
+			escaped = true
+
+			if err = s.readEscape(); err != nil {
+				return
+			}
+		default:
+			return escaped, s.unexpected()
+		}
+	}
+}
+
+// readEscape appends an escape sequence of a key to s.key. It's validated when the key is decoded.
+func (s *scanner) readEscape() error {
+	n := 2
+
+	for i := 0; i < n; i++ {
+		c, err := s.readByte()
+		if err != nil {
+			return err
+		}
+
+		if i == 1 && c == 'u' {
+			n += 4
+		}
+
+		s.key = append(s.key, c)
+	}
+
+	return nil
+}
+
+// keyString decodes the key that was last read.
+func (s *scanner) keyString(escaped bool) (string, error) {
+	if !escaped {
+		return string(s.key), nil
+	}
+
+	var key string
+
+	quoted := make([]byte, 0, len(s.key)+2)
+	quoted = append(append(append(quoted, '"'), s.key...), '"')
+
+	if err := json.Unmarshal(quoted, &key); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+
+	return key, nil
+}
+
+// keyIs reports whether the key that was last read is key, without allocating unless the key has escape sequences.
+func (s *scanner) keyIs(key string, escaped bool) (bool, error) {
+	if !escaped {
+		return string(s.key) == key, nil
+	}
+
+	decoded, err := s.keyString(escaped)
+
+	return decoded == key, err
+}
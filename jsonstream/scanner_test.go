@@ -0,0 +1,195 @@
+package jsonstream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanner(t *testing.T) {
+	contents := `
+	{
+		"skipped": {"s": "x\"y\\zé😀", "n": [-0.5e+10, 0, 12, 1E3, true, false, null, {}, []]},
+		"a\/b": "escaped key",
+		"num": -12.5e-1,
+		"obj": {"k": [1, {"v": "deep"}]},
+		"last": 0
+	}
+	`
+
+	tests := map[string]any{
+		`.["a/b"]`:       "escaped key",
+		".num":           -1.25,
+		".obj.k[1].v":    "deep",
+		".obj.k[-2]":     float64(1),
+		".last":          float64(0),
+		".skipped.s":     "x\"y\\zé😀",
+		".skipped.n[0]":  -0.5e10,
+		".skipped.n[2]":  float64(12),
+		"/skipped/n/3":   float64(1000),
+		".skipped.n[6]":  nil,
+		".skipped.n[-1]": []any{},
+		".skipped.n[-2]": map[string]any{},
+		".skipped.n[-4]": false,
+	}
+
+	for path, expected := range tests {
+		// Reading a byte at a time splits every token, escape sequence and value across buffers.
+		angler, err := NewAngler(iotest.OneByteReader(strings.NewReader(contents)), path)
+		require.NoError(t, err)
+
+		var value any
+
+		require.NoError(t, angler.LandInto(context.Background(), &value), "path %q", path)
+		assert.Equal(t, expected, value, "path %q", path)
+	}
+}
+
+func TestScannerMalformed(t *testing.T) {
+	for _, contents := range []string{
+		`{"a": tru, "b": 1}`,
+		`{"a": 01, "b": 1}`,
+		`{"a": 1., "b": 1}`,
+		`{"a": -, "b": 1}`,
+		`{"a": 1e, "b": 1}`,
+		`{"a": "\x", "b": 1}`,
+		`{"a": "\u12g4", "b": 1}`,
+		"{\"a\": \"\t\", \"b\": 1}",
+		`{"a": [1,], "b": 1}`,
+		`{"a": [1 2], "b": 1}`,
+		`{"a": {"k" 1}, "b": 1}`,
+		`{"a": {"k": 1,}, "b": 1}`,
+		`{"a": {1: 1}, "b": 1}`,
+		`{"a": [}, "b": 1}`,
+		`{"a": 1 "b": 1}`,
+		`{"a": "open`,
+		`{"a": [[[`,
+	} {
+		for _, stream := range []io.Reader{strings.NewReader(contents), iotest.OneByteReader(strings.NewReader(contents))} {
+			angler, err := NewAngler(stream, ".b")
+			require.NoError(t, err)
+
+			_, err = angler.Land(context.Background())
+			assert.ErrorIs(t, err, ErrMalformed, "skipped values of %q should be validated", contents)
+		}
+	}
+
+	angler, err := NewAngler(strings.NewReader("["+strings.Repeat("[", maxScanDepth+1)), ".[1]")
+	require.NoError(t, err)
+
+	_, err = angler.Land(context.Background())
+	assert.ErrorIs(t, err, ErrMalformed, "nesting should be bounded")
+
+	angler, _ = NewAngler(iotest.TimeoutReader(iotest.OneByteReader(strings.NewReader(`{"a": 1, "b": 2}`))), ".b")
+
+	_, err = angler.Land(context.Background())
+	assert.ErrorIs(t, err, iotest.ErrTimeout, "read errors should be returned as they are")
+}
+
+// tokenLand is how Angler used to find values, by walking the tokens of [json.Decoder], for comparison in benchmarks.
+func tokenLand(stream io.Reader, keys ...string) (any, error) {
+	dec := json.NewDecoder(stream)
+
+	for _, key := range keys {
+		// consume the starting '{' token
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+
+		for {
+			if !dec.More() {
+				return nil, fmt.Errorf("target key %q: %w", key, ErrNotFound)
+			}
+
+			t, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			if IsTargetKey(t, key) {
+				break
+			}
+
+			if err = tokenSkip(dec); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return dec.Token()
+}
+
+// tokenSkip consumes the next value of dec, including all nested values if it's an object or array.
+func tokenSkip(dec *json.Decoder) error {
+	depth := 0
+
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		if IsStartingDelim(t) {
+			depth++
+		} else if IsEndingDelim(t) {
+			depth--
+		}
+
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// BenchmarkLand finds values at the end of the registry responses that scaffold reads.
+func BenchmarkLand(b *testing.B) {
+	tests := []struct {
+		name string
+		file string
+		keys []string
+	}{
+		{name: "GitHub", file: "github.golangci-lint.resp.json", keys: []string{"reactions", "total_count"}},
+		{name: "NPM", file: "npm.aws-cdk-lib.resp.json", keys: []string{"readmeFilename"}},
+		{name: "PyPI", file: "pypi.black.resp.json", keys: []string{"vulnerabilities"}},
+	}
+
+	for _, tc := range tests {
+		template, err := os.ReadFile("../scaffold/testdata/" + tc.file)
+		require.NoError(b, err)
+
+		contents := strings.ReplaceAll(string(template), "{{.}}", "1.0.0")
+		path := "." + strings.Join(tc.keys, ".")
+
+		b.Run(tc.name+"/Scanner", func(b *testing.B) {
+			b.SetBytes(int64(len(contents)))
+			b.ReportAllocs()
+
+			for b.Loop() {
+				angler, err := NewAngler(strings.NewReader(contents), path)
+				require.NoError(b, err)
+
+				var raw json.RawMessage
+
+				require.NoError(b, angler.LandInto(context.Background(), &raw))
+			}
+		})
+
+		b.Run(tc.name+"/Decoder", func(b *testing.B) {
+			b.SetBytes(int64(len(contents)))
+			b.ReportAllocs()
+
+			for b.Loop() {
+				_, err := tokenLand(strings.NewReader(contents), tc.keys...)
+				require.NoError(b, err)
+			}
+		})
+	}
+}
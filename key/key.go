@@ -1,13 +1,19 @@
 package key
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
 
 	"github.com/zalando/go-keyring"
+
+	"github.com/kxue43/cli-toolkit/secret"
 )
 
 type (
@@ -15,44 +21,62 @@ type (
 		service string
 		user    string
 	}
+
+	// FileProvider keeps the encryption key base64 encoded in a file.
+	// It is meant for machines without a native credentials store and protects no better than the file's permissions.
+	FileProvider struct {
+		path string
+	}
 )
 
 func NewKeyringProvider(service, user string) KeyringProvider {
 	return KeyringProvider{service: service, user: user}
 }
 
-func (p KeyringProvider) Write(key []byte) (err error) {
-	var encoded string
+// ErrNoKey means that no encryption key has been saved yet.
+var ErrNoKey = errors.New("no encryption key has been saved yet")
 
-	encoded, err = keyring.Get(p.service, p.user)
-	if err != nil && !errors.Is(err, keyring.ErrNotFound) {
+// Read copies the saved encryption key into key without generating one, e.g. to check that it's accessible.
+// Non-nil returned error wraps [ErrNoKey] if there is none.
+func (p KeyringProvider) Read(key []byte) error {
+	encoded, err := keyring.Get(p.service, p.user)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return fmt.Errorf("%w in the keyring", ErrNoKey)
+	} else if err != nil {
 		return fmt.Errorf("failed to retrieve encryption key: secret exists but cannot be read: %s", err.Error())
-	} else if errors.Is(err, keyring.ErrNotFound) {
-		internal := make([]byte, len(key))
+	}
 
-		encoded, err = generateKey(internal)
-		if err != nil {
-			return err
-		}
+	raw := []byte(encoded)
+	defer secret.Wipe(raw)
 
-		err = keyring.Set(p.service, p.user, encoded)
-		if err != nil {
-			return fmt.Errorf("failed to save newly generated encryption key: %s", err.Error())
-		}
+	if err = decodeKey(raw, key); err != nil {
+		return fmt.Errorf("saved encryption key is invalid: %w", err)
+	}
 
-		copy(key, internal)
+	return nil
+}
 
-		return nil
+// Write copies the encryption key into key, which is typically backed by a [secret.Buffer].
+// A key is generated and saved if there is none yet.
+// Intermediate copies of the key are wiped, except for the base64 encoded string returned by the keyring library.
+func (p KeyringProvider) Write(key []byte) error {
+	if err := p.Read(key); !errors.Is(err, ErrNoKey) {
+		return err
 	}
 
-	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	internal := secret.NewBuffer(len(key))
+	defer internal.Destroy()
+
+	encoded, err := generateKey(internal.Bytes())
 	if err != nil {
-		return fmt.Errorf("failed to base64 decode saved encryption key: %s", err.Error())
-	} else if len(decoded) != len(key) {
-		return fmt.Errorf("saved encryption key has length %d while the input byte slice has length %d", len(decoded), len(key))
+		return err
+	}
+
+	if err = keyring.Set(p.service, p.user, encoded); err != nil {
+		return fmt.Errorf("failed to save newly generated encryption key: %s", err.Error())
 	}
 
-	copy(key, decoded)
+	copy(key, internal.Bytes())
 
 	return nil
 }
@@ -64,3 +88,92 @@ func generateKey(key []byte) (string, error) {
 
 	return base64.StdEncoding.EncodeToString(key), nil
 }
+
+// decodeKey base64 decodes encoded into key without leaving other copies of the key behind.
+func decodeKey(encoded, key []byte) error {
+	decoded := secret.NewBuffer(base64.StdEncoding.DecodedLen(len(encoded)))
+	defer decoded.Destroy()
+
+	n, err := base64.StdEncoding.Decode(decoded.Bytes(), encoded)
+	if err != nil {
+		return fmt.Errorf("failed to base64 decode: %s", err.Error())
+	} else if n != len(key) {
+		return fmt.Errorf("decoded key has length %d while the input byte slice has length %d", n, len(key))
+	}
+
+	copy(key, decoded.Bytes())
+
+	return nil
+}
+
+func NewFileProvider(path string) FileProvider {
+	return FileProvider{path: path}
+}
+
+// Read copies the encryption key from the file into key without generating one, e.g. to check that it's accessible.
+// The file must not be accessible by group or others.
+// Non-nil returned error wraps [ErrNoKey] if the file doesn't exist.
+func (p FileProvider) Read(key []byte) error {
+	info, err := os.Stat(p.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w in %q", ErrNoKey, p.path)
+	} else if err != nil {
+		return fmt.Errorf("failed to locate encryption key file %q: %s", p.path, err.Error())
+	}
+
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return fmt.Errorf("encryption key file %q has permissions %s, but it must not be accessible by group or others", p.path, perm)
+	}
+
+	encoded, err := os.ReadFile(p.path)
+	if err != nil {
+		return fmt.Errorf("failed to read encryption key file %q: %s", p.path, err.Error())
+	}
+
+	defer secret.Wipe(encoded)
+
+	if err = decodeKey(bytes.TrimSpace(encoded), key); err != nil {
+		return fmt.Errorf("encryption key file %q is invalid: %w", p.path, err)
+	}
+
+	return nil
+}
+
+// Write reads the encryption key from the file, or generates one and saves it to the file if the file doesn't exist yet.
+// The file must not be accessible by group or others.
+func (p FileProvider) Write(key []byte) error {
+	if err := p.Read(key); !errors.Is(err, ErrNoKey) {
+		return err
+	}
+
+	return p.create(key)
+}
+
+func (p FileProvider) create(key []byte) (err error) {
+	internal := secret.NewBuffer(len(key))
+	defer internal.Destroy()
+
+	encoded, err := generateKey(internal.Bytes())
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(p.path), 0700); err != nil {
+		return fmt.Errorf("failed to create directory for encryption key file %q: %s", p.path, err.Error())
+	}
+
+	fd, err := os.OpenFile(p.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create encryption key file %q: %s", p.path, err.Error())
+	}
+
+	defer func() { _ = fd.Close() }()
+
+	if _, err = io.WriteString(fd, encoded+"\n"); err != nil {
+		return fmt.Errorf("failed to save newly generated encryption key to %q: %s", p.path, err.Error())
+	}
+
+	copy(key, internal.Bytes())
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package key
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zalando/go-keyring"
+
+	"github.com/kxue43/cli-toolkit/secret"
+)
+
+func TestKeyringProvider(t *testing.T) {
+	keyring.MockInit()
+
+	p := NewKeyringProvider("service", "user")
+
+	first := secret.NewBuffer(32)
+	defer first.Destroy()
+
+	assert.ErrorIs(t, p.Read(first.Bytes()), ErrNoKey, "reading should not generate a key")
+
+	require.NoError(t, p.Write(first.Bytes()), "should be able to generate and save a key")
+
+	assert.NotEqual(t, make([]byte, 32), first.Bytes(), "a key should have been generated")
+
+	second := secret.NewBuffer(32)
+	defer second.Destroy()
+
+	require.NoError(t, p.Write(second.Bytes()), "should be able to read the saved key")
+
+	assert.Equal(t, first.Bytes(), second.Bytes(), "the saved key should be returned on subsequent calls")
+
+	assert.Error(t, p.Write(make([]byte, 16)), "a key of the wrong length should be rejected")
+}
+
+func TestFileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "key")
+
+	p := NewFileProvider(path)
+
+	first := secret.NewBuffer(32)
+	defer first.Destroy()
+
+	assert.ErrorIs(t, p.Read(first.Bytes()), ErrNoKey, "reading should not generate a key")
+
+	_, err := os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "reading should not create the key file")
+
+	require.NoError(t, p.Write(first.Bytes()), "should be able to generate and save a key")
+
+	info, err := os.Stat(path)
+	require.NoError(t, err, "the key file should have been created")
+
+	read := secret.NewBuffer(32)
+	defer read.Destroy()
+
+	require.NoError(t, p.Read(read.Bytes()), "should be able to read the saved key")
+	assert.Equal(t, first.Bytes(), read.Bytes())
+
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "the key file should only be accessible by its owner")
+
+	second := secret.NewBuffer(32)
+	defer second.Destroy()
+
+	require.NoError(t, p.Write(second.Bytes()), "should be able to read the saved key")
+
+	assert.Equal(t, first.Bytes(), second.Bytes(), "the saved key should be returned on subsequent calls")
+
+	require.NoError(t, os.Chmod(path, 0640))
+
+	assert.Error(t, p.Write(second.Bytes()), "a key file accessible by group should be rejected")
+}
@@ -270,19 +270,9 @@ func landFromPublicEndpoint(ctx context.Context, url, path string) (value string
 		return "", fmt.Errorf("failed to GET from endpoint %q, status code %d", url, rc)
 	}
 
-	angler, err := jsonstream.NewAngler(resp.Body, path)
+	value, err = jsonstream.LandAs[string](ctx, resp.Body, path)
 	if err != nil {
-		return "", fmt.Errorf("error from jsonstream.NewAngler: %w", err)
-	}
-
-	v, err := angler.Land(ctx)
-	if err != nil {
-		return "", fmt.Errorf(`failed to get the value at the %q path from the response body: %w`, path, err)
-	}
-
-	value, ok := v.(string)
-	if !ok {
-		return "", fmt.Errorf(`the value at the %q path is not string`, path)
+		return "", fmt.Errorf(`failed to get the string at the %q path from the response body: %w`, path, err)
 	}
 
 	return value, nil
@@ -0,0 +1,93 @@
+// Package secret provides a buffer type for key material and decrypted secrets.
+// Buffers are locked into memory where the platform allows it, wiped on destruction, and never printed.
+package secret
+
+import (
+	"fmt"
+	"runtime"
+)
+
+type (
+	// Buffer holds sensitive bytes. The zero value is an empty, unlocked buffer.
+	// Locking works at page granularity and Go heap pages may be shared with other allocations,
+	// so it is a best-effort guard against swapping rather than a guarantee.
+	Buffer struct {
+		data   []byte
+		locked bool
+	}
+)
+
+const redacted = "[REDACTED]"
+
+// NewBuffer allocates a zero-filled buffer of size bytes and tries to lock it into memory.
+func NewBuffer(size int) *Buffer {
+	b := Buffer{data: make([]byte, size)}
+
+	b.locked = size > 0 && lock(b.data) == nil
+
+	return &b
+}
+
+// FromBytes copies p into a new buffer and wipes p.
+func FromBytes(p []byte) *Buffer {
+	b := NewBuffer(len(p))
+
+	copy(b.data, p)
+	Wipe(p)
+
+	return b
+}
+
+// Wipe overwrites p with zeros.
+func Wipe(p []byte) {
+	clear(p)
+	runtime.KeepAlive(p)
+}
+
+// Bytes returns the underlying bytes, which stay valid until Destroy is called.
+func (b *Buffer) Bytes() []byte {
+	return b.data
+}
+
+func (b *Buffer) Len() int {
+	return len(b.data)
+}
+
+// Locked reports whether the buffer is locked into memory.
+func (b *Buffer) Locked() bool {
+	return b.locked
+}
+
+// Destroy wipes and unlocks the buffer. It is safe to call Destroy on a nil or destroyed buffer.
+func (b *Buffer) Destroy() {
+	if b == nil || b.data == nil {
+		return
+	}
+
+	Wipe(b.data)
+
+	if b.locked {
+		_ = unlock(b.data)
+		b.locked = false
+	}
+
+	b.data = nil
+}
+
+func (b *Buffer) String() string {
+	return redacted
+}
+
+func (b *Buffer) GoString() string {
+	return redacted
+}
+
+// Format redacts the buffer for every verb, so that its contents never reach logs through the fmt package.
+func (b *Buffer) Format(f fmt.State, verb rune) {
+	_, _ = f.Write([]byte(redacted))
+}
+
+// MarshalText redacts the buffer for encoders such as encoding/json.
+func (b *Buffer) MarshalText() ([]byte, error) {
+	return []byte(redacted), nil
+}
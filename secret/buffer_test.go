@@ -0,0 +1,64 @@
+package secret
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func assertWiped(t *testing.T, p []byte) {
+	t.Helper()
+
+	assert.Equal(t, make([]byte, len(p)), p, "bytes should have been wiped")
+}
+
+func TestDestroy(t *testing.T) {
+	b := NewBuffer(32)
+
+	require.Equal(t, 32, b.Len())
+
+	p := b.Bytes()
+	copy(p, "0123456789abcdef0123456789abcdef")
+
+	b.Destroy()
+
+	assertWiped(t, p)
+
+	assert.Equal(t, 0, b.Len(), "a destroyed buffer should be empty")
+
+	assert.False(t, b.Locked(), "a destroyed buffer should be unlocked")
+
+	b.Destroy()
+
+	var nilBuffer *Buffer
+
+	nilBuffer.Destroy()
+}
+
+func TestFromBytes(t *testing.T) {
+	src := []byte("session-token")
+
+	b := FromBytes(src)
+	defer b.Destroy()
+
+	assert.Equal(t, []byte("session-token"), b.Bytes())
+
+	assertWiped(t, src)
+}
+
+func TestRedaction(t *testing.T) {
+	b := FromBytes([]byte("hunter2"))
+	defer b.Destroy()
+
+	for _, format := range []string{"%s", "%v", "%+v", "%#v", "%x", "%q"} {
+		assert.Equal(t, redacted, fmt.Sprintf(format, b), "format %s should be redacted", format)
+	}
+
+	encoded, err := json.Marshal(struct{ Key *Buffer }{Key: b})
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{"Key": "[REDACTED]"}`, string(encoded))
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package secret
+
+import "errors"
+
+func lock([]byte) error {
+	return errors.ErrUnsupported
+}
+
+func unlock([]byte) error {
+	return errors.ErrUnsupported
+}
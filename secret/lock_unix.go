@@ -0,0 +1,13 @@
+//go:build unix
+
+package secret
+
+import "golang.org/x/sys/unix"
+
+func lock(p []byte) error {
+	return unix.Mlock(p)
+}
+
+func unlock(p []byte) error {
+	return unix.Munlock(p)
+}
@@ -0,0 +1,601 @@
+// Package securestore implements a directory of encrypted secrets with expiry.
+// Each secret lives in its own file whose name is derived from a hash of the secret's name and its expiration time.
+// File contents are encrypted via AES-GCM with the encryption key supplied by a [KeyProvider].
+package securestore
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/kxue43/cli-toolkit/cipher"
+	"github.com/kxue43/cli-toolkit/secret"
+)
+
+type (
+	// Store saves secrets as encrypted files in a single directory.
+	Store struct {
+		logger Logger
+		cipher *cipher.AesGcm
+		dir    string
+		// Margin shortens the lifetime of every entry when reading.
+		// Entries that expire within Margin from now are treated as expired.
+		Margin time.Duration
+		// Skew is how far the local clock is ahead of the clock that expirations are based on, e.g. that of a server.
+		// It's subtracted from the local time whenever expiry is decided. See [Store.SetSkew].
+		Skew time.Duration
+		// Keep is how many live entries Get keeps per name, from the latest expiration. Zero means one.
+		Keep int
+		// Observe, if set, is called for every entry file that is evicted or cannot be decrypted, e.g. to collect metrics.
+		Observe func(Event)
+	}
+
+	// Event is something that happened to an entry file.
+	Event int
+
+	// metadata is saved unencrypted in metaFileName, because it holds nothing secret.
+	metadata struct {
+		Skew time.Duration `json:"Skew"`
+	}
+
+	// Entry describes a live secret without revealing its value.
+	Entry struct {
+		Expiration time.Time
+		Name       string
+	}
+
+	Logger interface {
+		Printf(string, ...any)
+	}
+
+	KeyProvider interface {
+		Write([]byte) error
+	}
+
+	entryFile struct {
+		expiration time.Time
+		filePath   string
+	}
+
+	entryFileSlice []*entryFile
+)
+
+var (
+	ErrInit     = errors.New("secure store initialization failure")
+	ErrSave     = errors.New("failed to save secret")
+	ErrNotFound = errors.New("secret not found")
+
+	fileNameRegex = regexp.MustCompile(`^[0-9a-f]{7}-(\d+)$`)
+)
+
+const (
+	// EntryEvicted means an entry file was deleted because it expired, was superseded or had an invalid name.
+	EntryEvicted Event = iota
+	// EntryUndecryptable means an entry file could not be decrypted, e.g. because the encryption key changed.
+	EntryUndecryptable
+)
+
+const (
+	// metaFileName does not match fileNameRegex, so that it's never mistaken for an entry.
+	metaFileName = "meta.json"
+	// minSkewChange is how much the skew must change to be saved.
+	// Skews measured from Date headers have a resolution of one second, so smaller changes are noise.
+	minSkewChange = time.Second
+)
+
+// Len, Less and Swap sort entry files from the latest expiration to the earliest.
+func (es entryFileSlice) Len() int {
+	return len(es)
+}
+
+func (es entryFileSlice) Less(i, j int) bool {
+	return es[i].expiration.After(es[j].expiration)
+}
+
+func (es entryFileSlice) Swap(i, j int) {
+	es[i], es[j] = es[j], es[i]
+}
+
+func getPrefix(s string) string {
+	h := sha1.Sum([]byte(s))
+
+	return hex.EncodeToString(h[:])[0:7]
+}
+
+func encodeToFileName(name string, ts time.Time) string {
+	return fmt.Sprintf("%s-%s", getPrefix(name), strconv.FormatInt(ts.Unix(), 10))
+}
+
+func decodeFromFileName(fileName string) (ts time.Time, err error) {
+	matches := fileNameRegex.FindStringSubmatch(fileName)
+	if matches == nil {
+		return ts, fmt.Errorf("%q is not of the right file name format", fileName)
+	}
+
+	unixSec, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return ts, fmt.Errorf("numeric portion of %q is not a valid Unix second", fileName)
+	}
+
+	return time.Unix(unixSec, 0), nil
+}
+
+// seal prepends the length-prefixed name to value, so that a file can be attributed to its name after decryption.
+func seal(name string, value []byte) []byte {
+	plaintext := make([]byte, 0, binary.MaxVarintLen64+len(name)+len(value))
+
+	plaintext = binary.AppendUvarint(plaintext, uint64(len(name)))
+	plaintext = append(plaintext, name...)
+
+	return append(plaintext, value...)
+}
+
+// unseal is the reverse of seal. The returned value shares memory with plaintext.
+func unseal(plaintext []byte) (name string, value []byte, err error) {
+	size, n := binary.Uvarint(plaintext)
+	if n <= 0 || uint64(len(plaintext)-n) < size {
+		return "", nil, errors.New("malformed secret header")
+	}
+
+	return string(plaintext[n : n+int(size)]), plaintext[n+int(size):], nil
+}
+
+// New creates the directory dir if it doesn't exist yet.
+// The encryption key is kept in a [secret.Buffer] until Close is called.
+// Non-nil returned error wraps [ErrInit].
+func New(dir string, kp KeyProvider, logger Logger) (*Store, error) {
+	key := secret.NewBuffer(cipher.KeySize)
+
+	err := kp.Write(key.Bytes())
+	if err != nil {
+		key.Destroy()
+
+		return nil, fmt.Errorf("%w: failed to obtain encryption key: %s", ErrInit, err.Error())
+	}
+
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		if err = os.MkdirAll(dir, 0750); err != nil {
+			key.Destroy()
+
+			return nil, fmt.Errorf("%w: failed to create directory %q", ErrInit, dir)
+		}
+	} else if err != nil {
+		key.Destroy()
+
+		return nil, fmt.Errorf("%w: failed to locate directory %q: %s", ErrInit, dir, err.Error())
+	} else if !info.IsDir() {
+		key.Destroy()
+
+		return nil, fmt.Errorf("%w: %q is already a file", ErrInit, dir)
+	} else if perm := info.Mode().Perm(); perm&0007 != 0 {
+		key.Destroy()
+
+		return nil, fmt.Errorf("%w: directory %q has permissions %s, but it must not be accessible by others", ErrInit, dir, perm)
+	}
+
+	s := Store{logger: logger, cipher: cipher.NewAesGcm(key), dir: dir}
+
+	s.loadMetadata()
+
+	return &s, nil
+}
+
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Now returns the current time of the clock that expirations are based on, i.e. the local time corrected by Skew.
+func (s *Store) Now() time.Time {
+	return time.Now().Add(-s.Skew)
+}
+
+// SetSkew sets Skew and saves it in the store's metadata, so that it's applied by later instances of the store as well.
+// Changes of one second or less are ignored, so that the metadata file isn't rewritten on every measurement.
+func (s *Store) SetSkew(skew time.Duration) error {
+	if change := skew - s.Skew; change <= minSkewChange && change >= -minSkewChange {
+		return nil
+	}
+
+	s.Skew = skew
+
+	raw, err := json.Marshal(metadata{Skew: skew})
+	if err != nil {
+		return fmt.Errorf("failed to serialize metadata: %w", err)
+	}
+
+	if err = os.WriteFile(filepath.Join(s.dir, metaFileName), raw, 0600); err != nil {
+		return fmt.Errorf("failed to save metadata: %w", err)
+	}
+
+	return nil
+}
+
+// loadMetadata restores the fields saved by SetSkew. A missing or invalid metadata file leaves them at their zero values.
+func (s *Store) loadMetadata() {
+	fullPath := filepath.Join(s.dir, metaFileName)
+
+	raw, err := os.ReadFile(filepath.Clean(fullPath))
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		s.logger.Printf("Failed to read metadata file %q: %s.\n", fullPath, err)
+
+		return
+	}
+
+	var meta metadata
+
+	if err = json.Unmarshal(raw, &meta); err != nil {
+		s.logger.Printf("Ignoring invalid metadata file %q: %s.\n", fullPath, err)
+
+		return
+	}
+
+	s.Skew = meta.Skew
+}
+
+// Close wipes the encryption key. The store must not be used afterwards.
+func (s *Store) Close() {
+	s.cipher.Destroy()
+}
+
+// Import moves the entries found in dir into the store, e.g. when the store has been relocated.
+// If keep is not nil, only the entries whose names it accepts are moved.
+// Entry files that the store cannot read, e.g. those of an older format, and entries that keep rejects are deleted,
+// because they would never be returned.
+// Entries that the store already has are left in dir. dir is removed if it ends up empty.
+// It returns the number of entries moved. A missing dir is not an error.
+func (s *Store) Import(dir string, keep func(name string) bool) (int, error) {
+	items, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to read directory %q: %w", dir, err)
+	}
+
+	var (
+		moved           int
+		src, dest, name string
+	)
+
+	for _, item := range items {
+		if item.IsDir() || !fileNameRegex.MatchString(item.Name()) {
+			continue
+		}
+
+		src = filepath.Join(dir, item.Name())
+
+		if name, err = s.owner(src); err != nil || (keep != nil && !keep(name)) {
+			s.deleteFile(src, "unimportable")
+
+			continue
+		}
+
+		dest = filepath.Join(s.dir, item.Name())
+
+		if _, err = os.Lstat(dest); err == nil {
+			continue
+		}
+
+		if err = os.Rename(src, dest); err != nil {
+			return moved, fmt.Errorf("failed to move %q into %q: %w", item.Name(), s.dir, err)
+		}
+
+		moved++
+	}
+
+	// Fails harmlessly if anything is left behind.
+	_ = os.Remove(dir)
+
+	return moved, nil
+}
+
+// Put saves value under name for the duration of ttl.
+// Non-nil returned error wraps [ErrSave].
+func (s *Store) Put(name string, value []byte, ttl time.Duration) error {
+	return s.PutUntil(name, value, s.Now().Add(ttl))
+}
+
+// PutUntil saves value under name until expiration.
+// Non-nil returned error wraps [ErrSave].
+func (s *Store) PutUntil(name string, value []byte, expiration time.Time) error {
+	plaintext := seal(name, value)
+	defer secret.Wipe(plaintext)
+
+	encrypted, err := s.cipher.Encrypt(plaintext)
+	if err != nil {
+		return fmt.Errorf("%w: failed to encrypt before saving: %s", ErrSave, err.Error())
+	}
+
+	filePath := filepath.Join(s.dir, encodeToFileName(name, expiration))
+
+	if err = os.WriteFile(filePath, encrypted, 0600); err != nil {
+		return fmt.Errorf("%w: failed to write to disk: %s", ErrSave, err.Error())
+	}
+
+	return nil
+}
+
+// Get returns the value of the live entry under name with the latest expiration.
+// The caller should destroy the returned buffer after use.
+// Expired entries under name, and live ones beyond the latest Keep, are deleted along the way.
+// Non-nil returned error wraps [ErrNotFound] if there is no live entry under name.
+func (s *Store) Get(name string) (value *secret.Buffer, err error) {
+	actives := s.activeFiles(name)
+
+	for i, item := range actives {
+		if i < max(s.Keep, 1) {
+			continue
+		}
+
+		s.deleteFile(item.filePath, "older")
+	}
+
+	if len(actives) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrNotFound, name)
+	}
+
+	plaintext, err := s.open(actives[0].filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer plaintext.Destroy()
+
+	_, raw, err := unseal(plaintext.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse file %q: %w", actives[0].filePath, err)
+	}
+
+	return secret.FromBytes(raw), nil
+}
+
+// Delete removes all entries under name.
+// Files that cannot be decrypted are removed as well, since they are of no use to anyone.
+func (s *Store) Delete(name string) error {
+	files, err := s.filesOf(name)
+	if err != nil {
+		return err
+	}
+
+	var (
+		errs  []error
+		owner string
+	)
+
+	for _, item := range files {
+		if owner, err = s.owner(item.filePath); err == nil && owner != name {
+			continue
+		}
+
+		if err = os.Remove(item.filePath); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("failed to delete %q: %w", item.filePath, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// List returns the live entries of the store, sorted by name.
+// Entries that cannot be decrypted are skipped.
+func (s *Store) List() ([]Entry, error) {
+	items, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %q: %w", s.dir, err)
+	}
+
+	var (
+		expiration time.Time
+		name       string
+	)
+
+	cutoff := s.Now().Add(s.Margin)
+	entries := make([]Entry, 0, len(items))
+
+	for _, item := range items {
+		expiration, err = decodeFromFileName(item.Name())
+		if err != nil || item.IsDir() || expiration.Before(cutoff) {
+			continue
+		}
+
+		name, err = s.owner(filepath.Join(s.dir, item.Name()))
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, Entry{Name: name, Expiration: expiration})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Name == entries[j].Name {
+			return entries[i].Expiration.After(entries[j].Expiration)
+		}
+
+		return entries[i].Name < entries[j].Name
+	})
+
+	return entries, nil
+}
+
+// GC deletes all expired entries of the store, regardless of their names.
+// Margin is not applied, so that entries that are about to expire are kept.
+func (s *Store) GC() error {
+	items, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read directory %q: %w", s.dir, err)
+	}
+
+	var (
+		errs       []error
+		expiration time.Time
+	)
+
+	now := s.Now()
+
+	for _, item := range items {
+		expiration, err = decodeFromFileName(item.Name())
+		if err != nil || item.IsDir() || !expiration.Before(now) {
+			continue
+		}
+
+		fullPath := filepath.Join(s.dir, item.Name())
+
+		if err = os.Remove(fullPath); err == nil {
+			s.observe(EntryEvicted)
+		} else if !os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("failed to delete expired %q: %w", fullPath, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Verify tries to decrypt every entry, including expired ones, and returns the errors of those that fail.
+// Such entries are left in place, and they are deleted when the store comes across them.
+func (s *Store) Verify() (entries int, errs []error, err error) {
+	items, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read directory %q: %w", s.dir, err)
+	}
+
+	for _, item := range items {
+		if item.IsDir() || !fileNameRegex.MatchString(item.Name()) {
+			continue
+		}
+
+		entries++
+
+		if _, err = s.owner(filepath.Join(s.dir, item.Name())); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return entries, errs, nil
+}
+
+// filesOf returns all files whose name prefix matches that of name.
+// Because the prefix is a truncated hash, some of the files may belong to other names.
+func (s *Store) filesOf(name string) (entryFileSlice, error) {
+	pattern := filepath.Join(s.dir, fmt.Sprintf(`%s-*`, getPrefix(name)))
+
+	fullPaths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file globbing pattern: %w", err)
+	}
+
+	var expiration time.Time
+
+	files := make(entryFileSlice, 0, len(fullPaths))
+
+	for _, fullPath := range fullPaths {
+		expiration, err = decodeFromFileName(filepath.Base(fullPath))
+		if err != nil {
+			s.deleteFile(fullPath, "invalid")
+
+			continue
+		}
+
+		files = append(files, &entryFile{expiration: expiration, filePath: fullPath})
+	}
+
+	return files, nil
+}
+
+// activeFiles returns the live files of name, sorted from the latest expiration to the earliest.
+// Files of name that are almost expired are deleted.
+func (s *Store) activeFiles(name string) entryFileSlice {
+	files, err := s.filesOf(name)
+	if err != nil {
+		s.logger.Printf("%s\n", err)
+
+		return nil
+	}
+
+	var owner string
+
+	cutoff := s.Now().Add(s.Margin)
+	actives := make(entryFileSlice, 0, len(files))
+
+	for _, item := range files {
+		if item.expiration.Before(cutoff) {
+			s.deleteFile(item.filePath, "almost expired")
+
+			continue
+		}
+
+		owner, err = s.owner(item.filePath)
+		if err != nil {
+			s.logger.Printf("%s\n", err)
+
+			continue
+		} else if owner != name {
+			continue
+		}
+
+		actives = append(actives, item)
+	}
+
+	sort.Sort(actives)
+
+	return actives
+}
+
+// open returns the decrypted contents of a file. The caller should destroy the returned buffer after use.
+func (s *Store) open(fullPath string) (plaintext *secret.Buffer, err error) {
+	contents, err := os.ReadFile(filepath.Clean(fullPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %q: %w", fullPath, err)
+	}
+
+	plaintext, err = s.cipher.Decrypt(contents)
+	if err != nil {
+		s.observe(EntryUndecryptable)
+
+		return nil, fmt.Errorf("failed to decrypt file %q: %w", fullPath, err)
+	}
+
+	return plaintext, nil
+}
+
+// owner returns the name that a file was saved under.
+func (s *Store) owner(fullPath string) (name string, err error) {
+	plaintext, err := s.open(fullPath)
+	if err != nil {
+		return "", err
+	}
+
+	defer plaintext.Destroy()
+
+	name, _, err = unseal(plaintext.Bytes())
+	if err != nil {
+		return "", fmt.Errorf("failed to parse file %q: %w", fullPath, err)
+	}
+
+	return name, nil
+}
+
+func (s *Store) deleteFile(fullPath string, desc string) {
+	if os.Remove(fullPath) != nil {
+		s.logger.Printf("Failed to delete %s file %q.\n", desc, fullPath)
+
+		return
+	}
+
+	s.observe(EntryEvicted)
+}
+
+func (s *Store) observe(event Event) {
+	if s.Observe != nil {
+		s.Observe(event)
+	}
+}
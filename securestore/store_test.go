@@ -0,0 +1,285 @@
+package securestore
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kxue43/cli-toolkit/key"
+)
+
+// discardLogger stands in for storetest.DiscardLogger, which can't be imported here.
+var discardLogger = log.New(io.Discard, "", 0)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+
+	kp := key.NewFileProvider(filepath.Join(t.TempDir(), "key"))
+
+	store, err := New(filepath.Join(t.TempDir(), "store"), kp, discardLogger)
+	require.NoError(t, err, "should be able to create a store in a temp directory")
+
+	return store
+}
+
+func countFiles(t *testing.T, dir string) int {
+	t.Helper()
+
+	items, err := os.ReadDir(dir)
+	require.NoError(t, err, "should be able to read the store directory")
+
+	return len(items)
+}
+
+func TestPutGet(t *testing.T) {
+	store := newTestStore(t)
+
+	err := store.Put("github", []byte("token-1"), time.Hour)
+	require.NoError(t, err, "should be able to put a secret")
+
+	value, err := store.Get("github")
+	require.NoError(t, err, "should be able to get a secret that was just put")
+
+	assert.Equal(t, []byte("token-1"), value.Bytes())
+
+	_, err = store.Get("npm")
+	assert.ErrorIs(t, err, ErrNotFound, "getting a missing secret should fail with ErrNotFound")
+
+	err = store.Put("github", []byte("token-2"), 2*time.Hour)
+	require.NoError(t, err, "should be able to put a newer secret under the same name")
+
+	value, err = store.Get("github")
+	require.NoError(t, err, "should be able to get the newer secret")
+
+	assert.Equal(t, []byte("token-2"), value.Bytes(), "the entry with the latest expiration should win")
+
+	assert.Equal(t, 1, countFiles(t, store.Dir()), "the older entry should have been deleted by Get")
+}
+
+func TestMargin(t *testing.T) {
+	store := newTestStore(t)
+
+	store.Margin = 10 * time.Minute
+
+	err := store.Put("docker", []byte("secret"), 5*time.Minute)
+	require.NoError(t, err, "should be able to put a secret")
+
+	_, err = store.Get("docker")
+	assert.ErrorIs(t, err, ErrNotFound, "secrets expiring within the margin should not be returned")
+
+	assert.Equal(t, 0, countFiles(t, store.Dir()), "secrets expiring within the margin should be deleted by Get")
+}
+
+func TestListDelete(t *testing.T) {
+	store := newTestStore(t)
+
+	expiration := time.Now().Add(time.Hour)
+
+	require.NoError(t, store.PutUntil("b", []byte("2"), expiration))
+	require.NoError(t, store.PutUntil("a", []byte("1"), expiration))
+
+	entries, err := store.List()
+	require.NoError(t, err, "should be able to list entries")
+
+	assert.Equal(t, []Entry{
+		{Name: "a", Expiration: time.Unix(expiration.Unix(), 0)},
+		{Name: "b", Expiration: time.Unix(expiration.Unix(), 0)},
+	}, entries)
+
+	require.NoError(t, store.Delete("a"), "should be able to delete an entry")
+
+	_, err = store.Get("a")
+	assert.ErrorIs(t, err, ErrNotFound, "a deleted secret should not be found")
+
+	value, err := store.Get("b")
+	require.NoError(t, err, "deleting one name should not affect the others")
+
+	assert.Equal(t, []byte("2"), value.Bytes())
+}
+
+func TestGC(t *testing.T) {
+	store := newTestStore(t)
+
+	require.NoError(t, store.PutUntil("expired", []byte("x"), time.Now().Add(-time.Minute)))
+	require.NoError(t, store.Put("live", []byte("y"), time.Hour))
+
+	require.NoError(t, store.GC(), "should be able to garbage collect")
+
+	assert.Equal(t, 1, countFiles(t, store.Dir()), "only the live entry should remain after garbage collection")
+
+	_, err := store.Get("live")
+	assert.NoError(t, err, "the live entry should survive garbage collection")
+}
+
+func TestTamperedFile(t *testing.T) {
+	store := newTestStore(t)
+
+	require.NoError(t, store.Put("tampered", []byte("x"), time.Hour))
+
+	items, err := os.ReadDir(store.Dir())
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+
+	fullPath := filepath.Join(store.Dir(), items[0].Name())
+
+	require.NoError(t, os.WriteFile(fullPath, []byte("garbage that is long enough to hold a nonce"), 0600))
+
+	_, err = store.Get("tampered")
+	assert.ErrorIs(t, err, ErrNotFound, "entries that fail to decrypt should be treated as missing")
+
+	require.NoError(t, store.Delete("tampered"), "should be able to delete undecryptable entries")
+
+	assert.Equal(t, 0, countFiles(t, store.Dir()))
+}
+
+func TestClose(t *testing.T) {
+	store := newTestStore(t)
+
+	store.Close()
+
+	err := store.Put("closed", []byte("x"), time.Hour)
+	assert.ErrorIs(t, err, ErrSave, "a closed store should not be able to encrypt, because its key has been wiped")
+}
+
+func TestImport(t *testing.T) {
+	kp := key.NewFileProvider(filepath.Join(t.TempDir(), "key"))
+
+	old, err := New(filepath.Join(t.TempDir(), "old"), kp, discardLogger)
+	require.NoError(t, err, "should be able to create the old store")
+
+	require.NoError(t, old.Put("github", []byte("token"), time.Hour))
+	require.NoError(t, old.Put("stale", []byte("token"), time.Hour))
+	require.NoError(t, os.WriteFile(filepath.Join(old.Dir(), "notes.txt"), []byte("keep me"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(old.Dir(), encodeToFileName("plain", time.Now().Add(time.Hour))), []byte("not encrypted"), 0600))
+
+	store, err := New(filepath.Join(t.TempDir(), "new"), kp, discardLogger)
+	require.NoError(t, err, "should be able to create the new store")
+
+	moved, err := store.Import(old.Dir(), func(name string) bool { return name != "stale" })
+	require.NoError(t, err, "should be able to import entries")
+
+	assert.Equal(t, 1, moved, "only entry files that are kept should be moved")
+
+	value, err := store.Get("github")
+	require.NoError(t, err, "the imported entry should be readable")
+
+	assert.Equal(t, []byte("token"), value.Bytes())
+
+	assert.Equal(t, 1, countFiles(t, old.Dir()), "files other than entries should be left behind, and unreadable and rejected entries deleted")
+
+	require.NoError(t, os.Remove(filepath.Join(old.Dir(), "notes.txt")))
+
+	moved, err = store.Import(old.Dir(), nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, 0, moved, "nothing is left to move")
+
+	_, err = os.Stat(old.Dir())
+	assert.True(t, os.IsNotExist(err), "an empty directory should be removed after import")
+
+	moved, err = store.Import(old.Dir(), nil)
+	require.NoError(t, err, "importing from a missing directory should not fail")
+
+	assert.Equal(t, 0, moved)
+}
+
+func TestSkew(t *testing.T) {
+	kp := key.NewFileProvider(filepath.Join(t.TempDir(), "key"))
+
+	dir := filepath.Join(t.TempDir(), "store")
+
+	store, err := New(dir, kp, discardLogger)
+	require.NoError(t, err, "should be able to create a store in a temp directory")
+
+	require.NoError(t, store.PutUntil("github", []byte("token-1"), time.Now().Add(30*time.Minute)))
+
+	// The local clock is an hour behind the server that issued the expiration.
+	require.NoError(t, store.SetSkew(-time.Hour), "should be able to save the skew")
+
+	entries, err := store.List()
+	require.NoError(t, err)
+	assert.Empty(t, entries, "an entry that has expired by the server's clock should not be listed")
+
+	store.Close()
+
+	store, err = New(dir, kp, discardLogger)
+	require.NoError(t, err, "should be able to reopen the store")
+
+	defer store.Close()
+
+	assert.Equal(t, -time.Hour, store.Skew, "the skew should be restored from the metadata")
+
+	metaPath := filepath.Join(dir, metaFileName)
+
+	before, err := os.Stat(metaPath)
+	require.NoError(t, err)
+
+	require.NoError(t, os.Chtimes(metaPath, before.ModTime(), before.ModTime().Add(-time.Minute)))
+	require.NoError(t, store.SetSkew(-time.Hour+500*time.Millisecond))
+
+	after, err := os.Stat(metaPath)
+	require.NoError(t, err)
+
+	assert.Equal(t, -time.Hour, store.Skew, "a change within a second should be ignored")
+	assert.Equal(t, before.ModTime().Add(-time.Minute), after.ModTime(), "a change within a second should not be saved")
+
+	_, err = store.Get("github")
+	require.ErrorIs(t, err, ErrNotFound, "an entry that has expired by the server's clock should not be returned")
+
+	require.NoError(t, store.Put("npm", []byte("token-2"), time.Hour))
+
+	n, errs, err := store.Verify()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "the metadata file should not be taken for an entry")
+	assert.Empty(t, errs)
+
+	require.NoError(t, store.GC())
+	assert.Equal(t, 2, countFiles(t, dir), "GC should keep the metadata file")
+}
+
+func TestObserve(t *testing.T) {
+	store := newTestStore(t)
+
+	events := map[Event]int{}
+	store.Observe = func(event Event) { events[event]++ }
+
+	require.NoError(t, store.PutUntil("expired", []byte("x"), time.Now().Add(-time.Minute)))
+	require.NoError(t, store.Put("tampered", []byte("y"), time.Hour))
+
+	require.NoError(t, store.GC())
+
+	items, err := os.ReadDir(store.Dir())
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+
+	require.NoError(t, os.WriteFile(filepath.Join(store.Dir(), items[0].Name()), []byte("garbage that is long enough to hold a nonce"), 0600))
+
+	_, err = store.Get("tampered")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	assert.Equal(t, 1, events[EntryEvicted], "the expired entry should be reported as evicted")
+	assert.Equal(t, 1, events[EntryUndecryptable], "the tampered entry should be reported as undecryptable")
+}
+
+func TestKeep(t *testing.T) {
+	store := newTestStore(t)
+
+	store.Keep = 2
+
+	for i := range 3 {
+		require.NoError(t, store.Put("github", []byte(fmt.Sprintf("token-%d", i)), time.Duration(i+1)*time.Hour))
+	}
+
+	value, err := store.Get("github")
+	require.NoError(t, err)
+
+	assert.Equal(t, []byte("token-2"), value.Bytes(), "the entry with the latest expiration should be returned")
+	assert.Equal(t, 2, countFiles(t, store.Dir()), "only the latest Keep entries should be kept")
+}
@@ -0,0 +1,38 @@
+// Package storetest provides the fixtures shared by tests of packages that use a [securestore.Store].
+// The tests of package securestore itself can't use it, because it imports that package.
+package storetest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kxue43/cli-toolkit/key"
+	"github.com/kxue43/cli-toolkit/securestore"
+)
+
+// DiscardLogger is a logger that writes nowhere.
+type DiscardLogger struct{}
+
+func (DiscardLogger) Printf(string, ...any) {}
+
+func (DiscardLogger) Println(...any) {}
+
+// KeyProvider returns a key provider that keeps its key in a temporary directory of t.
+// The key is generated the first time it's asked for.
+func KeyProvider(t testing.TB) key.FileProvider {
+	t.Helper()
+
+	return key.NewFileProvider(filepath.Join(t.TempDir(), "key"))
+}
+
+// NewStore returns a store in a temporary directory of t, encrypted by a key of [KeyProvider].
+func NewStore(t testing.TB) *securestore.Store {
+	t.Helper()
+
+	store, err := securestore.New(filepath.Join(t.TempDir(), "store"), KeyProvider(t), DiscardLogger{})
+	require.NoError(t, err, "should be able to create a store in a temp directory")
+
+	return store
+}
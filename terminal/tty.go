@@ -2,6 +2,7 @@ package terminal
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"log"
 	"sync"
@@ -9,13 +10,25 @@ import (
 
 type (
 	TTY struct {
-		dest   io.ReadWriter
-		logger *log.Logger
-		buf    bytes.Buffer
-		mux    sync.Mutex // Guards the whole struct
+		dest     io.ReadWriter
+		logger   *log.Logger
+		buf      bytes.Buffer
+		headless bool
+		mux      sync.Mutex // Guards the whole struct
+	}
+
+	writeOnly struct {
+		io.Writer
 	}
 )
 
+// ErrNoTTY is returned when reading from a headless [TTY].
+var ErrNoTTY = errors.New("no terminal to read user input from")
+
+func (writeOnly) Read([]byte) (int, error) {
+	return 0, ErrNoTTY
+}
+
 func NewTTY(dest io.ReadWriter, prefix string, flag int) *TTY {
 	tty := TTY{dest: dest}
 
@@ -24,6 +37,21 @@ func NewTTY(dest io.ReadWriter, prefix string, flag int) *TTY {
 	return &tty
 }
 
+// NewHeadless returns a TTY for processes without a controlling terminal, e.g. in containers and CI.
+// Messages and logs go to dest, usually stderr, and reading fails with [ErrNoTTY].
+func NewHeadless(dest io.Writer, prefix string, flag int) *TTY {
+	tty := NewTTY(writeOnly{Writer: dest}, prefix, flag)
+
+	tty.headless = true
+
+	return tty
+}
+
+// Interactive reports whether user input can be read from t.
+func (t *TTY) Interactive() bool {
+	return !t.headless
+}
+
 func (t *TTY) Read(p []byte) (n int, err error) {
 	t.mux.Lock()
 	defer t.mux.Unlock()
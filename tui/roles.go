@@ -0,0 +1,301 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/kxue43/cli-toolkit/creds"
+)
+
+type (
+	rolePicker struct {
+		help        help.Model
+		filter      textinput.Model
+		roles       []creds.Role
+		expirations map[string]time.Time
+		now         time.Time
+		// matches are indices of roles that match the filter, best first.
+		matches []int
+		// index is the highlighted position in matches.
+		index  int
+		height int
+		chosen int
+	}
+
+	rolePickerKeyMap struct{}
+)
+
+// ErrNoRoleSelected is returned by [PickRole] when the user quits without picking a role.
+var ErrNoRoleSelected = errors.New("no role was selected")
+
+// Letters are typed into the filter, so only arrow and control keys move the highlight.
+var pickerKeys = struct {
+	up     key.Binding
+	down   key.Binding
+	choose key.Binding
+	quit   key.Binding
+}{
+	up: key.NewBinding(
+		key.WithKeys("up", "ctrl+p"),
+		key.WithHelp("↑/ctrl+p", "move up"),
+	),
+	down: key.NewBinding(
+		key.WithKeys("down", "ctrl+n"),
+		key.WithHelp("↓/ctrl+n", "move down"),
+	),
+	choose: key.NewBinding(
+		key.WithKeys("enter"),
+		key.WithHelp("↵", "assume role"),
+	),
+	quit: key.NewBinding(
+		key.WithKeys("esc", "ctrl+c"),
+		key.WithHelp("esc", "quit"),
+	),
+}
+
+var (
+	cachedStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("78"))
+	expiredStyle = lipgloss.NewStyle().Faint(true)
+)
+
+func (rolePickerKeyMap) ShortHelp() []key.Binding {
+	return []key.Binding{pickerKeys.up, pickerKeys.down, pickerKeys.choose, pickerKeys.quit}
+}
+
+func (rolePickerKeyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{pickerKeys.up, pickerKeys.down},
+		{pickerKeys.choose, pickerKeys.quit},
+	}
+}
+
+// fuzzyScore reports whether the runes of pattern appear in target in order, ignoring case.
+// Matches that are consecutive or at the start of words score higher.
+func fuzzyScore(pattern, target string) (score int, ok bool) {
+	p := []rune(strings.ToLower(pattern))
+	t := []rune(strings.ToLower(target))
+
+	matched, prev := 0, -2
+
+	for i := 0; i < len(t) && matched < len(p); i++ {
+		if t[i] != p[matched] {
+			continue
+		}
+
+		score++
+
+		if i == prev+1 {
+			score += 2
+		}
+
+		if i == 0 || !unicode.IsLetter(t[i-1]) && !unicode.IsDigit(t[i-1]) {
+			score += 3
+		}
+
+		matched++
+		prev = i
+	}
+
+	if matched < len(p) {
+		return 0, false
+	}
+
+	return score, true
+}
+
+func newRolePicker(roles []creds.Role, expirations map[string]time.Time, now time.Time) rolePicker {
+	ti := textinput.New()
+	ti.Placeholder = "type to filter"
+	ti.Prompt = "> "
+	ti.CharLimit = 128
+	ti.Focus()
+
+	m := rolePicker{
+		help:        help.New(),
+		filter:      ti,
+		roles:       roles,
+		expirations: expirations,
+		now:         now,
+		chosen:      -1,
+	}
+
+	m.match()
+
+	return m
+}
+
+// match recomputes the roles matching the filter and highlights the best one.
+func (m *rolePicker) match() {
+	type scored struct {
+		index int
+		score int
+	}
+
+	pattern := m.filter.Value()
+	candidates := make([]scored, 0, len(m.roles))
+
+	for i, role := range m.roles {
+		if score, ok := fuzzyScore(pattern, role.Name+" "+role.RoleArn); ok {
+			candidates = append(candidates, scored{index: i, score: score})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+
+	m.matches = m.matches[:0]
+
+	for _, c := range candidates {
+		m.matches = append(m.matches, c.index)
+	}
+
+	m.index = 0
+}
+
+func (m rolePicker) status(role creds.Role) string {
+	expiration, ok := m.expirations[role.CacheKey()]
+	if !ok || !expiration.After(m.now) {
+		return expiredStyle.Render("not cached")
+	}
+
+	return cachedStyle.Render(fmt.Sprintf("cached, expires in %s", expiration.Sub(m.now).Round(time.Minute)))
+}
+
+func (m rolePicker) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m rolePicker) View() string {
+	if m.chosen >= 0 {
+		return ""
+	}
+
+	var b strings.Builder
+
+	b.WriteString("Pick a role to assume.\n\n")
+	b.WriteString(m.filter.View())
+	b.WriteString("\n\n")
+
+	// Show a window of matches around the highlighted one.
+	rows := 15
+	if m.height > 0 {
+		rows = max(m.height-8, 1)
+	}
+
+	start := max(0, min(m.index-rows/2, len(m.matches)-rows))
+	end := min(len(m.matches), start+rows)
+
+	nameWidth := 0
+
+	for _, i := range m.matches[start:end] {
+		nameWidth = max(nameWidth, len(m.roles[i].Name))
+	}
+
+	for pos := start; pos < end; pos++ {
+		role := m.roles[m.matches[pos]]
+		line := fmt.Sprintf("%-*s  %s", nameWidth, role.Name, role.RoleArn)
+
+		if pos == m.index {
+			b.WriteString(highlightedStyle.Render("> " + line))
+		} else {
+			b.WriteString("  " + line)
+		}
+
+		b.WriteString("  ")
+		b.WriteString(m.status(role))
+		b.WriteRune('\n')
+	}
+
+	if len(m.matches) == 0 {
+		b.WriteString("  No matching roles.\n")
+	}
+
+	b.WriteRune('\n')
+	b.WriteString(m.help.View(rolePickerKeyMap{}))
+	b.WriteRune('\n')
+
+	return b.String()
+}
+
+func (m rolePicker) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.help.Width = msg.Width
+		m.height = msg.Height
+
+		return m, nil
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, pickerKeys.quit):
+			return m, tea.Quit
+		case key.Matches(msg, pickerKeys.choose):
+			if len(m.matches) == 0 {
+				return m, nil
+			}
+
+			m.chosen = m.matches[m.index]
+
+			return m, tea.Quit
+		case key.Matches(msg, pickerKeys.up):
+			if m.index > 0 {
+				m.index--
+			}
+
+			return m, nil
+		case key.Matches(msg, pickerKeys.down):
+			if m.index < len(m.matches)-1 {
+				m.index++
+			}
+
+			return m, nil
+		default:
+		}
+	}
+
+	pattern := m.filter.Value()
+
+	m.filter, cmd = m.filter.Update(msg)
+
+	if m.filter.Value() != pattern {
+		m.match()
+	}
+
+	return m, cmd
+}
+
+// PickRole lets the user pick one of roles on the terminal behind in and out.
+// expirations maps the [creds.Role.CacheKey] of roles to the expiration of their cached credentials, which is shown next to each role.
+// The returned error is [ErrNoRoleSelected] if the user quits.
+func PickRole(in io.Reader, out io.Writer, roles []creds.Role, expirations map[string]time.Time) (creds.Role, error) {
+	if len(roles) == 0 {
+		return creds.Role{}, errors.New("there are no roles to pick from")
+	}
+
+	p := tea.NewProgram(newRolePicker(roles, expirations, time.Now()), tea.WithInput(in), tea.WithOutput(out))
+
+	final, err := p.Run()
+	if err != nil {
+		return creds.Role{}, fmt.Errorf("role picker failed: %w", err)
+	}
+
+	m, ok := final.(rolePicker)
+	if !ok || m.chosen < 0 {
+		return creds.Role{}, ErrNoRoleSelected
+	}
+
+	return m.roles[m.chosen], nil
+}
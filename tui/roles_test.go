@@ -0,0 +1,65 @@
+package tui
+
+import (
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kxue43/cli-toolkit/creds"
+)
+
+func TestFuzzyScore(t *testing.T) {
+	_, ok := fuzzyScore("pad", "prod-admin")
+	assert.True(t, ok, "runes in order should match")
+
+	_, ok = fuzzyScore("dap", "prod-admin")
+	assert.False(t, ok, "runes out of order should not match")
+
+	_, ok = fuzzyScore("PROD", "prod-admin")
+	assert.True(t, ok, "matching should ignore case")
+
+	_, ok = fuzzyScore("", "prod-admin")
+	assert.True(t, ok, "an empty pattern should match everything")
+
+	consecutive, _ := fuzzyScore("admin", "prod-admin")
+	scattered, _ := fuzzyScore("admin", "xaxdxmxixn")
+	assert.Greater(t, consecutive, scattered, "consecutive matches should score higher")
+
+	wordStart, _ := fuzzyScore("a", "prod-admin")
+	inside, _ := fuzzyScore("a", "dat")
+	assert.Greater(t, wordStart, inside, "matches at word starts should score higher")
+}
+
+func TestRolePicker(t *testing.T) {
+	now := time.Now()
+
+	roles := []creds.Role{
+		{Name: "dev", RoleArn: "arn:aws:iam::111111111111:role/dev"},
+		{Name: "prod-admin", RoleArn: "arn:aws:iam::222222222222:role/admin"},
+		{Name: "prod-readonly", RoleArn: "arn:aws:iam::222222222222:role/readonly"},
+	}
+
+	expirations := map[string]time.Time{
+		roles[1].CacheKey(): now.Add(42 * time.Minute),
+	}
+
+	var model tea.Model = newRolePicker(roles, expirations, now)
+
+	assert.Len(t, model.(rolePicker).matches, 3, "all roles should be listed without a filter")
+	assert.Contains(t, model.View(), "cached, expires in 42m0s", "cache status should be shown")
+
+	for _, r := range "prodro" {
+		model, _ = model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+	}
+
+	require.Equal(t, []int{2, 1}, model.(rolePicker).matches, "the best match should come first")
+
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyDown})
+	model, cmd := model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+
+	require.NotNil(t, cmd, "choosing a role should quit the program")
+	assert.Equal(t, 1, model.(rolePicker).chosen, "the highlighted role should be chosen")
+}